@@ -0,0 +1,567 @@
+package shed
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/client"
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+// A Participant is a user taking part in a session.
+type Participant = comms.Participant
+
+// A Role determines what a participant may do to a document.
+type Role = comms.Role
+
+const (
+	Owner  = comms.OWNER
+	Editor = comms.EDITOR
+	Viewer = comms.VIEWER
+)
+
+// An Error is a request the server or client rejected.
+type Error = comms.ErrorMessage
+
+// A Change is one of the changes made to a document, with who made it and
+// when.
+type Change = comms.Change
+
+// A Revision is a document as it was at some point in its history.
+type Revision = comms.Revision
+
+// A Checkpoint names a revision of a document.
+type Checkpoint = comms.Checkpoint
+
+// A Span is a stretch of a document written by one author in one change.
+type Span = comms.Span
+
+// A DocumentEvent reports that a document was created, deleted or renamed.
+type DocumentEvent = comms.DocumentEvent
+
+// Actions reported by a DocumentEvent.
+const (
+	Created = comms.CREATED
+	Deleted = comms.DELETED
+	Renamed = comms.RENAMED
+)
+
+// A Client edits a shared document on a server. Set its fields before calling
+// Connect; the callbacks are called from a single goroutine, one at a time.
+type Client struct {
+	// Name and Colour identify the client to other participants.
+	Name   string
+	Colour string
+
+	// Document, Secret and Token are presented to servers that require
+	// authentication. Secret and Token may both be empty if it doesn't.
+	Document string
+	Secret   string
+	Token    string
+
+	// HeartbeatInterval, if set, is how often the server is pinged. If it
+	// stays silent for three intervals, OnError is called and the client
+	// disconnects.
+	HeartbeatInterval time.Duration
+
+	// Logger, if set, records what the client does. Every message exchanged
+	// with the server is logged at debug level.
+	Logger *slog.Logger
+
+	// OnRemote is called with each change made by another participant, after
+	// it has been applied to the client's copy of the document. It is also
+	// called with the changes that undo one the server rejected.
+	OnRemote func(op Operation)
+
+	// OnAck is called each time the server accepts a change passed to Submit.
+	OnAck func()
+
+	// OnParticipants is called whenever someone joins, leaves or changes role.
+	OnParticipants func(ps []Participant)
+
+	// OnError is called when the server or client rejects a request.
+	OnError func(err error)
+
+	// OnDocuments is called when someone else creates, deletes or renames a
+	// document under the prefix last passed to Documents.
+	OnDocuments func(e DocumentEvent)
+
+	// edits serializes local changes, so that they reach the server in the
+	// order they were applied to doc.
+	edits sync.Mutex
+
+	mu           sync.Mutex
+	doc          Document
+	exchange     client.Exchange
+	editor       comms.Transport
+	inner        client.Client
+	self         int
+	participants []Participant
+
+	// reload is closed once the document has been fetched again after the
+	// server rejected a change. Until then doc still holds the change, so
+	// remote changes are left to the document and local ones wait.
+	reload chan struct{}
+
+	// ready receives the outcome of loading the document.
+	ready chan error
+
+	// requests serializes requests that the server answers, such as for the
+	// document's history, whose answers are sent to reply.
+	requests sync.Mutex
+	reply    chan comms.Message
+}
+
+// Connect joins the session on server, loads the document and starts
+// delivering callbacks.
+func (c *Client) Connect(server Transport) error {
+	if c.Secret != "" || c.Token != "" {
+		c.inner.Auth = &comms.Authenticate{Document: c.Document, Secret: c.Secret, Token: c.Token}
+	}
+	c.inner.HeartbeatInterval = c.HeartbeatInterval
+	c.inner.Logger = c.Logger
+	editor, inner := comms.Pipe()
+	c.editor = editor
+	c.inner.Attach(inner)
+	if err := c.inner.Connect(server); err != nil {
+		editor.Close()
+		return err
+	}
+	c.ready = make(chan error, 1)
+	go c.receive()
+	if err := c.editor.Send(comms.JoinSession{Name: c.Name, Colour: c.Colour}); err != nil {
+		return err
+	}
+	if err := c.editor.Send(comms.FetchDocument{}); err != nil {
+		return err
+	}
+	return <-c.ready
+}
+
+// Submit applies a local change to the document and sends it to the server.
+// If the server rejects a change, the document is fetched again, undoing that
+// change and those made after it, and Submit waits until it has been.
+func (c *Client) Submit(op Operation) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.edits.Unlock()
+	if err := c.doc.Apply(op); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	m := c.exchange.Send(op)
+	c.mu.Unlock()
+	return c.send([]comms.OpMessage{m})
+}
+
+// Edit calls edit with the client's copy of the document and submits the
+// changes it returns, made to that text in order. No remote change is applied
+// in between, so edit can work from the whole text, as with Diff.
+func (c *Client) Edit(edit func(text string) []Operation) error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.edits.Unlock()
+	ops := edit(c.doc.Text())
+	var msgs []comms.OpMessage
+	var err error
+	for _, op := range ops {
+		if err = c.doc.Apply(op); err != nil {
+			break
+		}
+		msgs = append(msgs, c.exchange.Send(op))
+	}
+	c.mu.Unlock()
+	if sendErr := c.send(msgs); sendErr != nil {
+		return sendErr
+	}
+	return err
+}
+
+// lock takes c.edits and c.mu to make a local change, first waiting for the
+// document to be fetched again if the server has rejected a change.
+func (c *Client) lock() error {
+	for {
+		c.edits.Lock()
+		c.mu.Lock()
+		reload := c.reload
+		if reload == nil {
+			return nil
+		}
+		c.mu.Unlock()
+		c.edits.Unlock()
+		select {
+		case <-reload:
+		case <-c.Done():
+			return Error{Code: comms.CONNECTION_LOST, Message: "lost connection to the server"}
+		}
+	}
+}
+
+// refetch fetches the document again after the server rejected a change,
+// which the client's copy still holds. The changes made after it never reach
+// the server, so they are lost with it.
+func (c *Client) refetch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reload != nil {
+		return
+	}
+	c.reload = make(chan struct{})
+	go func() {
+		// Local changes already applied go first, to be dropped by the
+		// inner client along with the rejected one.
+		c.edits.Lock()
+		defer c.edits.Unlock()
+		c.editor.Send(comms.FetchDocument{})
+	}()
+}
+
+// send passes local changes, already applied to doc, on to the server. It
+// mustn't be called with c.mu held, since the changes can only be taken once
+// the remote changes ahead of them have been applied.
+func (c *Client) send(msgs []comms.OpMessage) error {
+	for _, m := range msgs {
+		if err := c.editor.Send(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetRole asks the server to change a participant's role. Only owners may do
+// this; the change is reported through OnParticipants.
+func (c *Client) SetRole(id int, role Role) error {
+	return c.editor.Send(comms.SetRole{Id: id, Role: role})
+}
+
+// History returns the changes made to the document after revision from,
+// oldest first, and the document's current revision. At most limit changes
+// are returned, or all of them if limit is 0.
+func (c *Client) History(from, limit int) ([]Change, int, error) {
+	m, err := c.request(comms.FetchHistory{From: from, Limit: limit})
+	if err != nil {
+		return nil, 0, err
+	}
+	h := m.(*comms.History)
+	return h.Changes, h.Rev, nil
+}
+
+// Revision returns the document as it was after rev changes.
+func (c *Client) Revision(rev int) (Revision, error) {
+	m, err := c.request(comms.FetchRevision{Rev: rev})
+	if err != nil {
+		return Revision{}, err
+	}
+	return *m.(*comms.Revision), nil
+}
+
+// RevisionAt returns the document as it was at time t.
+func (c *Client) RevisionAt(t time.Time) (Revision, error) {
+	m, err := c.request(comms.FetchRevision{Time: t})
+	if err != nil {
+		return Revision{}, err
+	}
+	return *m.(*comms.Revision), nil
+}
+
+// Diff returns the document's text at revision from and the changes that
+// turned it into revision to.
+func (c *Client) Diff(from, to int) (string, []Change, error) {
+	m, err := c.request(comms.FetchDiff{From: from, To: to})
+	if err != nil {
+		return "", nil, err
+	}
+	d := m.(*comms.Diff)
+	return d.Text, d.Changes, nil
+}
+
+// Checkpoint names the document's current revision on the server, replacing
+// any checkpoint of the same name, and returns every checkpoint.
+func (c *Client) Checkpoint(name string) ([]Checkpoint, error) {
+	return c.checkpoint(comms.CreateCheckpoint{Name: name})
+}
+
+// CheckpointRevision names revision rev of the document, like Checkpoint.
+func (c *Client) CheckpointRevision(name string, rev int) ([]Checkpoint, error) {
+	return c.checkpoint(comms.CreateCheckpoint{Name: name, Rev: &rev})
+}
+
+// Checkpoints returns the document's checkpoints, oldest first.
+func (c *Client) Checkpoints() ([]Checkpoint, error) {
+	return c.checkpoint(comms.FetchCheckpoints{})
+}
+
+func (c *Client) checkpoint(m comms.Message) ([]Checkpoint, error) {
+	m, err := c.request(m)
+	if err != nil {
+		return nil, err
+	}
+	return m.(*comms.Checkpoints).Checkpoints, nil
+}
+
+// Revert restores the document to its text at revision rev and returns the
+// document's revision afterwards. The server makes the changes that restore
+// it, which reach the client like anyone else's, so OnRemote is called for
+// each before Revert returns.
+func (c *Client) Revert(rev int) (int, error) {
+	m, err := c.request(comms.Revert{Rev: rev})
+	if err != nil {
+		return 0, err
+	}
+	return m.(*comms.Reverted).Rev, nil
+}
+
+// RevertTo restores the document to the named checkpoint, like Revert.
+func (c *Client) RevertTo(checkpoint string) (int, error) {
+	m, err := c.request(comms.Revert{Checkpoint: checkpoint})
+	if err != nil {
+		return 0, err
+	}
+	return m.(*comms.Reverted).Rev, nil
+}
+
+// Blame returns the document's text on the server and the spans that cover
+// it, in order, saying who wrote each part.
+func (c *Client) Blame() (string, []Span, error) {
+	m, err := c.request(comms.FetchBlame{})
+	if err != nil {
+		return "", nil, err
+	}
+	b := m.(*comms.Blame)
+	return b.Text, b.Spans, nil
+}
+
+// Documents returns the names of the documents starting with prefix that the
+// client may open, in order. From then on, OnDocuments is called when someone
+// else creates, deletes or renames one. The server must host several
+// documents, which it only does if it authenticates clients.
+func (c *Client) Documents(prefix string) ([]string, error) {
+	return c.documents(comms.ListDocuments{Prefix: prefix})
+}
+
+// CreateDocument creates an empty document, failing if it exists already.
+func (c *Client) CreateDocument(name string) error {
+	_, err := c.documents(comms.CreateDocument{Name: name})
+	return err
+}
+
+// DeleteDocument deletes a document, disconnecting everyone editing it. Only
+// the document's owners may.
+func (c *Client) DeleteDocument(name string) error {
+	_, err := c.documents(comms.DeleteDocument{Name: name})
+	return err
+}
+
+// RenameDocument gives a document a new name, disconnecting everyone editing
+// it so that they can reopen it under the new one. Only the document's owners
+// may.
+func (c *Client) RenameDocument(from, to string) error {
+	_, err := c.documents(comms.RenameDocument{From: from, To: to})
+	return err
+}
+
+func (c *Client) documents(m comms.Message) ([]string, error) {
+	m, err := c.request(m)
+	if err != nil {
+		return nil, err
+	}
+	return m.(*comms.Documents).Names, nil
+}
+
+// request sends a request that the server answers and waits for the answer.
+func (c *Client) request(m comms.Message) (comms.Message, error) {
+	c.requests.Lock()
+	defer c.requests.Unlock()
+	reply := make(chan comms.Message, 1)
+	c.mu.Lock()
+	c.reply = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.reply = nil
+		c.mu.Unlock()
+	}()
+
+	if err := c.editor.Send(m); err != nil {
+		return nil, err
+	}
+	select {
+	case m := <-reply:
+		if e, ok := m.(*comms.ErrorMessage); ok {
+			return nil, *e
+		}
+		return m, nil
+	case <-c.Done():
+		return nil, Error{Code: comms.CONNECTION_LOST, Message: "lost connection to the server"}
+	}
+}
+
+// answer passes m to the request waiting for it, if there is one, and reports
+// whether there was.
+func (c *Client) answer(m comms.Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reply == nil {
+		return false
+	}
+	c.reply <- m
+	c.reply = nil
+	return true
+}
+
+// Text returns the client's copy of the document.
+func (c *Client) Text() string {
+	return c.doc.Text()
+}
+
+// Self returns the participant ID the server assigned to the client, once it
+// has joined.
+func (c *Client) Self() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.self
+}
+
+// Participants returns everyone currently in the session, ordered by ID.
+func (c *Client) Participants() []Participant {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.participants)
+}
+
+// Close leaves the session.
+func (c *Client) Close() error {
+	return c.editor.Close()
+}
+
+// Done returns a channel that is closed once the client has disconnected.
+func (c *Client) Done() <-chan struct{} {
+	return c.inner.Done()
+}
+
+func (c *Client) receive() {
+	for {
+		m, err := c.editor.Receive()
+		if err != nil {
+			c.loaded(err)
+			return
+		}
+		switch m := m.(type) {
+		case *comms.OpMessage:
+			c.mu.Lock()
+			op := c.exchange.Receive(m)
+			if c.reload != nil {
+				c.mu.Unlock()
+				continue
+			}
+			err := c.doc.Apply(op)
+			c.mu.Unlock()
+			if err != nil {
+				c.fail(err)
+			} else if c.OnRemote != nil {
+				c.OnRemote(op)
+			}
+		case *comms.Document:
+			// Changes received before the document are already part of it.
+			c.mu.Lock()
+			reload := c.reload
+			var ops []Operation
+			if reload != nil {
+				ops = Diff(c.doc.Text(), m.Text)
+			}
+			c.doc.reset(m.Text, m.Rev)
+			c.exchange.Reset()
+			c.reload = nil
+			c.mu.Unlock()
+			if reload != nil {
+				close(reload)
+				for _, op := range ops {
+					if c.OnRemote != nil {
+						c.OnRemote(op)
+					}
+				}
+			}
+			c.loaded(nil)
+		case *comms.AcknowledgeChange:
+			c.mu.Lock()
+			c.exchange.Ack()
+			c.mu.Unlock()
+			if c.OnAck != nil {
+				c.OnAck()
+			}
+		case *comms.History, *comms.Revision, *comms.Diff, *comms.Checkpoints, *comms.Reverted,
+			*comms.Blame, *comms.Documents:
+			c.answer(m)
+		case *comms.DocumentEvent:
+			if c.OnDocuments != nil {
+				c.OnDocuments(*m)
+			}
+		case *comms.ErrorMessage:
+			if m.Rejected() {
+				c.refetch()
+			}
+			switch m.Code {
+			case comms.NO_SUCH_REV, comms.INVALID, comms.NO_SUCH_DOC, comms.EXISTS:
+				c.answer(m)
+				continue
+			case comms.FORBIDDEN, comms.READ_ONLY, comms.RATE_LIMITED, comms.TOO_LARGE, comms.INTERNAL:
+				// These may answer a request, such as to revert, or a
+				// change, which nothing waits for.
+				if !m.Rejected() && c.answer(m) {
+					continue
+				}
+			}
+			c.loaded(*m)
+			c.fail(*m)
+		default:
+			c.updateParticipants(m)
+		}
+	}
+}
+
+func (c *Client) updateParticipants(m comms.Message) {
+	c.mu.Lock()
+	switch m := m.(type) {
+	case *comms.Roster:
+		c.self = m.Self
+		c.participants = m.Participants
+	case *comms.ParticipantJoined:
+		c.participants = append(c.participants, m.Participant)
+	case *comms.ParticipantLeft:
+		c.participants = slices.DeleteFunc(c.participants, func(p Participant) bool { return p.Id == m.Id })
+	case *comms.RoleChanged:
+		for i := range c.participants {
+			if c.participants[i].Id == m.Id {
+				c.participants[i].Role = m.Role
+			}
+		}
+	default:
+		c.mu.Unlock()
+		return
+	}
+	ps := slices.Clone(c.participants)
+	c.mu.Unlock()
+
+	if c.OnParticipants != nil {
+		c.OnParticipants(ps)
+	}
+}
+
+// loaded reports the outcome of loading the document to Connect, if it is
+// still waiting.
+func (c *Client) loaded(err error) {
+	select {
+	case c.ready <- err:
+	default:
+	}
+}
+
+func (c *Client) fail(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
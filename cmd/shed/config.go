@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadConfig sets the flags of a command named by a config file, except those
+// already given on the command line.
+//
+// Each line of the file is blank, a comment starting with #, a "[command]"
+// heading or a "name = value" setting. Settings under a heading apply only to
+// that command, and must name one of its flags. Settings before the first
+// heading apply to every command that has a flag of that name. Flags that may
+// be repeated can be set on several lines.
+func loadConfig(fs *flag.FlagSet, cmd, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	section := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if h, ok := strings.CutPrefix(line, "["); ok {
+			h, ok = strings.CutSuffix(h, "]")
+			if !ok {
+				return fmt.Errorf("%s:%d: expected [command]", path, n)
+			}
+			section = strings.TrimSpace(h)
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected name = value", path, n)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case section != "" && section != cmd:
+			continue
+		case fs.Lookup(name) == nil || name == "config":
+			if section == "" {
+				continue
+			}
+			return fmt.Errorf("%s:%d: %s has no flag %q", path, n, cmd, name)
+		case given[name]:
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+	}
+	return sc.Err()
+}
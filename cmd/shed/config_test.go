@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	// Given a config file with shared and per-command settings
+	path := filepath.Join(t.TempDir(), "shed.conf")
+	os.WriteFile(path, []byte(`
+# Shared by every command
+secret = s3cret
+colour = #ff0000
+
+[cat]
+listen = ignored
+
+[serve]
+listen = :7000
+data = /var/lib/shed
+`), 0o600)
+
+	// When the serve command loads it, with -data on the command line
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	secret := fs.String("secret", "", "")
+	listen := fs.String("listen", "", "")
+	data := fs.String("data", "", "")
+	fs.Parse([]string{"-data", "/srv/shed"})
+	if err := loadConfig(fs, "serve", path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then the command line should take precedence over the file
+	if *secret != "s3cret" || *listen != ":7000" || *data != "/srv/shed" {
+		t.Errorf("got secret %q, listen %q, data %q", *secret, *listen, *data)
+	}
+}
+
+func TestLoadConfigRejectsUnknownFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shed.conf")
+	os.WriteFile(path, []byte("[serve]\nlisten = :7000\nlisen = :7001\n"), 0o600)
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.String("listen", "", "")
+	if err := loadConfig(fs, "serve", path); err == nil {
+		t.Error("expected an error for the misspelled flag")
+	}
+}
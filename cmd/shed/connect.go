@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/client"
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/tlsconf"
+)
+
+// remote holds the flags of commands that connect to a server.
+type remote struct {
+	document  string
+	secret    string
+	token     string
+	tls       bool
+	ca        string
+	cert      string
+	key       string
+	pin       string
+	heartbeat time.Duration
+
+	// Only the connect and sync commands log.
+	log   logOptions
+	debug bool
+}
+
+func (r *remote) flags(fs *flag.FlagSet) {
+	fs.StringVar(&r.document, "document", os.Getenv("SHED_DOCUMENT"), "open `name` on servers that host several documents (SHED_DOCUMENT)")
+	fs.StringVar(&r.secret, "secret", os.Getenv("SHED_SECRET"), "present `secret` to the server (SHED_SECRET)")
+	fs.StringVar(&r.token, "token", os.Getenv("SHED_TOKEN"), "present bearer `token` to the server (SHED_TOKEN)")
+	fs.BoolVar(&r.tls, "tls", false, "connect with TLS, verifying the server against the system's CAs")
+	fs.StringVar(&r.ca, "tls-ca", "", "connect with TLS, verifying the server against the CA in `file`")
+	fs.StringVar(&r.cert, "tls-cert", "", "present the client certificate in `file`")
+	fs.StringVar(&r.key, "tls-key", "", "the private key for -tls-cert, in `file`")
+	fs.StringVar(&r.pin, "tls-pin", "", "connect with TLS, trusting only the server key with this `pin`")
+	fs.DurationVar(&r.heartbeat, "heartbeat", 30*time.Second, "ping the server this often, or never if 0")
+}
+
+// creds returns the credentials to open the connection with, if any.
+func (r *remote) creds() *comms.Authenticate {
+	if r.secret == "" && r.token == "" {
+		return nil
+	}
+	return &comms.Authenticate{Document: r.document, Secret: r.secret, Token: r.token}
+}
+
+// dial connects to the server, using TLS if any of the -tls flags are set.
+func (r *remote) dial(addr string) (net.Conn, error) {
+	conn, err := comms.Dial(addr)
+	if err != nil {
+		return nil, unreachableError{err}
+	}
+	if !r.tls && r.ca == "" && r.cert == "" && r.pin == "" {
+		return conn, nil
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	conf, err := tlsconf.Client(host, r.ca, r.cert, r.key, r.pin)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tls.Client(conn, conf), nil
+}
+
+func connect(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+	r.log.flags(fs, slog.LevelWarn)
+	fs.BoolVar(&r.debug, "debug", false, "log every message exchanged with the server at info level")
+	listen := fs.String("listen", "", "accept editors on the Unix domain socket at `path`, each with its own session")
+	framing := fs.String("framing", "length", "frame messages to and from editors with `framing`: length (a 4-byte big-endian length), lines (newline-delimited JSON) or headers (Content-Length, as in LSP)")
+	rpc := fs.Bool("rpc", false, "serve editors a JSON-RPC 2.0 API rather than relaying shed's messages")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("connect takes the server's address")
+		}
+		frame, ok := framings[*framing]
+		if !ok {
+			return usageError("-framing must be length, lines or headers")
+		}
+		addr := args[0]
+		logger, err := r.log.logger(os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		serve := func(editor io.ReadWriter) error {
+			if *rpc {
+				return r.serveRPC(frame(editor), addr, logger)
+			}
+			return r.relay(comms.NewFramedStream(editor, frame), addr, logger)
+		}
+
+		if *listen == "" {
+			return serve(stdio{})
+		}
+		l, err := comms.Listen(comms.UnixPrefix + strings.TrimPrefix(*listen, comms.UnixPrefix))
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		for {
+			editor, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			go func() {
+				if err := serve(editor); err != nil {
+					logger.Error("connecting to the server", "error", err)
+					editor.Close()
+				} else if *rpc {
+					editor.Close()
+				}
+			}()
+		}
+	}
+}
+
+// framings frame messages on the byte streams editors connect with.
+var framings = map[string]comms.Framing{
+	"length":  comms.LengthFrames,
+	"lines":   comms.LineFrames,
+	"headers": comms.HeaderFrames,
+}
+
+// relay relays between an editor and a new session on the server until
+// either disconnects.
+func (r *remote) relay(editor comms.Transport, addr string, logger *slog.Logger) error {
+	server, err := r.dial(addr)
+	if err != nil {
+		return err
+	}
+	c := client.Client{Auth: r.creds(), HeartbeatInterval: r.heartbeat, Logger: logger, Debug: r.debug}
+	c.Attach(editor)
+	if err := c.Connect(comms.NewStream(server)); err != nil {
+		return err
+	}
+	<-c.Done()
+	return nil
+}
+
+// serveRPC serves an editor the JSON-RPC API until it disconnects, connecting
+// to the server each time it opens a document.
+func (r *remote) serveRPC(editor comms.Framer, addr string, logger *slog.Logger) error {
+	rpc := client.RPC{Logger: logger, Connect: func(c *client.Client, document string) error {
+		c.Auth, c.HeartbeatInterval, c.Logger, c.Debug = r.creds(), r.heartbeat, logger, r.debug
+		if document != "" {
+			if c.Auth == nil {
+				return comms.ErrorMessage{Code: comms.INVALID, Message: "choosing a document needs -secret or -token"}
+			}
+			c.Auth.Document = document
+		}
+		server, err := r.dial(addr)
+		if err != nil {
+			return err
+		}
+		return c.Connect(comms.NewStream(server))
+	}}
+	return rpc.Serve(editor)
+}
+
+type stdio struct{}
+
+func (s stdio) Read(p []byte) (n int, err error) {
+	return os.Stdin.Read(p)
+}
+
+func (s stdio) Write(p []byte) (n int, err error) {
+	return os.Stdout.Write(p)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/shed-protocol/shed"
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// open joins the session on a server as c, once the document has loaded. c
+// opens the document given by -document unless it names another.
+func (r *remote) open(addr string, c *shed.Client) error {
+	conn, err := r.dial(addr)
+	if err != nil {
+		return err
+	}
+	if c.Document == "" {
+		c.Document = r.document
+	}
+	c.Secret, c.Token = r.secret, r.token
+	c.HeartbeatInterval = r.heartbeat
+	if err := c.Connect(shed.NewStream(conn)); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+func cat(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+	rev := fs.Int("rev", -1, "print the document as it was at revision `n`")
+	at := fs.String("at", "", "print the document as it was at `time`, given in RFC 3339 or as a duration ago")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("cat takes the server's address")
+		}
+		var t time.Time
+		if *at != "" {
+			var err error
+			if t, err = parseTime(*at, time.Now()); err != nil {
+				return usageError(err.Error())
+			}
+		}
+		c := &shed.Client{Name: "shed cat"}
+		if err := r.open(args[0], c); err != nil {
+			return err
+		}
+		defer c.Close()
+
+		text := c.Text()
+		switch {
+		case *rev >= 0:
+			past, err := c.Revision(*rev)
+			if err != nil {
+				return err
+			}
+			text = past.Text
+		case !t.IsZero():
+			past, err := c.RevisionAt(t)
+			if err != nil {
+				return err
+			}
+			text = past.Text
+		}
+		_, err := io.WriteString(os.Stdout, text)
+		return err
+	}
+}
+
+func apply(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+
+	return func(args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return usageError("apply takes the server's address and a file of changes")
+		}
+		in, err := input(args[1:])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		ops, err := readOps(in)
+		if err != nil {
+			return err
+		}
+		return r.change(args[0], "shed apply", func(string) ([]ot.Operation, error) {
+			return ops, nil
+		})
+	}
+}
+
+func patch(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+
+	return func(args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return usageError("patch takes the server's address and a unified diff")
+		}
+		in, err := input(args[1:])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		diff, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		return r.change(args[0], "shed patch", func(text string) ([]ot.Operation, error) {
+			return shed.Patch(text, string(diff))
+		})
+	}
+}
+
+// input opens the file named by args, or standard input if there is none or
+// it is "-".
+func input(args []string) (io.ReadCloser, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(args[0])
+}
+
+// change joins the session on a server as name, submits the changes edit
+// makes to the document's text and waits for the server to acknowledge them.
+func (r *remote) change(addr, name string, edit func(text string) ([]ot.Operation, error)) error {
+	// The number of changes is only known once the document has loaded.
+	var acked atomic.Int64
+	acks := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+	c := &shed.Client{
+		Name: name,
+		OnAck: func() {
+			acked.Add(1)
+			select {
+			case acks <- struct{}{}:
+			default:
+			}
+		},
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	}
+	if err := r.open(addr, c); err != nil {
+		return err
+	}
+	defer c.Close()
+	ops, err := edit(c.Text())
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		if err := c.Submit(op); err != nil {
+			return fmt.Errorf("change %d: %w", i+1, err)
+		}
+	}
+	for acked.Load() < int64(len(ops)) {
+		select {
+		case <-acks:
+		case err := <-errs:
+			return err
+		case <-c.Done():
+			return comms.ErrorMessage{Code: comms.CONNECTION_LOST, Message: "lost connection to the server"}
+		}
+	}
+	return nil
+}
+
+// readOps reads changes written one JSON operation per line, as in
+// {"type":"insertion","pos":0,"text":"hello"}.
+func readOps(r io.Reader) ([]ot.Operation, error) {
+	var ops []ot.Operation
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			op, err := ot.Unmarshal(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			ops = append(ops, op)
+		}
+		if errors.Is(err, io.EOF) {
+			return ops, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
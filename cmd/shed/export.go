@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+func export(fs *flag.FlagSet) func(args []string) error {
+	data := fs.String("data", "", "read documents saved by \"shed serve -data\" in `directory`")
+	out := fs.String("o", ".", "write documents to files under `directory`, or to stdout if -")
+
+	return func(args []string) error {
+		if *data == "" {
+			return usageError("export needs -data")
+		}
+		dir, err := store.Open(*data)
+		if err != nil {
+			return err
+		}
+		defer dir.Close()
+
+		docs := args
+		if len(docs) == 0 {
+			if docs, err = dir.Documents(); err != nil {
+				return err
+			}
+		}
+		for _, doc := range docs {
+			changes, err := dir.Load(doc)
+			if err != nil {
+				return fmt.Errorf("%q: %w", doc, err)
+			}
+			text := store.Replay("", changes)
+			if *out == "-" {
+				os.Stdout.WriteString(text)
+				continue
+			}
+
+			// Documents are named with slashes, which become directories.
+			name := filepath.FromSlash(doc)
+			if !filepath.IsLocal(name) {
+				return fmt.Errorf("%q can't be written to a file", doc)
+			}
+			path := filepath.Join(*out, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
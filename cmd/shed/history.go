@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shed-protocol/shed"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+func history(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+	from := fs.Int("from", 0, "list the changes after revision `n`")
+	limit := fs.Int("limit", 0, "list at most `n` changes, or all of them if 0")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("history takes the server's address")
+		}
+		c := &shed.Client{Name: "shed history"}
+		if err := r.open(args[0], c); err != nil {
+			return err
+		}
+		defer c.Close()
+		changes, _, err := c.History(*from, *limit)
+		if err != nil {
+			return err
+		}
+
+		w := bufio.NewWriter(os.Stdout)
+		for _, ch := range changes {
+			when := "-"
+			if !ch.Time.IsZero() {
+				when = ch.Time.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", ch.Rev, when, or(ch.Author, "-"), describe(ch.Op, ""))
+		}
+		return w.Flush()
+	}
+}
+
+func diff(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+
+	return func(args []string) error {
+		if len(args) != 3 {
+			return usageError("diff takes the server's address and two revisions")
+		}
+		from, err1 := strconv.Atoi(args[1])
+		to, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil || from < 0 || to < from {
+			return usageError("diff takes two revisions, the earlier first")
+		}
+		c := &shed.Client{Name: "shed diff"}
+		if err := r.open(args[0], c); err != nil {
+			return err
+		}
+		defer c.Close()
+		text, changes, err := c.Diff(from, to)
+		if err != nil {
+			return err
+		}
+
+		w := bufio.NewWriter(os.Stdout)
+		for _, ch := range changes {
+			op := ot.Clamp(ch.Op, uint(len(text)))
+			fmt.Fprintf(w, "@%d %s %s\n", ch.Rev, ch.Author, describe(op, text))
+			text = op.Apply(text)
+		}
+		return w.Flush()
+	}
+}
+
+func checkpoint(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+	rev := fs.Int("rev", -1, "name revision `n` rather than the current one")
+
+	return func(args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return usageError("checkpoint takes the server's address and optionally a name")
+		}
+		c := &shed.Client{Name: "shed checkpoint"}
+		if err := r.open(args[0], c); err != nil {
+			return err
+		}
+		defer c.Close()
+
+		var checkpoints []shed.Checkpoint
+		var err error
+		switch {
+		case len(args) == 1:
+			checkpoints, err = c.Checkpoints()
+		case *rev >= 0:
+			checkpoints, err = c.CheckpointRevision(args[1], *rev)
+		default:
+			checkpoints, err = c.Checkpoint(args[1])
+		}
+		if err != nil || len(args) == 2 {
+			return err
+		}
+		w := bufio.NewWriter(os.Stdout)
+		for _, cp := range checkpoints {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", cp.Rev, cp.Time.Local().Format(time.DateTime), or(cp.Author, "-"), cp.Name)
+		}
+		return w.Flush()
+	}
+}
+
+func revert(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+
+	return func(args []string) error {
+		if len(args) != 2 {
+			return usageError("revert takes the server's address and a revision or checkpoint")
+		}
+		c := &shed.Client{Name: "shed revert"}
+		if err := r.open(args[0], c); err != nil {
+			return err
+		}
+		defer c.Close()
+		if rev, err := strconv.Atoi(args[1]); err == nil {
+			_, err = c.Revert(rev)
+			return err
+		}
+		_, err := c.RevertTo(args[1])
+		return err
+	}
+}
+
+func blame(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+	spans := fs.Bool("spans", false, "list every span with its position instead of annotating lines")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("blame takes the server's address")
+		}
+		c := &shed.Client{Name: "shed blame"}
+		if err := r.open(args[0], c); err != nil {
+			return err
+		}
+		defer c.Close()
+		text, ss, err := c.Blame()
+		if err != nil {
+			return err
+		}
+
+		w := bufio.NewWriter(os.Stdout)
+		if *spans {
+			for _, sp := range ss {
+				fmt.Fprintf(w, "%d\t%d\t%d\t%s\n", sp.Pos, sp.Len, sp.Rev, or(sp.Author, "-"))
+			}
+			return w.Flush()
+		}
+		for _, l := range blameLines(text, ss) {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", l.Rev, or(l.Author, "-"), text[l.Pos:l.Pos+l.Len])
+		}
+		return w.Flush()
+	}
+}
+
+// blameLines splits text into lines, without their newlines, each attributed
+// to the latest of the spans that wrote any of it.
+func blameLines(text string, spans []shed.Span) []shed.Span {
+	var lines []shed.Span
+	i := 0
+	for pos := 0; pos < len(text); {
+		n := strings.IndexByte(text[pos:], '\n')
+		if n < 0 {
+			n = len(text) - pos
+		}
+		line := shed.Span{Pos: pos, Len: n}
+		// A line's newline counts towards it, so that splitting a line is
+		// blamed on whoever split it.
+		end := min(pos+n+1, len(text))
+		for ; i < len(spans) && spans[i].Pos < end; i++ {
+			if spans[i].Rev > line.Rev {
+				line.Rev, line.Author = spans[i].Rev, spans[i].Author
+			}
+			if spans[i].Pos+spans[i].Len > end {
+				break
+			}
+		}
+		lines = append(lines, line)
+		pos = end
+	}
+	return lines
+}
+
+// or returns s, or def if s is empty.
+func or(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// describe summarises op. If text is the document op applies to, deletions
+// show the text they remove.
+func describe(op ot.Operation, text string) string {
+	switch op := op.(type) {
+	case ot.Insertion:
+		return fmt.Sprintf("+%d %q", op.Pos, op.Text)
+	case ot.Deletion:
+		if text == "" {
+			return fmt.Sprintf("-%d %d", op.Pos, op.Len)
+		}
+		return fmt.Sprintf("-%d %q", op.Pos, text[op.Pos:op.Pos+op.Len])
+	}
+	return fmt.Sprint(op)
+}
+
+// parseTime parses s as an RFC 3339 time, or as a duration before now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a time nor a duration", s)
+	}
+	return t, nil
+}
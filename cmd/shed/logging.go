@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+)
+
+// logOptions holds the flags that control what a command logs.
+type logOptions struct {
+	level  slog.LevelVar
+	format string
+}
+
+func (o *logOptions) flags(fs *flag.FlagSet, level slog.Level) {
+	o.level.Set(level)
+	fs.TextVar(&o.level, "log-level", &o.level, "log messages at `level` (debug, info, warn or error) and above")
+	fs.StringVar(&o.format, "log-format", "text", "write the log as text or json")
+}
+
+// logger returns a logger writing to w, and makes it the default so that
+// anything else logged goes the same way. The level may be changed later by
+// setting o.level.
+func (o *logOptions) logger(w io.Writer) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: &o.level}
+	var h slog.Handler
+	switch o.format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, usageError("unknown log format " + o.format)
+	}
+	l := slog.New(h)
+	slog.SetDefault(l)
+	return l, nil
+}
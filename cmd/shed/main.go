@@ -0,0 +1,224 @@
+// Command shed hosts shared editing sessions and talks to them.
+//
+// Usage:
+//
+//	shed <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	serve      host documents for editors to share
+//	connect    relay between an editor on stdin and stdout and a server
+//	sync       keep files and documents the same
+//	nvim       share a Neovim buffer as a document
+//	cat        print a document, now or as it was
+//	history    list the changes made to a document
+//	diff       show the changes between two revisions of a document
+//	checkpoint name a revision of a document, or list the names
+//	revert     restore a document to an earlier revision or checkpoint
+//	blame      show who wrote each line of a document
+//	apply      make changes to a document
+//	patch      apply a unified diff to a document
+//	export     write documents saved by a server out as files
+//	token      issue a bearer token for a server
+//
+// Run "shed <command> -h" to list a command's flags. Every command also
+// accepts -config, or SHED_CONFIG, naming a file that sets flags:
+//
+//	# Settings before any heading apply to every command with the flag.
+//	secret = correct horse battery staple
+//
+//	[serve]
+//	listen = :9000
+//	data = /var/lib/shed
+//
+// Flags given on the command line take precedence over the file. Flags for
+// secrets default to SHED_* environment variables, so that they needn't
+// appear in the process list.
+//
+// "shed serve" reads the file again on SIGHUP, applying what it can without
+// disconnecting anyone, and logs what needs a restart to change. Its log is
+// structured; -log-format json suits log collectors, and -debug follows every
+// message of a troublesome document or user without raising -log-level.
+//
+// "shed connect" exchanges messages with editors as JSON envelopes, such as
+// {"kind":1,"body":{"op":{...}}}, each preceded by its length as a 4-byte
+// big-endian integer. Editors that find that awkward to write, such as
+// scripts, may use -framing lines for one envelope per line, or -framing
+// headers for a Content-Length header before each, as language servers do.
+// Once an error with "change" set rejects one of its changes, an editor's
+// changes are dropped until it fetches the document again. With -rpc it serves editors JSON-RPC 2.0 instead: they call "open", "edit",
+// "undo", "getText" and "getParticipants", and are notified of others'
+// changes ("remoteEdit"), of who is there ("presence") and of the connection
+// ("connection").
+//
+// "shed sync" keeps a file and a document the same or, given a directory,
+// each file in it and the document named by the file's path under -document,
+// creating, deleting and renaming documents as files are and the other way
+// round. Servers only host several documents if they authenticate clients,
+// and only let owners delete or rename them.
+//
+// "shed nvim" shares a buffer of a running Neovim, started with --listen
+// giving the socket, as a document. The buffer takes the document's text to
+// begin with, unless the document is empty, in which case it is the other
+// way round.
+//
+// Shed exits with status 0 on success, 1 on failure, 2 if it was used
+// incorrectly, 3 if it couldn't reach the server and 4 if the server turned
+// it away.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+// Exit statuses.
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+	exitUnreachable
+	exitRejected
+)
+
+// A command is one of shed's subcommands. setup registers the command's flags
+// on fs and returns the function that runs it with the remaining arguments.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "", "host documents for editors to share", serve},
+	{"connect", "<address>", "relay between an editor on stdin and stdout and a server", connect},
+	{"sync", "<address> <file>|<directory>", "keep files and documents the same", syncFile},
+	{"nvim", "<address> <socket>", "share a Neovim buffer as a document", nvim},
+	{"cat", "<address>", "print a document, now or as it was", cat},
+	{"history", "<address>", "list the changes made to a document", history},
+	{"diff", "<address> <from> <to>", "show the changes between two revisions of a document", diff},
+	{"checkpoint", "<address> [<name>]", "name a revision of a document, or list the names", checkpoint},
+	{"revert", "<address> <revision>|<checkpoint>", "restore a document to an earlier revision or checkpoint", revert},
+	{"blame", "<address>", "show who wrote each line of a document", blame},
+	{"apply", "<address> [<file>]", "make changes to a document", apply},
+	{"patch", "<address> [<file>]", "apply a unified diff to a document", patch},
+	{"export", "[<document>...]", "write documents saved by a server out as files", export},
+	{"token", "<name> [<document>...]", "issue a bearer token for a server", token},
+}
+
+// A usageError means shed was run with the wrong arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// An unreachableError means the server couldn't be reached.
+type unreachableError struct {
+	err error
+}
+
+func (e unreachableError) Error() string {
+	return e.err.Error()
+}
+
+func (e unreachableError) Unwrap() error {
+	return e.err
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "shed: unknown command %q\n", args[0])
+		usage()
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("shed "+cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: shed %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	do := cmd.setup(fs)
+	if err := parseFlags(fs, cmd.name, args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	reparse = func(fs *flag.FlagSet) error {
+		return parseFlags(fs, cmd.name, args[1:])
+	}
+
+	err := do(fs.Args())
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "shed %s: %s\n", cmd.name, err)
+	var (
+		u usageError
+		r unreachableError
+		e comms.ErrorMessage
+	)
+	switch {
+	case errors.As(err, &u):
+		fs.Usage()
+		return exitUsage
+	case errors.As(err, &r):
+		return exitUnreachable
+	case errors.As(err, &e) && (e.Code == comms.UNAUTHORIZED || e.Code == comms.FORBIDDEN):
+		return exitRejected
+	}
+	return exitFailure
+}
+
+// reparse parses the command line and config file again into fs, for
+// commands that pick up changes to the config file while they run.
+var reparse func(fs *flag.FlagSet) error
+
+// parseFlags parses a command's flags from its command line, then from the
+// config file given by -config. Errors are reported to fs.Output.
+func parseFlags(fs *flag.FlagSet, cmd string, args []string) error {
+	config := fs.String("config", os.Getenv("SHED_CONFIG"), "read flags from `file`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *config == "" {
+		return nil
+	}
+	if err := loadConfig(fs, cmd, *config); err != nil {
+		fmt.Fprintf(fs.Output(), "%s: %s\n", fs.Name(), err)
+		return err
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: shed <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"shed <command> -h\" for a command's flags.\n")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shed-protocol/shed"
+)
+
+func TestCommandsParseTheirDefaults(t *testing.T) {
+	for _, cmd := range commands {
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		cmd.setup(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			if err := fs.Set(f.Name, f.DefValue); err != nil && f.DefValue != "" {
+				t.Errorf("%s -%s: can't be set to its default %q: %s", cmd.name, f.Name, f.DefValue, err)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"90m":                  now.Add(-90 * time.Minute),
+		"2024-04-30T09:00:00Z": time.Date(2024, 4, 30, 9, 0, 0, 0, time.UTC),
+	} {
+		if got, err := parseTime(s, now); err != nil || !got.Equal(want) {
+			t.Errorf("parseTime(%q) returned %v, %v, expected %v", s, got, err, want)
+		}
+	}
+	if _, err := parseTime("yesterday", now); err == nil {
+		t.Error("parseTime(\"yesterday\") succeeded, expected an error")
+	}
+}
+
+func TestBlameLines(t *testing.T) {
+	// Bob split the first two lines and rewrote the end of the last
+	text := "one\ntwo\nthree"
+	spans := []shed.Span{
+		{Pos: 0, Len: 3, Author: "alice", Rev: 1},
+		{Pos: 3, Len: 1, Author: "bob", Rev: 2},
+		{Pos: 4, Len: 6, Author: "alice", Rev: 1},
+		{Pos: 10, Len: 3, Author: "bob", Rev: 3},
+	}
+	want := []shed.Span{
+		{Pos: 0, Len: 3, Author: "bob", Rev: 2},
+		{Pos: 4, Len: 3, Author: "alice", Rev: 1},
+		{Pos: 8, Len: 5, Author: "bob", Rev: 3},
+	}
+	if got := blameLines(text, spans); !slices.Equal(got, want) {
+		t.Errorf("blameLines returned %v, expected %v", got, want)
+	}
+}
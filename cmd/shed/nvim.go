@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shed-protocol/shed"
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/msgpack"
+)
+
+func nvim(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+	r.log.flags(fs, slog.LevelWarn)
+	name := fs.String("name", os.Getenv("USER"), "join the session as `name`")
+	colour := fs.String("colour", "", "ask for `colour` to mark your changes")
+	buffer := fs.Int("buffer", 0, "share buffer `n`, or the current buffer if 0")
+
+	return func(args []string) error {
+		if len(args) != 2 {
+			return usageError("nvim takes the server's address and the address Neovim listens on")
+		}
+		logger, err := r.log.logger(os.Stderr)
+		if err != nil {
+			return err
+		}
+		conn, err := dialNvim(args[1])
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		b := &nvimBridge{buffer: *buffer, logger: logger, wake: make(chan struct{}, 1)}
+		b.client = &shed.Client{Name: *name, Colour: *colour, Logger: logger, OnRemote: func(shed.Operation) { b.notify() }}
+		if err := r.open(args[0], b.client); err != nil {
+			return err
+		}
+		defer b.client.Close()
+		return b.run(conn)
+	}
+}
+
+// dialNvim connects to Neovim where it listens, as given to its --listen
+// flag: a Unix domain socket's path, or a TCP address.
+func dialNvim(addr string) (net.Conn, error) {
+	network := "unix"
+	if _, _, err := net.SplitHostPort(addr); err == nil && !strings.Contains(addr, "/") {
+		network = "tcp"
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Neovim: %w", err)
+	}
+	return conn, nil
+}
+
+// Kinds of msgpack-RPC message.
+const (
+	rpcRequest      = 0
+	rpcResponse     = 1
+	rpcNotification = 2
+)
+
+// detachedError means Neovim stopped sending a buffer's changes, as it does
+// when the buffer is unloaded.
+var detachedError = errors.New("detached from the buffer")
+
+// An nvimBridge keeps a Neovim buffer and a shared document the same,
+// speaking msgpack-RPC with Neovim. It has Neovim report the buffer's changes
+// with nvim_buf_attach and makes others' changes with nvim_buf_set_text.
+//
+// The bridge can't tell its own changes from the user's by what they do, so
+// it relies on Neovim reporting a change before answering the request that
+// made it: while a change is in flight, the buffer's changes are held until
+// the answer comes, and the last of them is the bridge's own.
+type nvimBridge struct {
+	client *shed.Client
+	buffer int
+	logger *slog.Logger
+	wake   chan struct{}
+
+	w      io.Writer
+	lastId int64
+	attach int64
+
+	// lines is the buffer as Neovim last reported it. eol is whether the
+	// document ends with a newline after the last line, which Neovim
+	// leaves out of lines.
+	lines    []string
+	eol      bool
+	attached bool
+
+	// setting is the ID of the nvim_buf_set_text request in flight, if any,
+	// and held the buffer's changes reported since it was sent.
+	setting int64
+	held    []linesEvent
+}
+
+// A linesEvent reports that Neovim replaced lines first to last, exclusive,
+// with data. A negative last means the end of the buffer.
+type linesEvent struct {
+	first, last int
+	data        []string
+}
+
+// apply returns lines with the event's change made.
+func (e linesEvent) apply(lines []string) []string {
+	last := e.last
+	if last < 0 || last > len(lines) {
+		last = len(lines)
+	}
+	lines = slices.Replace(lines, min(max(e.first, 0), last), last, e.data...)
+	if len(lines) == 0 {
+		// Buffers always have a line, if an empty one.
+		lines = []string{""}
+	}
+	return lines
+}
+
+// notify has the bridge bring the buffer up to date with the document.
+func (b *nvimBridge) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run keeps the buffer and document the same until either goes away. The
+// buffer takes the document's text to begin with, unless the document is
+// empty.
+func (b *nvimBridge) run(nvim io.ReadWriter) error {
+	b.w = nvim
+	msgs := make(chan []any)
+	var readErr error
+	go func() {
+		defer close(msgs)
+		d := msgpack.NewDecoder(nvim)
+		for {
+			v, err := d.Decode()
+			if err != nil {
+				readErr = err
+				return
+			}
+			if m, ok := v.([]any); ok && len(m) > 0 {
+				msgs <- m
+			} else {
+				b.logger.Warn("ignoring malformed message from Neovim")
+			}
+		}
+	}()
+
+	var err error
+	if b.attach, err = b.request("nvim_buf_attach", b.buffer, true, map[string]any{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				if errors.Is(readErr, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("reading from Neovim: %w", readErr)
+			}
+			err = b.handle(m)
+		case <-b.wake:
+			err = b.sync()
+		case <-b.client.Done():
+			return comms.ErrorMessage{Code: comms.CONNECTION_LOST, Message: "lost connection to the server"}
+		}
+		if errors.Is(err, detachedError) {
+			b.logger.Info("Neovim detached from the buffer")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *nvimBridge) handle(m []any) error {
+	kind, _ := msgpack.AsInt(m[0])
+	switch {
+	case kind == rpcResponse && len(m) == 4:
+		id, _ := msgpack.AsInt(m[1])
+		return b.response(id, m[2], m[3])
+	case kind == rpcNotification && len(m) == 3:
+		method, _ := m[1].(string)
+		params, _ := m[2].([]any)
+		return b.notification(method, params)
+	case kind == rpcRequest && len(m) == 4:
+		return b.send([]any{int64(rpcResponse), m[1], []any{int64(0), "shed answers no requests"}, nil})
+	}
+	b.logger.Warn("ignoring malformed message from Neovim")
+	return nil
+}
+
+func (b *nvimBridge) response(id int64, rpcErr, result any) error {
+	switch id {
+	case b.attach:
+		if rpcErr != nil {
+			return fmt.Errorf("attaching to buffer %d: %s", b.buffer, nvimError(rpcErr))
+		}
+		if ok, _ := result.(bool); !ok {
+			return fmt.Errorf("could not attach to buffer %d", b.buffer)
+		}
+	case b.setting:
+		held := b.held
+		b.setting, b.held = 0, nil
+		var own *linesEvent
+		if rpcErr != nil {
+			b.logger.Warn("Neovim refused a change", "error", nvimError(rpcErr))
+		} else if len(held) > 0 {
+			own, held = &held[len(held)-1], held[:len(held)-1]
+		}
+		for _, e := range held {
+			if err := b.edit(e); err != nil {
+				return err
+			}
+		}
+		if own != nil {
+			b.lines = own.apply(b.lines)
+		}
+		return b.sync()
+	}
+	return nil
+}
+
+func (b *nvimBridge) notification(method string, params []any) error {
+	switch method {
+	case "nvim_buf_lines_event":
+		// [buffer, changedtick, firstline, lastline, linedata, more]
+		e, ok := parseLinesEvent(params)
+		if !ok {
+			b.logger.Warn("ignoring malformed buffer change from Neovim")
+			return nil
+		}
+		switch {
+		case !b.attached:
+			// The first event holds the whole buffer.
+			b.attached, b.lines = true, e.apply(nil)
+			return b.start()
+		case b.setting != 0:
+			b.held = append(b.held, e)
+			return nil
+		}
+		return b.edit(e)
+	case "nvim_buf_detach_event":
+		return detachedError
+	}
+	return nil
+}
+
+func parseLinesEvent(params []any) (linesEvent, bool) {
+	if len(params) < 5 {
+		return linesEvent{}, false
+	}
+	first, ok1 := msgpack.AsInt(params[2])
+	last, ok2 := msgpack.AsInt(params[3])
+	data, ok3 := params[4].([]any)
+	if !ok1 || !ok2 || !ok3 {
+		return linesEvent{}, false
+	}
+	e := linesEvent{first: int(first), last: int(last), data: make([]string, len(data))}
+	for i, line := range data {
+		if e.data[i], ok1 = line.(string); !ok1 {
+			return linesEvent{}, false
+		}
+	}
+	return e, true
+}
+
+// start makes the buffer and document the same to begin with. An empty
+// document takes the buffer's text, ending it with a newline as Neovim
+// writes files; otherwise the buffer takes the document's.
+func (b *nvimBridge) start() error {
+	if buf := strings.Join(b.lines, "\n"); b.client.Text() == "" && buf != "" {
+		b.eol = true
+		return b.client.Edit(func(text string) []shed.Operation { return shed.Diff(text, buf+"\n") })
+	}
+	return b.sync()
+}
+
+// text returns the buffer's text as the document would hold it.
+func (b *nvimBridge) text() string {
+	text := strings.Join(b.lines, "\n")
+	if b.eol {
+		text += "\n"
+	}
+	return text
+}
+
+// edit sends the user's change to the buffer to the server, rebased on any of
+// the document's changes that the buffer doesn't show yet.
+func (b *nvimBridge) edit(e linesEvent) error {
+	old := b.text()
+	b.lines = e.apply(b.lines)
+	ops := spliceOps(old, b.text())
+	if len(ops) == 0 {
+		return nil
+	}
+	return b.client.Edit(func(doc string) []shed.Operation {
+		if doc != old {
+			for _, r := range shed.Diff(old, doc) {
+				for i, l := range ops {
+					ops[i], r = l.Rebase(r), r.Rebase(l)
+				}
+			}
+		}
+		return ops
+	})
+}
+
+// sync changes the buffer to match the document, unless a change is already
+// in flight, in which case it is called again once Neovim answers.
+func (b *nvimBridge) sync() error {
+	if !b.attached || b.setting != 0 {
+		return nil
+	}
+	doc := b.client.Text()
+	b.eol = strings.HasSuffix(doc, "\n")
+	want := strings.TrimSuffix(doc, "\n")
+	have := strings.Join(b.lines, "\n")
+	if have == want {
+		return nil
+	}
+	pos, n, text := splice(have, want)
+	startRow, startCol := position(have, pos)
+	endRow, endCol := position(have, pos+n)
+	id, err := b.request("nvim_buf_set_text", b.buffer, startRow, startCol, endRow, endCol, strings.Split(text, "\n"))
+	if err != nil {
+		return err
+	}
+	b.setting = id
+	return nil
+}
+
+// request sends Neovim a request, returning its ID.
+func (b *nvimBridge) request(method string, params ...any) (int64, error) {
+	b.lastId++
+	return b.lastId, b.send([]any{int64(rpcRequest), b.lastId, method, params})
+}
+
+func (b *nvimBridge) send(m []any) error {
+	data, err := msgpack.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := b.w.Write(data); err != nil {
+		return fmt.Errorf("writing to Neovim: %w", err)
+	}
+	return nil
+}
+
+// nvimError returns the message of an error Neovim reported, which it sends
+// as [type, message].
+func nvimError(v any) string {
+	if e, ok := v.([]any); ok && len(e) == 2 {
+		if msg, ok := e[1].(string); ok {
+			return msg
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// splice returns where a and b differ, as one change: n bytes of a from pos
+// are replaced by text. It never splits a UTF-8 sequence.
+func splice(a, b string) (pos, n int, text string) {
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	for p > 0 && (p < len(a) && !utf8.RuneStart(a[p]) || p < len(b) && !utf8.RuneStart(b[p])) {
+		p--
+	}
+	s := 0
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+	for s > 0 && !utf8.RuneStart(a[len(a)-s]) {
+		s--
+	}
+	return p, len(a) - p - s, b[p : len(b)-s]
+}
+
+// spliceOps returns the operations that turn a into b, where they differ in
+// one place.
+func spliceOps(a, b string) []shed.Operation {
+	pos, n, text := splice(a, b)
+	var ops []shed.Operation
+	if n > 0 {
+		ops = append(ops, shed.Deletion{Pos: uint(pos), Len: uint(n)})
+	}
+	if text != "" {
+		ops = append(ops, shed.Insertion{Pos: uint(pos), Text: text})
+	}
+	return ops
+}
+
+// position returns the 0-indexed line and byte column of offset in text, as
+// Neovim's API counts them.
+func position(text string, offset int) (row, col int) {
+	before := text[:offset]
+	return strings.Count(before, "\n"), offset - strings.LastIndexByte(before, '\n') - 1
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shed-protocol/shed"
+	"github.com/shed-protocol/shed/internal/msgpack"
+)
+
+// A fakeNvim stands in for Neovim, answering the requests the bridge makes
+// and reporting changes to its one buffer as Neovim does.
+type fakeNvim struct {
+	conn net.Conn
+
+	mu    sync.Mutex
+	lines []string
+	tick  int64
+
+	// meanwhile, if set, is called before the next nvim_buf_set_text is
+	// carried out, as though the user had typed while it was on its way.
+	meanwhile func()
+}
+
+func newFakeNvim(t *testing.T, lines ...string) (*fakeNvim, net.Conn) {
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close() })
+	f := &fakeNvim{conn: a, lines: lines}
+	go f.serve()
+	return f, b
+}
+
+func (f *fakeNvim) send(m ...any) {
+	data, _ := msgpack.Marshal(m)
+	f.conn.Write(data)
+}
+
+// replace replaces lines first to last with data, reporting the change. The
+// caller must hold f.mu.
+func (f *fakeNvim) replace(first, last int, data []string) {
+	f.lines = slices.Replace(f.lines, first, last, data...)
+	f.tick++
+	f.send(int64(rpcNotification), "nvim_buf_lines_event", []any{msgpack.Ext{Type: 0, Data: []byte{1}}, f.tick, first, last, data, false})
+}
+
+// edit makes a change as the user would.
+func (f *fakeNvim) edit(first, last int, data ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replace(first, last, data)
+}
+
+func (f *fakeNvim) text() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.lines, "\n")
+}
+
+func (f *fakeNvim) serve() {
+	d := msgpack.NewDecoder(f.conn)
+	for {
+		v, err := d.Decode()
+		if err != nil {
+			return
+		}
+		m := v.([]any)
+		id, method, params := m[1], m[2].(string), m[3].([]any)
+		f.mu.Lock()
+		switch method {
+		case "nvim_buf_attach":
+			f.tick++
+			f.send(int64(rpcNotification), "nvim_buf_lines_event", []any{msgpack.Ext{Type: 0, Data: []byte{1}}, f.tick, 0, -1, f.lines, false})
+			f.send(int64(rpcResponse), id, nil, true)
+		case "nvim_buf_set_text":
+			if f.meanwhile != nil {
+				f.meanwhile()
+				f.meanwhile = nil
+			}
+			n := make([]int, 4)
+			for i := range n {
+				x, _ := msgpack.AsInt(params[i+1])
+				n[i] = int(x)
+			}
+			var replacement []string
+			for _, s := range params[5].([]any) {
+				replacement = append(replacement, s.(string))
+			}
+			if n[2] >= len(f.lines) || n[3] > len(f.lines[n[2]]) {
+				f.send(int64(rpcResponse), id, []any{int64(0), "index out of bounds"}, nil)
+				break
+			}
+			// Neovim reports the change, then answers.
+			replacement[0] = f.lines[n[0]][:n[1]] + replacement[0]
+			replacement[len(replacement)-1] += f.lines[n[2]][n[3]:]
+			f.replace(n[0], n[2]+1, replacement)
+			f.send(int64(rpcResponse), id, nil, nil)
+		default:
+			f.send(int64(rpcResponse), id, []any{int64(0), "unknown method"}, nil)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// startBridge shares f's buffer as the document s hosts.
+func startBridge(t *testing.T, s *shed.Server, nvim net.Conn) {
+	a, b := shed.Pipe()
+	s.Accept(a)
+	bridge := &nvimBridge{logger: slog.New(slog.DiscardHandler), wake: make(chan struct{}, 1)}
+	bridge.client = &shed.Client{Name: "nvim", OnRemote: func(shed.Operation) { bridge.notify() }}
+	if err := bridge.client.Connect(b); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bridge.client.Close() })
+	go bridge.run(nvim)
+}
+
+func connectDoc(t *testing.T, s *shed.Server, c *shed.Client) *shed.Client {
+	t.Helper()
+	a, b := shed.Pipe()
+	s.Accept(a)
+	if err := c.Connect(b); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// eventually waits for cond to hold, failing the test if it doesn't soon.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("gave up waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNvimBridgeSharesBuffer(t *testing.T) {
+	// Given a document, and Neovim with an empty buffer
+	server := &shed.Server{}
+	bob := connectDoc(t, server, &shed.Client{Name: "bob"})
+	bob.Submit(shed.Insertion{Text: "hello\nworld\n"})
+	nvim, conn := newFakeNvim(t, "")
+
+	// When the bridge attaches to the buffer
+	startBridge(t, server, conn)
+
+	// Then the buffer should take the document's text
+	eventually(t, "the buffer to load", func() bool { return nvim.text() == "hello\nworld" })
+
+	// When the user edits the buffer
+	nvim.edit(0, 1, "hello there")
+
+	// Then the document should follow
+	eventually(t, "the document to change", func() bool { return bob.Text() == "hello there\nworld\n" })
+
+	// When someone else edits the document
+	bob.Submit(shed.Insertion{Pos: 17, Text: "!\nagain"})
+
+	// Then the buffer should follow, without sending the change back
+	eventually(t, "the buffer to change", func() bool { return nvim.text() == "hello there\nworld!\nagain" })
+	time.Sleep(20 * time.Millisecond)
+	if got := bob.Text(); got != "hello there\nworld!\nagain\n" {
+		t.Errorf("the document holds %q", got)
+	}
+}
+
+func TestNvimBridgeTakesBufferForEmptyDocument(t *testing.T) {
+	// Given an empty document, and Neovim with a buffer of its own
+	server := &shed.Server{}
+	bob := connectDoc(t, server, &shed.Client{Name: "bob"})
+	nvim, conn := newFakeNvim(t, "one", "two")
+
+	// When the bridge attaches to the buffer
+	startBridge(t, server, conn)
+
+	// Then the document should take the buffer's text
+	eventually(t, "the document to load", func() bool { return bob.Text() == "one\ntwo\n" })
+	if got := nvim.text(); got != "one\ntwo" {
+		t.Errorf("the buffer holds %q", got)
+	}
+}
+
+func TestNvimBridgeMergesChangesMadeMeanwhile(t *testing.T) {
+	// Given a buffer shared as a document
+	server := &shed.Server{}
+	bob := connectDoc(t, server, &shed.Client{Name: "bob"})
+	bob.Submit(shed.Insertion{Text: "ab\ncd\n"})
+	nvim, conn := newFakeNvim(t, "")
+	startBridge(t, server, conn)
+	eventually(t, "the buffer to load", func() bool { return nvim.text() == "ab\ncd" })
+
+	// When the user types while someone else's change is on its way to the
+	// buffer
+	nvim.mu.Lock()
+	nvim.meanwhile = func() { nvim.replace(1, 2, []string{"xxcd"}) }
+	nvim.mu.Unlock()
+	bob.Submit(shed.Insertion{Pos: 4, Text: "Y"})
+
+	// Then both changes should end up in the buffer and the document
+	eventually(t, "the buffer and document to agree", func() bool {
+		return bob.Text() == "ab\nxxcYd\n" && nvim.text() == "ab\nxxcYd"
+	})
+}
+
+func TestSplice(t *testing.T) {
+	for _, c := range []struct {
+		a, b   string
+		pos, n int
+		text   string
+	}{
+		{"hello", "hello", 5, 0, ""},
+		{"hello", "help", 3, 2, "p"},
+		{"abc", "aXbc", 1, 0, "X"},
+		{"aaa", "aa", 2, 1, ""},
+		{"naïve", "naive", 2, 2, "i"},
+		{"é", "è", 0, 2, "è"},
+	} {
+		pos, n, text := splice(c.a, c.b)
+		if pos != c.pos || n != c.n || text != c.text {
+			t.Errorf("splice(%q, %q) = %d, %d, %q, expected %d, %d, %q", c.a, c.b, pos, n, text, c.pos, c.n, c.text)
+		}
+	}
+}
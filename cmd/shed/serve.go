@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/admin"
+	"github.com/shed-protocol/shed/internal/auth"
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/server"
+	"github.com/shed-protocol/shed/internal/store"
+	"github.com/shed-protocol/shed/internal/tlsconf"
+	"github.com/shed-protocol/shed/internal/web"
+	"github.com/shed-protocol/shed/internal/websocket"
+)
+
+// serveOptions holds the flags of the serve command. Those marked as
+// reloadable are applied to the running server on SIGHUP; the rest need a
+// restart to change.
+type serveOptions struct {
+	listen   listFlag
+	http     string
+	origins  listFlag
+	metrics  string
+	admin    string
+	adminKey string
+	data     string
+	sync     time.Duration
+	tlsCert  string
+	tlsKey   string
+	clientCA string
+	timeout  time.Duration
+	logFile  string
+	maxSize  int
+
+	// Reloadable, except for the log format.
+	log         logOptions
+	debug       listFlag
+	secret      string
+	tokenKey    string
+	defaultRole string
+	heartbeat   time.Duration
+	acl         aclFlag
+	limits      server.Limits
+}
+
+func (o *serveOptions) flags(fs *flag.FlagSet) {
+	fs.Var(&o.listen, "listen", "accept editors on `address`, or unix:/path/to/socket (repeatable, default :9000)")
+	fs.StringVar(&o.http, "http", "", "serve the web editor and WebSockets on `address`")
+	fs.Var(&o.origins, "allow-origin", "let pages from `origin`, such as https://example.com, connect over WebSockets (repeatable, * for any)")
+	fs.StringVar(&o.metrics, "metrics", "", "serve Prometheus metrics at /metrics on `address`")
+	fs.StringVar(&o.admin, "admin", "", "serve the admin API on `address`, best a Unix domain socket or loopback")
+	fs.StringVar(&o.adminKey, "admin-token", os.Getenv("SHED_ADMIN_TOKEN"), "require `token` for the admin API (SHED_ADMIN_TOKEN)")
+	fs.StringVar(&o.data, "data", "", "save documents in `directory`, so they survive restarts")
+	fs.DurationVar(&o.sync, "sync-interval", time.Second, "flush saved documents to disk this often")
+	fs.StringVar(&o.tlsCert, "tls-cert", "", "serve TLS with the certificate in `file`")
+	fs.StringVar(&o.tlsKey, "tls-key", "", "the private key for -tls-cert, in `file`")
+	fs.StringVar(&o.clientCA, "tls-client-ca", "", "require client certificates signed by the CA in `file`")
+	fs.DurationVar(&o.timeout, "shutdown-timeout", 10*time.Second, "give clients this long to disconnect when stopping")
+	fs.StringVar(&o.logFile, "log", "", "append the log to `file` instead of stderr")
+	fs.IntVar(&o.maxSize, "max-message-size", comms.MaxPayloadSize, "drop connections that send messages longer than `bytes`")
+
+	o.log.flags(fs, slog.LevelInfo)
+	fs.Var(&o.debug, "debug", "log every message of sessions on `document`, or of the user of that name (repeatable)")
+	fs.StringVar(&o.secret, "secret", os.Getenv("SHED_SECRET"), "let clients presenting `secret` join (SHED_SECRET)")
+	fs.StringVar(&o.tokenKey, "token-key", os.Getenv("SHED_TOKEN_KEY"), "let clients with tokens signed by `key` join (SHED_TOKEN_KEY)")
+	fs.StringVar(&o.defaultRole, "default-role", "", "give clients the ACL doesn't mention this `role`")
+	fs.DurationVar(&o.heartbeat, "heartbeat", 30*time.Second, "ping clients this often, or never if 0")
+	o.acl = make(aclFlag)
+	fs.Var(o.acl, "acl", "give a user a role on a document, as `document:user=role` (repeatable)")
+
+	o.limits = server.DefaultLimits
+	l := &o.limits
+	fs.Float64Var(&l.SessionMessages.PerSecond, "rate-messages", l.SessionMessages.PerSecond, "let each client send `n` messages a second, or any number if 0")
+	fs.IntVar(&l.SessionMessages.Burst, "burst-messages", l.SessionMessages.Burst, "let each client send `n` messages at once")
+	fs.Float64Var(&l.SessionBytes.PerSecond, "rate-bytes", l.SessionBytes.PerSecond, "let each client send `n` bytes a second, or any number if 0")
+	fs.IntVar(&l.SessionBytes.Burst, "burst-bytes", l.SessionBytes.Burst, "let each client send `n` bytes at once")
+	fs.Float64Var(&l.IPMessages.PerSecond, "ip-rate-messages", l.IPMessages.PerSecond, "let each address send `n` messages a second, or any number if 0")
+	fs.IntVar(&l.IPMessages.Burst, "ip-burst-messages", l.IPMessages.Burst, "let each address send `n` messages at once")
+	fs.Float64Var(&l.IPBytes.PerSecond, "ip-rate-bytes", l.IPBytes.PerSecond, "let each address send `n` bytes a second, or any number if 0")
+	fs.IntVar(&l.IPBytes.Burst, "ip-burst-bytes", l.IPBytes.Burst, "let each address send `n` bytes at once")
+	fs.IntVar(&l.MaxConnections, "max-connections", l.MaxConnections, "accept at most `n` clients, or any number if 0")
+	fs.IntVar(&l.MaxConnectionsPerDocument, "max-connections-per-document", l.MaxConnectionsPerDocument, "accept at most `n` clients per document")
+	fs.IntVar(&l.MaxDocumentSize, "max-document-size", l.MaxDocumentSize, "reject changes that grow a document past `bytes`")
+}
+
+// config returns the server settings that can be reloaded.
+func (o *serveOptions) config() (server.Config, error) {
+	c := server.Config{
+		ACL:               o.acl,
+		DefaultRole:       comms.Role(o.defaultRole),
+		HeartbeatInterval: o.heartbeat,
+		Limits:            o.limits,
+		Debug:             o.debug,
+	}
+	if c.DefaultRole != "" && !c.DefaultRole.Valid() {
+		return c, usageError("unknown role " + o.defaultRole)
+	}
+	if keys := (auth.Keys{Secret: o.secret, TokenKey: []byte(o.tokenKey)}); keys.Secret != "" || len(keys.TokenKey) > 0 {
+		c.Auth = keys
+	}
+	return c, nil
+}
+
+func serve(fs *flag.FlagSet) func(args []string) error {
+	var o serveOptions
+	o.flags(fs)
+
+	return func(args []string) error {
+		if len(args) > 0 {
+			return usageError("serve takes no arguments")
+		}
+		if len(o.listen) == 0 {
+			o.listen = listFlag{":9000"}
+		}
+		if o.admin != "" && o.adminKey == "" {
+			return usageError("-admin needs -admin-token")
+		}
+		comms.MaxPayloadSize = o.maxSize
+		w := io.Writer(os.Stderr)
+		if o.logFile != "" {
+			f, err := os.OpenFile(o.logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		logger, err := o.log.logger(w)
+		if err != nil {
+			return err
+		}
+
+		c, err := o.config()
+		if err != nil {
+			return err
+		}
+		s := server.Server{Logger: logger}
+		s.Auth, s.ACL, s.DefaultRole = c.Auth, c.ACL, c.DefaultRole
+		s.HeartbeatInterval, s.Limits, s.Debug = c.HeartbeatInterval, c.Limits, c.Debug
+		if o.data != "" {
+			dir, err := store.Open(o.data)
+			if err != nil {
+				return err
+			}
+			defer dir.Close()
+			s.Store = dir
+			go func() {
+				for range time.Tick(o.sync) {
+					if err := dir.Sync(); err != nil {
+						logger.Error("saving documents", "error", err)
+					}
+				}
+			}()
+		}
+
+		var conf *tls.Config
+		if o.tlsCert != "" {
+			if conf, err = tlsconf.Server(o.tlsCert, o.tlsKey, o.clientCA); err != nil {
+				return err
+			}
+		}
+		var listeners []net.Listener
+		for _, addr := range o.listen {
+			l, err := listenOn(addr, conf)
+			if err != nil {
+				return err
+			}
+			listeners = append(listeners, l)
+		}
+		s.Init()
+		go s.Start()
+
+		errs := make(chan error, len(listeners)+3)
+		for _, l := range listeners {
+			go func() {
+				if err := s.Serve(l); !errors.Is(err, server.ServerClosedError) {
+					errs <- err
+				}
+			}()
+			logger.Info("serving", "addr", l.Addr().String())
+		}
+
+		// Browsers join over WebSockets, served alongside the web editor.
+		var hs *http.Server
+		if o.http != "" {
+			hl, err := listenOn(o.http, conf)
+			if err != nil {
+				return err
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/ws", websocket.Handler(s.Accept, o.origins))
+			mux.Handle("/", web.Handler("/ws"))
+			hs = &http.Server{Handler: mux}
+			go func() {
+				if err := hs.Serve(hl); !errors.Is(err, http.ErrServerClosed) {
+					errs <- err
+				}
+			}()
+			logger.Info("serving the web editor", "addr", hl.Addr().String())
+		}
+
+		// Metrics are served without TLS, for scrapers on a private network.
+		if o.metrics != "" {
+			ml, err := listenOn(o.metrics, nil)
+			if err != nil {
+				return err
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", s.MetricsHandler())
+			ms := &http.Server{Handler: mux}
+			defer ms.Close()
+			go func() {
+				if err := ms.Serve(ml); !errors.Is(err, http.ErrServerClosed) {
+					errs <- err
+				}
+			}()
+			logger.Info("serving metrics", "addr", ml.Addr().String())
+		}
+
+		if o.admin != "" {
+			al, err := listenOn(o.admin, nil)
+			if err != nil {
+				return err
+			}
+			as := &http.Server{Handler: admin.Handler(&s, o.adminKey)}
+			defer as.Close()
+			go func() {
+				if err := as.Serve(al); !errors.Is(err, http.ErrServerClosed) {
+					errs <- err
+				}
+			}()
+			logger.Info("serving the admin API", "addr", al.Addr().String())
+		}
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	wait:
+		for {
+			select {
+			case <-hup:
+				o.reload(&s, logger)
+			case <-ctx.Done():
+				break wait
+			case err = <-errs:
+				break wait
+			}
+		}
+		stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+		defer cancel()
+		if hs != nil {
+			// WebSocket sessions are hijacked, so the server's own shutdown is
+			// what closes them.
+			hs.Shutdown(ctx)
+		}
+		return errors.Join(err, s.Shutdown(ctx))
+	}
+}
+
+// reload reads the command line and config file again and applies any
+// settings that can change while the server runs. If the new settings are
+// invalid, the old ones are kept.
+func (o *serveOptions) reload(s *server.Server, logger *slog.Logger) {
+	var next serveOptions
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	next.flags(fs)
+	if err := reparse(fs); err != nil {
+		logger.Error("not reloading", "error", err)
+		return
+	}
+	c, err := next.config()
+	if err != nil {
+		logger.Error("not reloading", "error", err)
+		return
+	}
+	if len(next.listen) == 0 {
+		next.listen = listFlag{":9000"}
+	}
+
+	var ignored []string
+	for name, changed := range map[string]bool{
+		"listen":           !slices.Equal(next.listen, o.listen),
+		"http":             next.http != o.http,
+		"allow-origin":     !slices.Equal(next.origins, o.origins),
+		"metrics":          next.metrics != o.metrics,
+		"admin":            next.admin != o.admin,
+		"admin-token":      next.adminKey != o.adminKey,
+		"data":             next.data != o.data,
+		"sync-interval":    next.sync != o.sync,
+		"tls-cert":         next.tlsCert != o.tlsCert,
+		"tls-key":          next.tlsKey != o.tlsKey,
+		"tls-client-ca":    next.clientCA != o.clientCA,
+		"shutdown-timeout": next.timeout != o.timeout,
+		"log":              next.logFile != o.logFile,
+		"max-message-size": next.maxSize != o.maxSize,
+		"log-format":       next.log.format != o.log.format,
+	} {
+		if changed {
+			ignored = append(ignored, name)
+		}
+	}
+	if len(ignored) > 0 {
+		slices.Sort(ignored)
+		logger.Warn("restart to change " + strings.Join(ignored, ", "))
+	}
+
+	o.log.level.Set(next.log.level.Level())
+	s.Reload(c)
+	logger.Info("reloaded settings")
+}
+
+// listenOn listens on a TCP address, or on a Unix domain socket given as
+// unix:/path/to/socket, serving TLS if conf is set.
+func listenOn(addr string, conf *tls.Config) (net.Listener, error) {
+	l, err := comms.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	if conf != nil {
+		l = tls.NewListener(l, conf)
+	}
+	return l, nil
+}
+
+// A listFlag collects every value of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// An aclFlag collects -acl flags into a server's ACL.
+type aclFlag map[string]map[string]comms.Role
+
+func (a aclFlag) String() string {
+	return ""
+}
+
+func (a aclFlag) Set(v string) error {
+	doc, rest, ok1 := strings.Cut(v, ":")
+	user, role, ok2 := strings.Cut(rest, "=")
+	if !ok1 || !ok2 || user == "" {
+		return fmt.Errorf("expected document:user=role, got %q", v)
+	}
+	if r := comms.Role(role); !r.Valid() {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if a[doc] == nil {
+		a[doc] = make(map[string]comms.Role)
+	}
+	a[doc][user] = comms.Role(role)
+	return nil
+}
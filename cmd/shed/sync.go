@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/shed-protocol/shed"
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+func syncFile(fs *flag.FlagSet) func(args []string) error {
+	var r remote
+	r.flags(fs)
+	r.log.flags(fs, slog.LevelWarn)
+	interval := fs.Duration("interval", 250*time.Millisecond, "check for changes this often")
+	prefer := fs.String("prefer", "", "if the file and document differ to begin with, keep the `side` given, local or remote")
+	var ignore listFlag
+	fs.Var(&ignore, "ignore", "when syncing a directory, leave out files and directories matching `pattern` (repeatable)")
+
+	return func(args []string) error {
+		if len(args) != 2 {
+			return usageError("sync takes the server's address and a file or directory")
+		}
+		if *prefer != "" && *prefer != "local" && *prefer != "remote" {
+			return usageError("-prefer must be local or remote")
+		}
+		for _, pattern := range ignore {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return usageError(fmt.Sprintf("bad -ignore pattern %q", pattern))
+			}
+		}
+		info, err := os.Stat(args[1])
+		dir := err == nil && info.IsDir()
+		if dir && r.creds() == nil {
+			return usageError("syncing a directory needs a server that hosts several documents; give -secret or -token")
+		}
+		logger, err := r.log.logger(os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		changed := make(chan struct{}, 1)
+		wake := func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+		newClient := func() *shed.Client {
+			return &shed.Client{Name: "shed sync", Logger: logger, OnRemote: func(shed.Operation) { wake() }}
+		}
+		c := newClient()
+		var s syncer = &fileSync{path: args[1], client: c, logger: logger.With("file", args[1])}
+		if dir {
+			// Documents are named by their files' paths under the
+			// document given, if any.
+			w := &workspace{dir: args[1], ignore: ignore, client: c, logger: logger}
+			if r.document != "" {
+				w.prefix = r.document + "/"
+			}
+			w.open = func(doc string) (*shed.Client, error) {
+				c := newClient()
+				c.Document = doc
+				return c, r.open(args[0], c)
+			}
+			c.OnDocuments = func(e shed.DocumentEvent) {
+				w.notify(e)
+				wake()
+			}
+			defer w.close()
+			s = w
+		}
+		if err := r.open(args[0], c); err != nil {
+			return err
+		}
+		defer c.Close()
+		if err := s.start(*prefer); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		tick := time.NewTicker(*interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+			case <-changed:
+			case <-c.Done():
+				return comms.ErrorMessage{Code: comms.CONNECTION_LOST, Message: "lost connection to the server"}
+			case <-ctx.Done():
+				return s.sync(true)
+			}
+			if err := s.sync(false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// A syncer keeps something on disk and the server the same, syncing
+// repeatedly once started.
+type syncer interface {
+	start(prefer string) error
+	sync(final bool) error
+}
+
+// A fileSync keeps a file and a shared document the same. Changes to either
+// are merged into the other, and changes made to both at once are merged as
+// though the file's were made after the document's.
+type fileSync struct {
+	path   string
+	client *shed.Client
+	logger *slog.Logger
+
+	// last is the text the file and document last agreed on.
+	last string
+
+	// seen is what the file held when it was last read, and stat described
+	// it then.
+	seen string
+	stat fs.FileInfo
+}
+
+// start makes the file and the document the same to begin with. A missing or
+// empty file takes the document's text, and an empty document the file's;
+// otherwise they must agree unless prefer names the side to keep.
+func (s *fileSync) start(prefer string) error {
+	doc := s.client.Text()
+	file, err := os.ReadFile(s.path)
+	missing := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !missing {
+		return err
+	}
+	switch {
+	case missing:
+		if err := s.write(doc); err != nil {
+			return err
+		}
+	case string(file) == doc:
+	case doc == "" || prefer == "local":
+		if err := s.client.Edit(func(text string) []shed.Operation { return shed.Diff(text, string(file)) }); err != nil {
+			return err
+		}
+		doc = string(file)
+	case len(file) == 0 || prefer == "remote":
+		if err := s.write(doc); err != nil {
+			return err
+		}
+	default:
+		return usageError(fmt.Sprintf("%s and the document differ; choose which to keep with -prefer", s.path))
+	}
+	s.last = doc
+	_, _, err = s.read()
+	return err
+}
+
+// sync merges the changes made to the file and the document since they last
+// agreed, sending the file's to the server and writing the result to the
+// file. Changes to the file are only taken once it has stopped changing, so
+// that a half-written file isn't sent, unless this is the final sync.
+func (s *fileSync) sync(final bool) error {
+	file, settled, err := s.read()
+	if errors.Is(err, fs.ErrNotExist) {
+		// Editors may remove a file while saving it.
+		return nil
+	}
+	if err != nil || !settled && !final {
+		return err
+	}
+
+	var text string
+	err = s.client.Edit(func(doc string) []shed.Operation {
+		local := shed.Diff(s.last, file)
+		for _, r := range shed.Diff(s.last, doc) {
+			for i, l := range local {
+				local[i], r = l.Rebase(r), r.Rebase(l)
+			}
+		}
+		merged := shed.NewDocument(doc)
+		for _, op := range local {
+			merged.Apply(op)
+		}
+		text = merged.Text()
+		return local
+	})
+	if err != nil {
+		return err
+	}
+	if file != s.last {
+		s.logger.Info("sent changes made to the file")
+	}
+	if text != file {
+		if err := s.write(text); err != nil {
+			return err
+		}
+		s.logger.Info("wrote changes made to the document")
+	}
+	s.last = text
+	return nil
+}
+
+// read returns what the file holds, reading it again only if it has changed
+// since it was last read, and reports whether it has stopped changing: that
+// it held the same when last read or is what was last synced.
+func (s *fileSync) read() (text string, settled bool, err error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", false, err
+	}
+	if s.stat != nil && info.Size() == s.stat.Size() && info.ModTime().Equal(s.stat.ModTime()) {
+		return s.seen, true, nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", false, err
+	}
+	text = string(b)
+	settled = text == s.seen || text == s.last
+	s.seen, s.stat = text, info
+	return text, settled, nil
+}
+
+// write replaces the file with text all at once, so that nothing reading it
+// sees it half-written, and remembers it as read.
+func (s *fileSync) write(text string) error {
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(s.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := errors.Join(tmp.Chmod(mode), tmp.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.seen, s.stat = text, info
+	return nil
+}
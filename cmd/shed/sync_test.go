@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shed-protocol/shed"
+)
+
+// A peer is a client whose remote changes and acknowledgements are counted
+// on channels.
+type peer struct {
+	*shed.Client
+	remote, acked chan struct{}
+}
+
+// join connects a peer to s.
+func join(t *testing.T, s *shed.Server, name string) peer {
+	t.Helper()
+	p := peer{remote: make(chan struct{}, 8), acked: make(chan struct{}, 8)}
+	p.Client = &shed.Client{
+		Name:     name,
+		OnRemote: func(shed.Operation) { p.remote <- struct{}{} },
+		OnAck:    func() { p.acked <- struct{}{} },
+	}
+	c := p.Client
+	a, b := shed.Pipe()
+	s.Accept(a)
+	if err := c.Connect(b); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return p
+}
+
+// edit makes a change as p and waits for the server to acknowledge it.
+func (p peer) edit(t *testing.T, op shed.Operation) {
+	t.Helper()
+	if err := p.Submit(op); err != nil {
+		t.Fatal(err)
+	}
+	<-p.acked
+}
+
+func TestFileSyncMergesChanges(t *testing.T) {
+	// Given a file is synced with a document another client has written
+	var server shed.Server
+	bob := join(t, &server, "bob")
+	bob.edit(t, shed.Insertion{Text: "one\ntwo\nthree\n"})
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	synced := join(t, &server, "shed sync")
+	s := &fileSync{path: path, client: synced.Client, logger: slog.New(slog.DiscardHandler)}
+	if err := s.start(""); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "one\ntwo\nthree\n" {
+		t.Fatalf("file holds %q to begin with", b)
+	}
+
+	// When the document and the file are changed at once
+	bob.edit(t, shed.Insertion{Pos: 0, Text: "zero\n"})
+	<-synced.remote
+	os.WriteFile(path, []byte("one\n2\nthree\n"), 0o644)
+
+	// Then the file shouldn't be taken until it has settled
+	if err := s.sync(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := bob.Text(); got != "zero\none\ntwo\nthree\n" {
+		t.Errorf("Bob has %q before the file settled", got)
+	}
+
+	// Then both changes should be kept in the file and the document
+	if err := s.sync(false); err != nil {
+		t.Fatal(err)
+	}
+	<-bob.remote
+	<-bob.remote
+	want := "zero\none\n2\nthree\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("file holds %q, expected %q", b, want)
+	}
+	if got := bob.Text(); got != want {
+		t.Errorf("Bob has %q, expected %q", got, want)
+	}
+
+	// When nothing changes
+	// Then nothing should be sent or written
+	before, _ := os.Stat(path)
+	if err := s.sync(false); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path); !after.ModTime().Equal(before.ModTime()) {
+		t.Error("file was written again")
+	}
+	select {
+	case <-bob.remote:
+		t.Error("Bob received a change")
+	default:
+	}
+}
+
+func TestFileSyncRequiresChoiceWhenBothDiffer(t *testing.T) {
+	var server shed.Server
+	bob := join(t, &server, "bob")
+	bob.edit(t, shed.Insertion{Text: "remote"})
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("local"), 0o644)
+	s := &fileSync{path: path, client: join(t, &server, "shed sync").Client}
+
+	var u usageError
+	if err := s.start(""); !errors.As(err, &u) {
+		t.Errorf("start returned %v, expected a usage error", err)
+	}
+	if err := s.start("remote"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "remote" {
+		t.Errorf("file holds %q, expected the document's text", b)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/auth"
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+func token(fs *flag.FlagSet) func(args []string) error {
+	key := fs.String("key", os.Getenv("SHED_TOKEN_KEY"), "sign the token with `key`, as given to \"shed serve -token-key\" (SHED_TOKEN_KEY)")
+	ttl := fs.Duration("ttl", 24*time.Hour, "let the token be used for this long, or forever if 0")
+	role := fs.String("role", "", "grant `role` (owner, editor or viewer) to the holder")
+
+	return func(args []string) error {
+		if len(args) < 1 {
+			return usageError("token takes the name of its holder")
+		}
+		if *key == "" {
+			return usageError("token needs -key")
+		}
+		id := auth.Identity{Name: args[0], Documents: args[1:], Role: comms.Role(*role)}
+		if id.Role != "" && !id.Role.Valid() {
+			return usageError("unknown role " + *role)
+		}
+		if len(id.Documents) == 0 {
+			id.Documents = nil
+		}
+		var expires time.Time
+		if *ttl > 0 {
+			expires = time.Now().Add(*ttl)
+		}
+		fmt.Println(auth.IssueToken([]byte(*key), id, expires))
+		return nil
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/shed-protocol/shed"
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+// A workspace keeps the files in a directory and the documents under a prefix
+// the same, with a fileSync for each. Files created, deleted and renamed in
+// the directory are created, deleted and renamed on the server, and the other
+// way round.
+type workspace struct {
+	dir    string
+	prefix string
+
+	// ignore holds patterns for path.Match. Patterns with a slash match the
+	// whole of a file's path in the directory, and others match any one of
+	// its elements. .git is always ignored.
+	ignore []string
+
+	// client lists, creates, deletes and renames documents, and open
+	// connects a new client to a document so that a file can be synced.
+	client *shed.Client
+	open   func(doc string) (*shed.Client, error)
+	logger *slog.Logger
+
+	// files holds the synced files by their slash-separated paths in the
+	// directory.
+	files map[string]*file
+
+	// fresh holds files that have appeared in the directory as they were
+	// when last seen, so that they are only sent once they have settled.
+	fresh map[string]fs.FileInfo
+
+	// skipped holds files that can't be synced, so that they aren't tried
+	// again.
+	skipped map[string]bool
+
+	// events queues what OnDocuments reports until sync handles it.
+	mu     sync.Mutex
+	events []shed.DocumentEvent
+}
+
+// A file is one of a workspace's files.
+type file struct {
+	*fileSync
+	name string
+
+	// missing is set while the file is absent from the directory. Files
+	// absent twice running have been deleted or renamed, rather than
+	// replaced by an editor saving them.
+	missing bool
+}
+
+// lost reports whether the file's client has been disconnected.
+func (f *file) lost() bool {
+	select {
+	case <-f.client.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// notify queues an event reported by OnDocuments.
+func (w *workspace) notify(e shed.DocumentEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.events = append(w.events, e)
+}
+
+// start makes the directory and the documents the same to begin with, taking
+// files and documents found on only one side to the other and starting each
+// file's sync as fileSync.start does.
+func (w *workspace) start(prefer string) error {
+	w.files = make(map[string]*file)
+	w.fresh = make(map[string]fs.FileInfo)
+	w.skipped = make(map[string]bool)
+	names, err := w.client.Documents(w.prefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if rel, ok := w.path(name); ok {
+			if err := w.track(rel, prefer); err != nil {
+				return err
+			}
+		}
+	}
+	present, err := w.scan()
+	if err != nil {
+		return err
+	}
+	for rel := range present {
+		if w.files[rel] == nil {
+			if err := w.create(rel, prefer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sync handles the documents created, deleted and renamed since the last
+// sync, syncs each file, and sends the files created, deleted and renamed in
+// the directory. Files that appear are only sent once they have settled,
+// unless this is the final sync.
+func (w *workspace) sync(final bool) error {
+	if err := w.handle(); err != nil {
+		return err
+	}
+	if err := w.reconnect(); err != nil {
+		return err
+	}
+	present, err := w.scan()
+	if err != nil {
+		return err
+	}
+
+	var gone []*file
+	for _, rel := range slices.Sorted(maps.Keys(w.files)) {
+		f := w.files[rel]
+		if _, ok := present[rel]; !ok {
+			if f.missing {
+				gone = append(gone, f)
+			}
+			f.missing = true
+			continue
+		}
+		f.missing = false
+		if err := f.sync(final); err != nil && !f.lost() {
+			return err
+		}
+	}
+
+	for rel := range w.fresh {
+		if _, ok := present[rel]; !ok || w.files[rel] != nil {
+			delete(w.fresh, rel)
+		}
+	}
+	for _, rel := range slices.Sorted(maps.Keys(present)) {
+		info := present[rel]
+		if w.files[rel] != nil || w.skipped[rel] {
+			continue
+		}
+		if seen, ok := w.fresh[rel]; !final && (!ok || seen.Size() != info.Size() || !seen.ModTime().Equal(info.ModTime())) {
+			w.fresh[rel] = info
+			continue
+		}
+		delete(w.fresh, rel)
+		if i := w.renamed(gone, rel, info); i >= 0 {
+			if err := w.rename(gone[i], rel); err != nil {
+				return err
+			}
+			gone = slices.Delete(gone, i, i+1)
+			continue
+		}
+		if err := w.create(rel, ""); err != nil {
+			return w.skip(rel, err)
+		}
+	}
+
+	for _, f := range gone {
+		if err := w.delete(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle makes the changes to the directory that the queued events report.
+func (w *workspace) handle() error {
+	w.mu.Lock()
+	events := w.events
+	w.events = nil
+	w.mu.Unlock()
+
+	for _, e := range events {
+		rel, ok := w.path(e.Name)
+		f := w.files[rel]
+		if !ok {
+			f = nil
+		}
+		to, toOk := "", false
+		if e.Action == shed.Renamed {
+			to, toOk = w.path(e.To)
+		}
+		log := w.logger.With("by", e.Author)
+		switch {
+		case e.Action == shed.Created && ok && f == nil:
+			log.Info("document created", "file", rel)
+			if err := w.track(rel, ""); err != nil {
+				return w.skip(rel, err)
+			}
+		case e.Action == shed.Renamed && f != nil && toOk:
+			log.Info("document renamed", "file", rel, "to", to)
+			f.client.Close()
+			delete(w.files, rel)
+			dst := filepath.Join(w.dir, filepath.FromSlash(to))
+			if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+				return err
+			}
+			if err := os.Rename(f.path, dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if err := w.attach(to, f.last); err != nil {
+				return err
+			}
+		case e.Action == shed.Renamed && f == nil && toOk:
+			log.Info("document renamed", "file", to)
+			if err := w.track(to, ""); err != nil {
+				return w.skip(to, err)
+			}
+		case f != nil && (e.Action == shed.Deleted || e.Action == shed.Renamed):
+			log.Info("document deleted", "file", rel)
+			f.client.Close()
+			delete(w.files, rel)
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reconnect reopens the documents of files whose clients were disconnected,
+// as when an administrator removes them, unless they have gone.
+func (w *workspace) reconnect() error {
+	var lost []*file
+	for _, f := range w.files {
+		if f.lost() {
+			lost = append(lost, f)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	// Events sent before the list are queued by the time it arrives, so
+	// documents that have gone are handled first.
+	names, err := w.client.Documents(w.prefix)
+	if err != nil {
+		return err
+	}
+	if err := w.handle(); err != nil {
+		return err
+	}
+	for _, f := range lost {
+		rel, _ := w.path(f.name)
+		if w.files[rel] != f {
+			continue
+		}
+		delete(w.files, rel)
+		if !slices.Contains(names, f.name) {
+			continue
+		}
+		w.logger.Info("reconnecting", "file", rel)
+		if err := w.attach(rel, f.last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan lists the regular files in the directory that aren't ignored, by
+// their slash-separated paths.
+func (w *workspace) scan() (map[string]fs.FileInfo, error) {
+	present := make(map[string]fs.FileInfo)
+	err := filepath.WalkDir(w.dir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p != w.dir {
+			// Directories may be removed while they are walked.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(w.dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if w.ignored(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		present[rel] = info
+		return err
+	})
+	return present, err
+}
+
+// ignored reports whether a file or directory is left out of the workspace.
+func (w *workspace) ignored(rel string) bool {
+	for _, pattern := range append([]string{".git"}, w.ignore...) {
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, rel); ok {
+				return true
+			}
+			continue
+		}
+		for elem := range strings.SplitSeq(rel, "/") {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// path returns the path in the directory of the named document, if it is
+// under the workspace's prefix, names a file inside the directory and isn't
+// ignored.
+func (w *workspace) path(name string) (string, bool) {
+	rel, ok := strings.CutPrefix(name, w.prefix)
+	if !ok || !filepath.IsLocal(filepath.FromSlash(rel)) || path.Clean(rel) != rel || w.ignored(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// track starts syncing a file with its document, as fileSync.start does.
+func (w *workspace) track(rel, prefer string) error {
+	s, err := w.connect(rel)
+	if err != nil {
+		return err
+	}
+	if err := s.start(prefer); err != nil {
+		s.client.Close()
+		return err
+	}
+	w.files[rel] = &file{fileSync: s, name: w.prefix + rel}
+	return nil
+}
+
+// skip leaves a file unsynced if err says that it and its document differ or
+// that it may not be synced, rather than stopping the sync, since there is no
+// one to choose which to keep or ask for access once it is running.
+func (w *workspace) skip(rel string, err error) error {
+	var u usageError
+	var e shed.Error
+	switch {
+	case errors.As(err, &u):
+		w.logger.Warn("the file and its document differ; leaving it unsynced", "file", rel)
+	case errors.As(err, &e) && e.Code == comms.FORBIDDEN:
+		w.logger.Warn("not allowed to sync the file", "file", rel, "error", e.Message)
+	default:
+		return err
+	}
+	w.skipped[rel] = true
+	return nil
+}
+
+// attach resumes syncing a file with its document from last, the text they
+// last agreed on, merging what has changed on either side since.
+func (w *workspace) attach(rel, last string) error {
+	s, err := w.connect(rel)
+	if err != nil {
+		return err
+	}
+	s.last = last
+	if err := s.sync(true); err != nil {
+		s.client.Close()
+		return err
+	}
+	w.files[rel] = &file{fileSync: s, name: w.prefix + rel}
+	return nil
+}
+
+// connect opens a file's document, making the directory the file goes in.
+func (w *workspace) connect(rel string) (*fileSync, error) {
+	p := filepath.Join(w.dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	c, err := w.open(w.prefix + rel)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSync{path: p, client: c, logger: w.logger.With("file", rel)}, nil
+}
+
+// create sends a file that has appeared in the directory as a new document.
+// Files that aren't text are skipped.
+func (w *workspace) create(rel, prefer string) error {
+	b, err := os.ReadFile(filepath.Join(w.dir, filepath.FromSlash(rel)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(b) {
+		w.logger.Warn("skipping file that isn't text", "file", rel)
+		w.skipped[rel] = true
+		return nil
+	}
+	err = w.client.CreateDocument(w.prefix + rel)
+	var e shed.Error
+	if errors.As(err, &e) && e.Code == comms.EXISTS {
+		// Someone else created it first.
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	w.logger.Info("created document", "file", rel)
+	return w.track(rel, prefer)
+}
+
+// delete deletes the document of a file that has gone from the directory.
+func (w *workspace) delete(f *file) error {
+	rel, _ := w.path(f.name)
+	f.client.Close()
+	delete(w.files, rel)
+	err := w.client.DeleteDocument(f.name)
+	var e shed.Error
+	if errors.As(err, &e) && e.Code == comms.NO_SUCH_DOC {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	w.logger.Info("deleted document", "file", rel)
+	return nil
+}
+
+// rename renames the document of a file that has moved in the directory.
+func (w *workspace) rename(f *file, rel string) error {
+	from, _ := w.path(f.name)
+	f.client.Close()
+	delete(w.files, from)
+	if err := w.client.RenameDocument(f.name, w.prefix+rel); err != nil {
+		return err
+	}
+	w.logger.Info("renamed document", "file", from, "to", rel)
+	return w.attach(rel, f.last)
+}
+
+// renamed returns which of the files that have gone from the directory
+// held what a file that has appeared holds, or -1 if none did.
+func (w *workspace) renamed(gone []*file, rel string, info fs.FileInfo) int {
+	var text string
+	for i, f := range gone {
+		if int64(len(f.last)) != info.Size() {
+			continue
+		}
+		if text == "" {
+			b, err := os.ReadFile(filepath.Join(w.dir, filepath.FromSlash(rel)))
+			if err != nil {
+				return -1
+			}
+			text = string(b)
+		}
+		if text == f.last {
+			return i
+		}
+	}
+	return -1
+}
+
+// close stops syncing every file.
+func (w *workspace) close() {
+	for _, f := range w.files {
+		f.client.Close()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/shed-protocol/shed"
+)
+
+// openDoc connects a client to a document on s, which must accept the secret
+// "s3cret".
+func openDoc(t *testing.T, s *shed.Server, c *shed.Client, doc string) *shed.Client {
+	t.Helper()
+	c.Document, c.Secret = doc, "s3cret"
+	a, b := shed.Pipe()
+	s.Accept(a)
+	if err := c.Connect(b); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestWorkspaceMirrorsDirectory(t *testing.T) {
+	// Given a project with one document, and a directory with files of its
+	// own, some of them ignored
+	server := &shed.Server{Secret: "s3cret", DefaultRole: shed.Owner}
+	bob := openDoc(t, server, &shed.Client{Name: "bob"}, "proj")
+	acked := make(chan struct{}, 1)
+	openDoc(t, server, &shed.Client{Name: "bob", OnAck: func() { acked <- struct{}{} }}, "proj/a.txt").Submit(shed.Insertion{Text: "alpha\n"})
+	<-acked
+	dir := t.TempDir()
+	write := func(rel, text string) {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		os.WriteFile(p, []byte(text), 0o644)
+	}
+	read := func(rel string) string {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(b)
+	}
+	write("b.txt", "beta\n")
+	write("sub/c.txt", "gamma\n")
+	write("build/out.o", "ignored\n")
+	write(".git/HEAD", "ignored\n")
+
+	events := make(chan struct{}, 8)
+	w := &workspace{dir: dir, prefix: "proj/", ignore: []string{"build"}, logger: slog.New(slog.DiscardHandler)}
+	w.client = openDoc(t, server, &shed.Client{OnDocuments: func(e shed.DocumentEvent) {
+		w.notify(e)
+		events <- struct{}{}
+	}}, "proj")
+	w.open = func(doc string) (*shed.Client, error) {
+		return openDoc(t, server, &shed.Client{Name: "shed sync"}, doc), nil
+	}
+	t.Cleanup(w.close)
+	expectDocuments := func(want ...string) {
+		t.Helper()
+		if got, err := bob.Documents("proj/"); err != nil || !slices.Equal(got, want) {
+			t.Errorf("the project holds %q, %v, expected %q", got, err, want)
+		}
+	}
+	expectText := func(doc, want string) {
+		t.Helper()
+		if got := openDoc(t, server, &shed.Client{}, doc).Text(); got != want {
+			t.Errorf("%s holds %q, expected %q", doc, got, want)
+		}
+	}
+	sync := func() {
+		t.Helper()
+		if err := w.sync(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// When the workspace starts
+	if err := w.start(""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then every file should have a document and every document a file
+	expectDocuments("proj/a.txt", "proj/b.txt", "proj/sub/c.txt")
+	expectText("proj/sub/c.txt", "gamma\n")
+	if got := read("a.txt"); got != "alpha\n" {
+		t.Errorf("a.txt holds %q", got)
+	}
+
+	// When files are renamed, deleted and created
+	os.Rename(filepath.Join(dir, "b.txt"), filepath.Join(dir, "b2.txt"))
+	os.Remove(filepath.Join(dir, "sub/c.txt"))
+	write("d.txt", "delta\n")
+	sync()
+
+	// Then nothing should be sent until they have settled
+	expectDocuments("proj/a.txt", "proj/b.txt", "proj/sub/c.txt")
+
+	// Then their documents should be renamed, deleted and created
+	sync()
+	expectDocuments("proj/a.txt", "proj/b2.txt", "proj/d.txt")
+	expectText("proj/b2.txt", "beta\n")
+	expectText("proj/d.txt", "delta\n")
+
+	// When someone else creates, renames and deletes documents
+	if err := bob.CreateDocument("proj/e.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.RenameDocument("proj/b2.txt", "proj/sub/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.DeleteDocument("proj/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		<-events
+	}
+	sync()
+
+	// Then the files should follow
+	for rel, want := range map[string]string{
+		"a.txt":     "<missing>",
+		"b2.txt":    "<missing>",
+		"sub/b.txt": "beta\n",
+		"e.txt":     "",
+		"d.txt":     "delta\n",
+	} {
+		if got := read(rel); got != want {
+			t.Errorf("%s holds %q, expected %q", rel, got, want)
+		}
+	}
+	expectDocuments("proj/d.txt", "proj/e.txt", "proj/sub/b.txt")
+}
+
+func TestWorkspaceIgnoresPatterns(t *testing.T) {
+	w := &workspace{ignore: []string{"*.o", "docs/*.tmp", "node_modules"}}
+	for rel, want := range map[string]bool{
+		"main.go":             false,
+		"main.o":              true,
+		"lib/util.o":          true,
+		"docs/draft.tmp":      true,
+		"src/docs/draft.tmp":  false,
+		"node_modules/x/y.js": true,
+		".git/config":         true,
+		".gitignore":          false,
+	} {
+		if got := w.ignored(rel); got != want {
+			t.Errorf("ignored(%q) = %v, expected %v", rel, got, want)
+		}
+	}
+}
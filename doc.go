@@ -0,0 +1,10 @@
+// Package shed embeds shared editing sessions in other programs.
+//
+// A Document holds a buffer and applies Operations to it. A Client joins a
+// session on a server and reports what other participants do through
+// callbacks, and a Server hosts sessions on listeners, WebSockets or in-memory
+// transports created with Pipe.
+//
+// The types in this package are stable; the packages under internal/ that
+// implement them are not.
+package shed
@@ -0,0 +1,97 @@
+package shed
+
+import (
+	"sync"
+
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// An Operation is an atomic edit to a document: an Insertion or a Deletion.
+// Positions and lengths are measured in bytes.
+type Operation = ot.Operation
+
+// An Insertion adds text before a 0-indexed position.
+type Insertion = ot.Insertion
+
+// A Deletion removes text starting from a 0-indexed position.
+type Deletion = ot.Deletion
+
+// OutOfRangeError is returned when an operation reaches past the end of a
+// document.
+var OutOfRangeError = ot.OutOfRangeError
+
+// MalformedPatchError is returned by Patch for text that isn't a unified diff.
+var MalformedPatchError = ot.MalformedPatchError
+
+// PatchConflictError is returned by Patch when the lines a diff changes
+// aren't in the document.
+var PatchConflictError = ot.PatchConflictError
+
+// Diff returns the operations that turn text a into text b, to be applied in
+// order. It changes as little as it can, line by line and then character by
+// character, so that a program that only knows a document's whole text can
+// still take part in a session.
+func Diff(a, b string) []Operation {
+	return ot.Diff(a, b)
+}
+
+// Patch returns the operations that apply a unified diff of one file, as
+// written by diff -u or git diff, to text. Hunks are found near where they
+// say, as the patch command finds them.
+func Patch(text, patch string) ([]Operation, error) {
+	return ot.Patch(text, patch)
+}
+
+// Rebase returns an operation with the same effect as op when applied after
+// on, where op and on were both made to the same version of a document.
+func Rebase(op, on Operation) Operation {
+	return op.Rebase(on)
+}
+
+// A Document is a buffer of text that operations are applied to in turn. It
+// is safe for concurrent use.
+type Document struct {
+	mu       sync.Mutex
+	text     string
+	revision int
+}
+
+// NewDocument returns a document containing text, at revision 0.
+func NewDocument(text string) *Document {
+	return &Document{text: text}
+}
+
+// Apply applies op to the document and advances its revision. The document is
+// left unchanged if op does not fit it.
+func (d *Document) Apply(op Operation) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := ot.Validate(op, d.text); err != nil {
+		return err
+	}
+	d.text = op.Apply(d.text)
+	d.revision++
+	return nil
+}
+
+// reset replaces the contents of the document.
+func (d *Document) reset(text string, revision int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.text = text
+	d.revision = revision
+}
+
+// Text returns the current contents of the document.
+func (d *Document) Text() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.text
+}
+
+// Revision returns the number of operations applied to the document.
+func (d *Document) Revision() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.revision
+}
@@ -0,0 +1,119 @@
+// Package admin serves an HTTP API for inspecting and managing the documents
+// and sessions of a running server.
+//
+// Every request must carry the admin token as "Authorization: Bearer <token>".
+// Document names may contain slashes, and come last in the path:
+//
+//	GET    /documents              list documents
+//	GET    /documents/<name>       fetch a document's text and revision
+//	DELETE /documents/<name>       disconnect everyone and delete a document
+//	POST   /renames/<name>?to=new  disconnect everyone and rename a document
+//	PUT    /locks/<name>           make a document read-only
+//	DELETE /locks/<name>           make it writable again
+//	POST   /snapshots/<name>       save a snapshot of a document
+//	GET    /sessions               list sessions
+//	DELETE /sessions/<id>          disconnect a session
+//
+// Responses are JSON. Errors are objects with an "error" field.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/shed-protocol/shed/internal/server"
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+// Handler serves the admin API for s to clients presenting token.
+func Handler(s *server.Server, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /documents", func(w http.ResponseWriter, r *http.Request) {
+		docs, err := s.Documents()
+		reply(w, docs, err)
+	})
+	mux.HandleFunc("GET /documents/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		doc, err := s.Text(name)
+		reply(w, document{Name: name, Text: doc.Text, Rev: doc.Rev}, err)
+	})
+	mux.HandleFunc("DELETE /documents/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, nil, s.Delete(r.PathValue("name")))
+	})
+	mux.HandleFunc("POST /renames/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		to, ok := r.URL.Query()["to"]
+		if !ok || len(to) != 1 || to[0] == "" {
+			fail(w, http.StatusBadRequest, "give the new name as ?to=")
+			return
+		}
+		reply(w, nil, s.Rename(r.PathValue("name"), to[0]))
+	})
+	mux.HandleFunc("PUT /locks/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, nil, s.Lock(r.PathValue("name"), true))
+	})
+	mux.HandleFunc("DELETE /locks/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, nil, s.Lock(r.PathValue("name"), false))
+	})
+	mux.HandleFunc("POST /snapshots/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, nil, s.Snapshot(r.PathValue("name")))
+	})
+	mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, s.Sessions(), nil)
+	})
+	mux.HandleFunc("DELETE /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			fail(w, http.StatusNotFound, server.NoSuchSessionError.Error())
+			return
+		}
+		reply(w, nil, s.Kick(id))
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			fail(w, http.StatusUnauthorized, "a valid admin token is required")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+type document struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+	Rev  int    `json:"rev"`
+}
+
+// reply writes v as JSON, or the error if there is one. Nothing is written
+// for successful requests without a result.
+func reply(w http.ResponseWriter, v any, err error) {
+	switch {
+	case errors.Is(err, server.NoSuchDocumentError), errors.Is(err, server.NoSuchSessionError):
+		fail(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, store.ExistsError):
+		fail(w, http.StatusConflict, err.Error())
+	case errors.Is(err, server.NoSnapshotsError):
+		fail(w, http.StatusNotImplemented, err.Error())
+	case err != nil:
+		fail(w, http.StatusInternalServerError, err.Error())
+	case v == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func fail(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,226 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/shed-protocol/shed/internal/auth"
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+	"github.com/shed-protocol/shed/internal/server"
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+const token = "t0ken"
+
+// setup starts a server saving to a temporary directory, and returns its
+// admin API and a function that opens a document on it.
+func setup(t *testing.T) (*httptest.Server, func(doc string) comms.Transport) {
+	dir, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dir.Close() })
+	s := &server.Server{Auth: auth.Keys{Secret: "s3cret"}, Store: dir}
+	s.Init()
+	go s.Start()
+	ts := httptest.NewServer(Handler(s, token))
+	t.Cleanup(ts.Close)
+
+	return ts, func(doc string) comms.Transport {
+		a, b := comms.Pipe()
+		t.Cleanup(func() { a.Close() })
+		s.Accept(b)
+		a.Send(comms.Authenticate{Document: doc, Secret: "s3cret"})
+		a.Send(comms.JoinSession{Name: "alice"})
+		receive(t, a, comms.ROSTER)
+		return a
+	}
+}
+
+// do makes a request to the admin API, decoding the response into v if it
+// isn't nil, and returns the response's status.
+func do(t *testing.T, ts *httptest.Server, method, path string, v any) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, ts.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// receive waits for a message of the given kind, skipping any others.
+func receive(t *testing.T, c comms.Transport, kind comms.MessageKind) comms.Message {
+	t.Helper()
+	for {
+		m, err := c.Receive()
+		if err != nil {
+			t.Fatalf("waiting for %s: %s", kind, err)
+		}
+		if m.Kind() == kind {
+			return m
+		}
+	}
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	ts, _ := setup(t)
+	for _, auth := range []string{"", "Bearer wrong", token} {
+		req, _ := http.NewRequest("GET", ts.URL+"/documents", nil)
+		req.Header.Set("Authorization", auth)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%q got status %d, expected 401", auth, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandlerListsDocumentsAndSessions(t *testing.T) {
+	// Given a client has changed a document
+	ts, open := setup(t)
+	c := open("notes/todo")
+	c.Send(comms.OpMessage{Op: ot.Insertion{Text: "hello"}})
+	receive(t, c, comms.ACK_CHANGE)
+
+	// When the documents are listed
+	var docs []server.DocumentInfo
+	if status := do(t, ts, "GET", "/documents", &docs); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+
+	// Then the document should be included with its session
+	want := server.DocumentInfo{Name: "notes/todo", Loaded: true, Rev: 1, Size: 5, Sessions: 1}
+	if len(docs) != 1 || docs[0] != want {
+		t.Errorf("got %+v, expected %+v", docs, want)
+	}
+
+	// When its text is fetched
+	var doc document
+	do(t, ts, "GET", "/documents/notes/todo", &doc)
+
+	// Then it should be the server's copy
+	if doc.Text != "hello" || doc.Rev != 1 {
+		t.Errorf("got %+v", doc)
+	}
+
+	// When the sessions are listed
+	var sessions []server.SessionInfo
+	do(t, ts, "GET", "/sessions", &sessions)
+
+	// Then the client should be included
+	if len(sessions) != 1 || sessions[0].Document != "notes/todo" || sessions[0].Name != "alice" {
+		t.Errorf("got %+v", sessions)
+	}
+}
+
+func TestHandlerKicksSessions(t *testing.T) {
+	// Given a client has joined
+	ts, open := setup(t)
+	c := open("notes")
+	var sessions []server.SessionInfo
+	do(t, ts, "GET", "/sessions", &sessions)
+
+	// When its session is kicked
+	if status := do(t, ts, "DELETE", "/sessions/"+strconv.Itoa(sessions[0].Id), nil); status != http.StatusNoContent {
+		t.Fatalf("got status %d", status)
+	}
+
+	// Then it should be told why and disconnected
+	e := receive(t, c, comms.ERROR).(*comms.ErrorMessage)
+	if e.Code != comms.KICKED {
+		t.Errorf("got %+v, expected to be kicked", e)
+	}
+	if _, err := c.Receive(); err == nil {
+		t.Error("still connected")
+	}
+
+	// When a session that doesn't exist is kicked
+	// Then it should be reported missing
+	if status := do(t, ts, "DELETE", "/sessions/99", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d, expected 404", status)
+	}
+}
+
+func TestHandlerLocksDocuments(t *testing.T) {
+	// Given a document is locked
+	ts, open := setup(t)
+	c := open("notes")
+	if status := do(t, ts, "PUT", "/locks/notes", nil); status != http.StatusNoContent {
+		t.Fatalf("got status %d", status)
+	}
+
+	// When a client changes it
+	c.Send(comms.OpMessage{Op: ot.Insertion{Text: "hello"}})
+
+	// Then the change should be refused
+	if e := receive(t, c, comms.ERROR).(*comms.ErrorMessage); e.Code != comms.READ_ONLY {
+		t.Errorf("got %+v, expected a read-only error", e)
+	}
+
+	// When it is unlocked
+	do(t, ts, "DELETE", "/locks/notes", nil)
+	c.Send(comms.OpMessage{Op: ot.Insertion{Text: "hello"}})
+
+	// Then changes should be accepted again
+	receive(t, c, comms.ACK_CHANGE)
+}
+
+func TestHandlerRenamesAndDeletesDocuments(t *testing.T) {
+	// Given two documents have been changed
+	ts, open := setup(t)
+	for _, doc := range []string{"a", "b"} {
+		c := open(doc)
+		c.Send(comms.OpMessage{Op: ot.Insertion{Text: doc}})
+		receive(t, c, comms.ACK_CHANGE)
+	}
+
+	// When one is renamed over the other
+	// Then it should be refused
+	if status := do(t, ts, "POST", "/renames/a?to=b", nil); status != http.StatusConflict {
+		t.Errorf("got status %d, expected 409", status)
+	}
+
+	// When one is renamed without a new name
+	// Then it should be refused
+	for _, path := range []string{"/renames/a", "/renames/a?to="} {
+		if status := do(t, ts, "POST", path, nil); status != http.StatusBadRequest {
+			t.Errorf("%s got status %d, expected 400", path, status)
+		}
+	}
+
+	// When one is renamed and a snapshot taken, and the other deleted
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/renames/a?to=c"},
+		{"POST", "/snapshots/c"},
+		{"DELETE", "/documents/b"},
+	} {
+		if status := do(t, ts, req.method, req.path, nil); status != http.StatusNoContent {
+			t.Fatalf("%s %s got status %d", req.method, req.path, status)
+		}
+	}
+
+	// Then only the renamed document should be left
+	var docs []server.DocumentInfo
+	do(t, ts, "GET", "/documents", &docs)
+	if len(docs) != 1 || docs[0].Name != "c" || docs[0].Size != 1 {
+		t.Errorf("got %+v", docs)
+	}
+	if status := do(t, ts, "GET", "/documents/a", nil); status != http.StatusNotFound {
+		t.Errorf("got status %d for the old name, expected 404", status)
+	}
+}
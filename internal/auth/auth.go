@@ -0,0 +1,116 @@
+// Package auth verifies the credentials presented by clients when they connect
+// to a server.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+var (
+	InvalidCredentialsError = errors.New("invalid credentials")
+	InvalidTokenError       = errors.New("invalid token")
+	ExpiredTokenError       = errors.New("token has expired")
+)
+
+// An Identity describes who an authenticated client is and what it may edit.
+type Identity struct {
+	// Name is the user the credentials were issued to, if known.
+	Name string `json:"sub,omitempty"`
+
+	// Documents lists the documents the client may open. A nil slice allows
+	// every document, and an empty one none.
+	Documents []string `json:"docs,omitzero"`
+
+	// Role is granted on every document the identity may open, unless the
+	// server assigns it a different one.
+	Role comms.Role `json:"role,omitempty"`
+}
+
+// CanAccess reports whether the identity may open the named document.
+func (id Identity) CanAccess(doc string) bool {
+	return id.Documents == nil || slices.Contains(id.Documents, doc)
+}
+
+// An Authenticator checks the credentials presented in an Authenticate message.
+type Authenticator interface {
+	Authenticate(creds comms.Authenticate) (Identity, error)
+}
+
+// Keys authenticates clients holding either a static shared secret or a bearer
+// token signed with TokenKey. Either may be left empty to disable that method.
+type Keys struct {
+	Secret   string
+	TokenKey []byte
+}
+
+func (k Keys) Authenticate(creds comms.Authenticate) (Identity, error) {
+	switch {
+	case creds.Token != "" && len(k.TokenKey) > 0:
+		return VerifyToken(k.TokenKey, creds.Token, time.Now())
+	case creds.Secret != "" && k.Secret != "":
+		if subtle.ConstantTimeCompare([]byte(creds.Secret), []byte(k.Secret)) == 1 {
+			return Identity{}, nil
+		}
+	}
+	return Identity{}, InvalidCredentialsError
+}
+
+type claims struct {
+	Identity
+	Expires int64 `json:"exp,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// IssueToken returns a bearer token for id signed with key. The token is
+// rejected after expires, unless expires is the zero time.
+func IssueToken(key []byte, id Identity, expires time.Time) string {
+	c := claims{Identity: id}
+	if !expires.IsZero() {
+		c.Expires = expires.Unix()
+	}
+	payload, _ := json.Marshal(c)
+	p := encoding.EncodeToString(payload)
+	return p + "." + encoding.EncodeToString(sign(key, p))
+}
+
+// VerifyToken checks that token was signed with key and has not expired at the
+// given time, and returns the identity it carries.
+func VerifyToken(key []byte, token string, now time.Time) (Identity, error) {
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return Identity{}, InvalidTokenError
+	}
+	sig, err := encoding.DecodeString(s)
+	if err != nil || !hmac.Equal(sig, sign(key, p)) {
+		return Identity{}, InvalidTokenError
+	}
+	payload, err := encoding.DecodeString(p)
+	if err != nil {
+		return Identity{}, InvalidTokenError
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Identity{}, InvalidTokenError
+	}
+	if c.Expires != 0 && now.Unix() >= c.Expires {
+		return Identity{}, ExpiredTokenError
+	}
+	return c.Identity, nil
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
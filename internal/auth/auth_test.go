@@ -0,0 +1,100 @@
+package auth_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/auth"
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+var key = []byte("test signing key")
+
+func TestTokenRoundTrip(t *testing.T) {
+	want := auth.Identity{Name: "alice", Documents: []string{"notes", "todo"}}
+	token := auth.IssueToken(key, want, time.Now().Add(time.Hour))
+
+	got, err := auth.VerifyToken(key, token, time.Now())
+	if err != nil {
+		t.Fatalf("error verifying token: %s", err)
+	}
+	if got.Name != want.Name || !slices.Equal(got.Documents, want.Documents) {
+		t.Errorf("got identity %+v, want %+v", got, want)
+	}
+}
+
+func TestTokenKeepsEmptyDocumentList(t *testing.T) {
+	token := auth.IssueToken(key, auth.Identity{Name: "alice", Documents: []string{}}, time.Time{})
+
+	got, err := auth.VerifyToken(key, token, time.Now())
+	if err != nil {
+		t.Fatalf("error verifying token: %s", err)
+	}
+	if got.Documents == nil || got.CanAccess("notes") {
+		t.Errorf("token for no documents gave identity %+v", got)
+	}
+}
+
+func TestTokenRejectsWrongKey(t *testing.T) {
+	token := auth.IssueToken(key, auth.Identity{Name: "alice"}, time.Time{})
+	if _, err := auth.VerifyToken([]byte("other key"), token, time.Now()); !errors.Is(err, auth.InvalidTokenError) {
+		t.Errorf("expected InvalidTokenError, got %v", err)
+	}
+}
+
+func TestTokenRejectsTampering(t *testing.T) {
+	token := auth.IssueToken(key, auth.Identity{Name: "alice"}, time.Time{})
+	forged := auth.IssueToken([]byte("other key"), auth.Identity{Name: "mallory"}, time.Time{})
+
+	payload, _, _ := strings.Cut(forged, ".")
+	_, sig, _ := strings.Cut(token, ".")
+	if _, err := auth.VerifyToken(key, payload+"."+sig, time.Now()); !errors.Is(err, auth.InvalidTokenError) {
+		t.Errorf("expected InvalidTokenError, got %v", err)
+	}
+}
+
+func TestTokenExpires(t *testing.T) {
+	expires := time.Now()
+	token := auth.IssueToken(key, auth.Identity{Name: "alice"}, expires)
+	if _, err := auth.VerifyToken(key, token, expires.Add(time.Second)); !errors.Is(err, auth.ExpiredTokenError) {
+		t.Errorf("expected ExpiredTokenError, got %v", err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	k := auth.Keys{Secret: "hunter2", TokenKey: key}
+	token := auth.IssueToken(key, auth.Identity{Name: "alice"}, time.Time{})
+
+	cases := []struct {
+		creds comms.Authenticate
+		ok    bool
+	}{
+		{comms.Authenticate{Secret: "hunter2"}, true},
+		{comms.Authenticate{Secret: "hunter3"}, false},
+		{comms.Authenticate{Token: token}, true},
+		{comms.Authenticate{Token: "nonsense"}, false},
+		{comms.Authenticate{}, false},
+	}
+	for _, c := range cases {
+		if _, err := k.Authenticate(c.creds); (err == nil) != c.ok {
+			t.Errorf("Authenticate(%+v) returned %v", c.creds, err)
+		}
+	}
+
+	if _, err := (auth.Keys{}).Authenticate(comms.Authenticate{}); err == nil {
+		t.Errorf("empty Keys accepted empty credentials")
+	}
+}
+
+func TestIdentityCanAccess(t *testing.T) {
+	if !(auth.Identity{}).CanAccess("anything") {
+		t.Errorf("identity without document list should access everything")
+	}
+	id := auth.Identity{Documents: []string{"notes"}}
+	if !id.CanAccess("notes") || id.CanAccess("secrets") {
+		t.Errorf("identity %+v has wrong access", id)
+	}
+}
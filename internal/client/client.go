@@ -1,96 +1,324 @@
 package client
 
 import (
-	"io"
-	"net"
-	"slices"
+	"log/slog"
+	"time"
 
 	"github.com/shed-protocol/shed/internal/comms"
 	"github.com/shed-protocol/shed/internal/ot"
 )
 
 type Client struct {
+	// Auth, if set, is sent to the server as soon as the client connects.
+	Auth *comms.Authenticate
+
+	// HeartbeatInterval, if set, is how often the server is pinged. If it
+	// stays silent for MissedHeartbeats intervals, the editor is told the
+	// connection was lost.
+	HeartbeatInterval time.Duration
+	MissedHeartbeats  int
+
+	// Logger, if set, records what the client does. Every message exchanged
+	// with the server is logged at debug level, or at info level if Debug is
+	// set.
+	Logger *slog.Logger
+	Debug  bool
+
 	eIn  chan<- comms.Message
 	eOut <-chan comms.Message
 
+	self  *comms.Participant
 	queue []comms.Message
 	sent  comms.Message
 	sIn   chan<- comms.Message
 	sOut  <-chan comms.Message
+	done  chan struct{}
+
+	// resync is set once a change has been rejected. The editor's copy of the
+	// document still holds it, so the editor's changes are dropped until it
+	// fetches the document again.
+	resync bool
+
+	// rev is the revision of the document the queued changes are made to, or
+	// -1 until the server has sent the document.
+	rev int
+
+	// received counts the editor's changes, and passed the remote changes
+	// passed on to it. forwarded holds the last of those, which the editor
+	// may not have seen when it made its next change; like the server's
+	// history, they are kept until it does.
+	received, passed int
+	forwarded        []ot.Operation
+}
+
+// discard is the logger of clients that haven't been given one.
+var discard = slog.New(slog.DiscardHandler)
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger == nil {
+		return discard
+	}
+	return c.Logger
 }
 
-func (c *Client) Attach(editor io.ReadWriter) {
+func (c *Client) Attach(editor comms.Transport) {
 	eIn := make(chan comms.Message)
 	eOut := make(chan comms.Message)
 	c.eIn = eIn
 	c.eOut = eOut
-	go comms.ChanToWriter(eIn, editor)
-	go comms.ReaderToChan(editor, eOut)
+	go func() {
+		comms.ChanToTransport(eIn, editor)
+		editor.Close()
+		for range eIn {
+		}
+	}()
+	go func() {
+		comms.TransportToChan(editor, eOut)
+		close(eOut)
+	}()
 }
 
-func (c *Client) Connect(server net.Conn) {
+func (c *Client) Connect(server comms.Transport) error {
 	sIn := make(chan comms.Message)
 	sOut := make(chan comms.Message)
 	c.sIn = sIn
 	c.sOut = sOut
 	c.sent = nil
-	go comms.ChanToWriter(sIn, server)
-	go comms.ReaderToChan(server, sOut)
+	c.resync = false
+	c.rev = -1
+	c.done = make(chan struct{})
+	if c.Auth != nil {
+		if err := server.Send(*c.Auth); err != nil {
+			return err
+		}
+	}
+	level := slog.LevelDebug
+	if c.Debug {
+		level = slog.LevelInfo
+	}
+	server = comms.Logged(server, c.logger(), level)
+	if c.HeartbeatInterval > 0 {
+		server = comms.Heartbeat(server, c.HeartbeatInterval, c.MissedHeartbeats)
+	}
+	go func() {
+		comms.ChanToTransport(sIn, server)
+		server.Close()
+		for range sIn {
+		}
+	}()
+	go func() {
+		err := comms.TransportToChan(server, sOut)
+		c.logger().Info("disconnected from the server", "reason", err)
+		close(sOut)
+	}()
 	go c.loop()
+	return nil
+}
+
+// Done returns a channel that is closed once either the editor or the server
+// has disconnected, after the client has closed its side of both.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
 }
 
 func (c *Client) loop() {
+	defer c.close()
 	for {
+		var send chan<- comms.Message
+		var next comms.Message
+		if c.sent == nil && len(c.queue) > 0 {
+			send, next = c.sIn, c.stamp(c.queue[0])
+		}
+
 		select {
-		case msg := <-c.eOut:
-			if msg.Kind() == comms.BUFFER_OP {
-				c.queue = append(c.queue, msg)
+		case msg, ok := <-c.eOut:
+			if !ok {
+				return
+			}
+			switch msg.Kind() {
+			case comms.BUFFER_OP:
+				msg = c.local(msg)
+				switch {
+				case c.resync:
+					c.logger().Debug("dropped change made before the document was fetched again")
+				case c.self != nil && !c.self.Role.CanEdit():
+					c.logger().Debug("rejected change from viewer")
+					c.reject()
+					c.eIn <- comms.ErrorMessage{Code: comms.READ_ONLY, Message: "viewers cannot edit the document", Change: true}
+				default:
+					c.queue = append(c.queue, msg)
+				}
+			case comms.FETCH_DOCUMENT:
+				c.resync = false
+				c.sIn <- msg
+			case comms.JOIN_SESSION, comms.SET_ROLE,
+				comms.FETCH_HISTORY, comms.FETCH_REVISION, comms.FETCH_DIFF,
+				comms.CREATE_CHECKPOINT, comms.FETCH_CHECKPOINTS, comms.REVERT, comms.FETCH_BLAME,
+				comms.LIST_DOCUMENTS, comms.CREATE_DOCUMENT, comms.DELETE_DOCUMENT, comms.RENAME_DOCUMENT:
+				c.sIn <- msg
+			}
+		case msg, ok := <-c.sOut:
+			if !ok {
+				if c.eIn != nil {
+					c.eIn <- comms.ErrorMessage{Code: comms.CONNECTION_LOST, Message: "lost connection to the server"}
+				}
+				return
 			}
-		case msg := <-c.sOut:
 			switch msg.Kind() {
+			case comms.PING:
+				// Clients that don't ping the server themselves still
+				// answer its pings, lest it take them for gone.
+				c.sIn <- comms.Pong{}
 			case comms.ACK_CHANGE:
+				// Editors may use acknowledgements to tell when their
+				// changes have reached the server.
 				c.sent = nil
-			case comms.BUFFER_OP:
-				{
-					op, _ := asOp(msg)
-					if c.sent != nil {
-						if on, ok := asOp(c.sent); ok {
-							op = op.Rebase(on)
-						}
-					}
-					for _, m := range slices.Backward(c.queue) {
-						if on, ok := asOp(m); ok {
-							op = op.Rebase(on)
-						}
+				c.advance()
+				c.eIn <- msg
+			case comms.ROSTER:
+				c.updateSelf(msg)
+				c.eIn <- msg
+			case comms.ROLE_CHANGED:
+				c.updateSelf(msg)
+				c.eIn <- msg
+			case comms.ERROR:
+				if e, ok := asError(msg); ok {
+					c.logger().Warn("server reported an error", "code", e.Code, "message", e.Message)
+					if e.Rejected() {
+						c.sent = nil
+						c.reject()
 					}
-					c.eIn <- comms.OpMessage{Op: op}
 				}
-				{
-					on, _ := asOp(msg)
-					for i, m := range c.queue {
-						if op, ok := asOp(m); ok {
-							c.queue[i] = comms.OpMessage{Op: op.Rebase(on)}
-						}
-					}
+				c.eIn <- msg
+			case comms.DOCUMENT:
+				if d, ok := msg.(*comms.Document); ok {
+					c.rev = d.Rev
 				}
+				c.eIn <- msg
+			case comms.PARTICIPANT_JOINED, comms.PARTICIPANT_LEFT,
+				comms.HISTORY, comms.REVISION, comms.DIFF, comms.CHECKPOINTS, comms.REVERTED,
+				comms.BLAME, comms.DOCUMENTS, comms.DOCUMENT_EVENT:
+				c.eIn <- msg
+			case comms.BUFFER_OP:
+				c.advance()
+				c.eIn <- c.remote(msg)
 			}
-		default:
-			if c.sent == nil && len(c.queue) > 0 {
-				msg := c.queue[0]
-				c.queue = c.queue[1:]
-				c.sIn <- msg
-				c.sent = msg
+		case send <- next:
+			c.queue = c.queue[1:]
+			c.sent = next
+		}
+	}
+}
+
+// close shuts down both connections once the loop has stopped, discarding
+// anything either side sends in the meantime.
+func (c *Client) close() {
+	close(c.sIn)
+	if c.eIn != nil {
+		close(c.eIn)
+	}
+	go func() {
+		for range c.sOut {
+		}
+	}()
+	if c.eOut != nil {
+		go func() {
+			for range c.eOut {
 			}
+		}()
+	}
+	close(c.done)
+}
+
+// stamp returns the next change to send the server, saying which revision of
+// the document it was made to, if the client knows.
+func (c *Client) stamp(m comms.Message) comms.Message {
+	op, _ := comms.AsOp(m)
+	change := comms.OpMessage{Op: op}
+	if c.rev >= 0 {
+		rev := c.rev
+		change.Rev = &rev
+	}
+	return change
+}
+
+// advance counts a change the server has applied since the client was sent
+// the document.
+func (c *Client) advance() {
+	if c.rev >= 0 {
+		c.rev++
+	}
+}
+
+// remote rebases a change from the server over the editor's changes that
+// the server hadn't had, and them over it, and returns it to pass on to the
+// editor.
+func (c *Client) remote(msg comms.Message) comms.Message {
+	op, _ := comms.AsOp(msg)
+	if c.sent != nil {
+		on, _ := comms.AsOp(c.sent)
+		op, c.sent = op.Rebase(on), comms.OpMessage{Op: on.Rebase(op)}
+	}
+	for i, m := range c.queue {
+		on, _ := comms.AsOp(m)
+		op, c.queue[i] = op.Rebase(on), comms.OpMessage{Op: on.Rebase(op)}
+	}
+
+	c.passed++
+	c.forwarded = append(c.forwarded, op)
+	received := c.received
+	change := comms.OpMessage{Op: op, Seen: &received}
+	if m, ok := msg.(*comms.OpMessage); ok {
+		change.Author = m.Author
+	}
+	return change
+}
+
+// local rebases a change from the editor over the remote changes it hadn't
+// seen, and them over it, and returns it to queue.
+func (c *Client) local(msg comms.Message) comms.Message {
+	c.received++
+	op, _ := comms.AsOp(msg)
+	unseen := 0
+	if m, ok := msg.(*comms.OpMessage); ok && m.Seen != nil {
+		unseen = min(max(c.passed-*m.Seen, 0), len(c.forwarded))
+	}
+	c.forwarded = c.forwarded[len(c.forwarded)-unseen:]
+	for i, on := range c.forwarded {
+		op, c.forwarded[i] = op.Rebase(on), on.Rebase(op)
+	}
+	return comms.OpMessage{Op: op}
+}
+
+// reject drops the editor's changes that are yet to be sent, which were made
+// on top of a rejected one, until the editor fetches the document again.
+func (c *Client) reject() {
+	c.queue = nil
+	c.resync = true
+}
+
+// updateSelf keeps track of the participant the server says this client is.
+func (c *Client) updateSelf(m comms.Message) {
+	switch m := m.(type) {
+	case *comms.Roster:
+		for _, p := range m.Participants {
+			if p.Id == m.Self {
+				c.self = &p
+			}
+		}
+	case *comms.RoleChanged:
+		if c.self != nil && c.self.Id == m.Id {
+			c.self.Role = m.Role
 		}
 	}
 }
 
-func asOp(m comms.Message) (op ot.Operation, ok bool) {
+func asError(m comms.Message) (e comms.ErrorMessage, ok bool) {
 	switch m := m.(type) {
-	case comms.OpMessage:
-		op, ok = m.Op, true
-	case *comms.OpMessage:
-		op, ok = m.Op, true
+	case comms.ErrorMessage:
+		e, ok = m, true
+	case *comms.ErrorMessage:
+		e, ok = *m, true
 	}
 	return
 }
@@ -1,41 +1,41 @@
 package client
 
 import (
-	"net"
 	"testing"
+	"time"
 
 	"github.com/shed-protocol/shed/internal/comms"
 	"github.com/shed-protocol/shed/internal/ot"
 )
 
 type MockEditor struct {
-	client net.Conn
+	client comms.Transport
 	local  chan comms.Message
 	remote chan comms.Message
 }
 
-func (e *MockEditor) Init(c net.Conn) {
+func (e *MockEditor) Init(c comms.Transport) {
 	e.client = c
 	e.local = make(chan comms.Message)
 	e.remote = make(chan comms.Message)
-	go comms.ChanToWriter(e.local, e.client)
-	go comms.ReaderToChan(e.client, e.remote)
+	go comms.ChanToTransport(e.local, e.client)
+	go comms.TransportToChan(e.client, e.remote)
 }
 
 type MockServer struct {
-	client net.Conn
+	client comms.Transport
 	cIn    chan<- comms.Message
 	cOut   <-chan comms.Message
 }
 
-func (s *MockServer) Accept(c net.Conn) {
+func (s *MockServer) Accept(c comms.Transport) {
 	cIn := make(chan comms.Message)
 	cOut := make(chan comms.Message)
 	s.client = c
 	s.cIn = cIn
 	s.cOut = cOut
-	go comms.ChanToWriter(cIn, c)
-	go comms.ReaderToChan(s.client, cOut)
+	go comms.ChanToTransport(cIn, c)
+	go comms.TransportToChan(s.client, cOut)
 }
 
 func setupSingleClient() (c *Client, e *MockEditor, s *MockServer, teardown func()) {
@@ -43,11 +43,11 @@ func setupSingleClient() (c *Client, e *MockEditor, s *MockServer, teardown func
 	e = new(MockEditor)
 	s = new(MockServer)
 
-	a1, b1 := net.Pipe()
+	a1, b1 := comms.Pipe()
 	c.Attach(a1)
 	e.Init(b1)
 
-	a2, b2 := net.Pipe()
+	a2, b2 := comms.Pipe()
 	c.Connect(a2)
 	s.Accept(b2)
 
@@ -103,17 +103,81 @@ func TestClientSendsOneMessageAtATime(t *testing.T) {
 	}
 }
 
+func TestClientStopsWaitingOnlyForRejectedChanges(t *testing.T) {
+	// Given the client has sent one change and queued another
+	_, e, s, teardown := setupSingleClient()
+	defer teardown()
+	e.local <- comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "hello"}}
+	e.local <- comms.OpMessage{Op: ot.Deletion{Pos: 0, Len: 1}}
+	<-s.cOut
+
+	// When the server reports an error answering some other request
+	s.cIn <- comms.ErrorMessage{Code: comms.RATE_LIMITED, Message: "slow down"}
+	<-e.remote
+
+	// Then the client should still wait for the change to be acknowledged
+	select {
+	case msg := <-s.cOut:
+		t.Fatalf("server received %v, expected nothing", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// When the server rejects the change
+	s.cIn <- comms.ErrorMessage{Code: comms.INTERNAL, Message: "could not save the change", Change: true}
+	<-e.remote
+
+	// Then the queued change, made on top of it, should be dropped along
+	// with the editor's changes until it fetches the document again
+	e.local <- comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "h"}}
+	select {
+	case msg := <-s.cOut:
+		t.Fatalf("server received %v, expected nothing", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+	e.local <- comms.FetchDocument{}
+	if msg := <-s.cOut; msg.Kind() != comms.FETCH_DOCUMENT {
+		t.Errorf("server received %v, expected the request for the document", msg)
+	}
+	e.local <- comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "hi"}}
+	if msg, ok := (<-s.cOut).(*comms.OpMessage); !ok || msg.Op != (ot.Insertion{Pos: 0, Text: "hi"}) {
+		t.Errorf("server received %v, expected the change made since", msg)
+	}
+}
+
+func TestClientAnswersPingsWithoutHeartbeats(t *testing.T) {
+	// Given an idle client that doesn't ping the server
+	_, _, s, teardown := setupSingleClient()
+	defer teardown()
+
+	// When the server pings it
+	s.cIn <- comms.Ping{}
+
+	// Then it should answer
+	select {
+	case msg := <-s.cOut:
+		if msg.Kind() != comms.PONG {
+			t.Errorf("server received %v, expected a pong", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("the client did not answer the ping")
+	}
+}
+
 func TestClientSendsRemoteChangeToEditor(t *testing.T) {
 	// Given the client has no pending changes
 	_, e, s, teardown := setupSingleClient()
 	defer teardown()
 
 	// When the client receives a remote change
-	msg := comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "hello"}}
+	msg := comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "hello"}, Author: "alice"}
 	s.cIn <- msg
 
-	// Then the change should be sent to the editor
-	<-e.remote
+	// Then the change should be sent to the editor with its author, having
+	// seen none of the editor's changes
+	got, ok := (<-e.remote).(*comms.OpMessage)
+	if !ok || got.Op != msg.Op || got.Author != msg.Author || got.Seen == nil || *got.Seen != 0 {
+		t.Errorf("editor received %v, expected %v", got, msg)
+	}
 }
 
 func TestClientRebasesRemoteChangesForEditor(t *testing.T) {
@@ -129,7 +193,7 @@ func TestClientRebasesRemoteChangesForEditor(t *testing.T) {
 	s.cIn <- comms.OpMessage{Op: remoteOp}
 
 	// Then the remote change should be rebased and sent to the editor
-	if got, ok := asOp(<-e.remote); ok {
+	if got, ok := comms.AsOp(<-e.remote); ok {
 		want := remoteOp.Rebase(localOp)
 		if got != want {
 			t.Errorf("editor received remote change %#v, expected %#v", got, want)
@@ -161,7 +225,7 @@ func TestClientRebasesQueuedChangesForServer(t *testing.T) {
 	s.cIn <- comms.OpMessage{Op: remoteOp}
 
 	// Then the remote change should be rebased and sent to the editor
-	if got, ok := asOp(<-e.remote); ok {
+	if got, ok := comms.AsOp(<-e.remote); ok {
 		want := remoteOp.Rebase(localOp1).Rebase(localOp2)
 		if got != want {
 			t.Errorf("editor received remote change %#v, expected %#v", got, want)
@@ -174,9 +238,10 @@ func TestClientRebasesQueuedChangesForServer(t *testing.T) {
 	<-s.cOut
 	s.cIn <- comms.AcknowledgeChange{}
 
-	// Then the client should send rebased local changes
-	if got, ok := asOp(<-s.cOut); ok {
-		want := localOp2.Rebase(remoteOp)
+	// Then the client should send rebased local changes, over the remote
+	// change as it applies after the acknowledged one
+	if got, ok := comms.AsOp(<-s.cOut); ok {
+		want := localOp2.Rebase(remoteOp.Rebase(localOp1))
 		if got != want {
 			t.Errorf("server received local change %#v, expected %#v", got, want)
 		}
@@ -184,3 +249,164 @@ func TestClientRebasesQueuedChangesForServer(t *testing.T) {
 		t.Fatalf("server received unexpected message type")
 	}
 }
+
+func TestClientForwardsJoinToServer(t *testing.T) {
+	// Given the client is attached to an editor
+	_, e, s, teardown := setupSingleClient()
+	defer teardown()
+
+	// When the editor declares its identity
+	want := comms.JoinSession{Name: "alice", Colour: "#ff0000"}
+	e.local <- want
+
+	// Then the join should be sent straight to the server
+	if got := <-s.cOut; *got.(*comms.JoinSession) != want {
+		t.Errorf("server received %v, expected %v", got, want)
+	}
+}
+
+func TestClientForwardsRosterToEditor(t *testing.T) {
+	// Given the client is connected to a server
+	_, e, s, teardown := setupSingleClient()
+	defer teardown()
+
+	// When the server announces a participant
+	want := comms.ParticipantJoined{Participant: comms.Participant{Id: 3, Name: "bob"}}
+	s.cIn <- want
+
+	// Then the announcement should be sent to the editor
+	if got := <-e.remote; *got.(*comms.ParticipantJoined) != want {
+		t.Errorf("editor received %v, expected %v", got, want)
+	}
+}
+
+func TestClientFetchesDocumentForEditor(t *testing.T) {
+	// Given the client is connected to a server
+	_, e, s, teardown := setupSingleClient()
+	defer teardown()
+
+	// When the editor asks for the document
+	e.local <- comms.FetchDocument{}
+
+	// Then the request should be sent to the server
+	if got := <-s.cOut; got.Kind() != comms.FETCH_DOCUMENT {
+		t.Fatalf("server received %v, expected a request for the document", got)
+	}
+
+	// When the server replies
+	want := comms.Document{Text: "hello", Rev: 1}
+	s.cIn <- want
+
+	// Then the document should be sent to the editor
+	if got := <-e.remote; *got.(*comms.Document) != want {
+		t.Errorf("editor received %v, expected %v", got, want)
+	}
+}
+
+func TestClientFetchesHistoryForEditor(t *testing.T) {
+	// Given the client is connected to a server
+	_, e, s, teardown := setupSingleClient()
+	defer teardown()
+
+	// When the editor asks for an old revision
+	e.local <- comms.FetchRevision{Rev: 1}
+
+	// Then the request should be sent to the server
+	if got := <-s.cOut; got.Kind() != comms.FETCH_REVISION {
+		t.Fatalf("server received %v, expected a request for a revision", got)
+	}
+
+	// When the server replies
+	want := comms.Revision{Text: "hello", Rev: 1}
+	s.cIn <- want
+
+	// Then the revision should be sent to the editor
+	if got := <-e.remote; *got.(*comms.Revision) != want {
+		t.Errorf("editor received %v, expected %v", got, want)
+	}
+}
+
+func TestClientAuthenticatesOnConnect(t *testing.T) {
+	// Given the client has credentials
+	c := new(Client)
+	c.Auth = &comms.Authenticate{Document: "notes", Secret: "hunter2"}
+	a, b := comms.Pipe()
+	defer a.Close()
+
+	// When the client connects
+	go c.Connect(a)
+
+	// Then the credentials should be the first message sent
+	msg, err := b.Receive()
+	if got, ok := msg.(*comms.Authenticate); err != nil || !ok || *got != *c.Auth {
+		t.Errorf("server received %v (%v), expected %v", msg, err, c.Auth)
+	}
+}
+
+func TestClientRejectsChangesFromViewers(t *testing.T) {
+	// Given the server says the client is a viewer
+	_, e, s, teardown := setupSingleClient()
+	defer teardown()
+
+	s.cIn <- comms.Roster{Self: 1, Participants: []comms.Participant{{Id: 1, Role: comms.VIEWER}}}
+	<-e.remote
+
+	// When the editor sends a change
+	e.local <- comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "hello"}}
+
+	// Then the client should refuse it without contacting the server
+	if msg, ok := (<-e.remote).(*comms.ErrorMessage); !ok || msg.Code != comms.READ_ONLY || !msg.Rejected() {
+		t.Errorf("editor received %v, expected a read-only error rejecting the change", msg)
+	}
+	select {
+	case msg := <-s.cOut:
+		t.Errorf("server received %v", msg)
+	default:
+	}
+}
+
+func TestClientClosesServerWhenEditorDetaches(t *testing.T) {
+	// Given the client is connected
+	c := new(Client)
+	a1, b1 := comms.Pipe()
+	a2, b2 := comms.Pipe()
+	c.Attach(a1)
+	c.Connect(a2)
+
+	// When the editor disconnects
+	b1.Close()
+
+	// Then the client should finish and hang up on the server
+	<-c.Done()
+	if _, err := b2.Receive(); err == nil {
+		t.Errorf("server connection is still open")
+	}
+}
+
+func TestClientReportsLostConnection(t *testing.T) {
+	// Given the client expects regular heartbeats
+	c := new(Client)
+	c.HeartbeatInterval = 5 * time.Millisecond
+	c.MissedHeartbeats = 2
+	a1, b1 := comms.Pipe()
+	e := new(MockEditor)
+	c.Attach(a1)
+	e.Init(b1)
+
+	// When the server stops responding
+	a2, b2 := comms.Pipe()
+	defer b2.Close()
+	go func() {
+		for {
+			if _, err := b2.Receive(); err != nil {
+				return
+			}
+		}
+	}()
+	c.Connect(a2)
+
+	// Then the editor should be told the connection was lost
+	if msg, ok := (<-e.remote).(*comms.ErrorMessage); !ok || msg.Code != comms.CONNECTION_LOST {
+		t.Errorf("editor received %v, expected a lost connection error", msg)
+	}
+}
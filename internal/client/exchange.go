@@ -0,0 +1,67 @@
+package client
+
+import (
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// An Exchange is an editor's side of the changes it and a Client pass each
+// other, for editors that keep a copy of the document and make changes while
+// others' are on their way. Changes that cross are rebased over each other,
+// as the Client does on its side. It isn't safe for concurrent use.
+type Exchange struct {
+	// received counts the remote changes passed on by the client, sent the
+	// editor's changes and acked those the server accepted.
+	received, sent, acked int
+
+	// pending holds the last of the editor's changes, which the client may
+	// not have had when it passed on the next remote change.
+	pending []ot.Operation
+}
+
+// Send returns the message passing on op, just applied to the editor's copy
+// of the document.
+func (x *Exchange) Send(op ot.Operation) comms.OpMessage {
+	x.sent++
+	x.pending = append(x.pending, op)
+	seen := x.received
+	return comms.OpMessage{Op: op, Seen: &seen}
+}
+
+// Receive returns a remote change passed on by the client as it applies to
+// the editor's copy of the document. Every change passed on must be
+// received, even those the editor ignores, such as while it loads the
+// document.
+func (x *Exchange) Receive(m *comms.OpMessage) ot.Operation {
+	x.received++
+	if m.Seen == nil {
+		x.pending = nil
+	} else {
+		x.trim(*m.Seen)
+	}
+	op := m.Op
+	for i, on := range x.pending {
+		op, x.pending[i] = op.Rebase(on), on.Rebase(op)
+	}
+	return op
+}
+
+// Ack notes that the server accepted one of the editor's changes. The client
+// had it, and so had as many changes as the server accepted.
+func (x *Exchange) Ack() {
+	x.acked++
+	x.trim(x.acked)
+}
+
+// Reset forgets the editor's changes once its copy of the document has been
+// replaced by the server's.
+func (x *Exchange) Reset() {
+	x.pending = nil
+}
+
+// trim forgets the editor's changes among the first seen it sent.
+func (x *Exchange) trim(seen int) {
+	if n := max(x.sent-seen, 0); n < len(x.pending) {
+		x.pending = x.pending[len(x.pending)-n:]
+	}
+}
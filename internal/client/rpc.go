@@ -0,0 +1,662 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// JSON-RPC 2.0 error codes. Errors reported by shed itself, such as for a
+// viewer's changes, use rpcShedError, with shed's error code in their data.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcShedError      = -32000
+)
+
+// undoLimit bounds how many of an editor's changes an RPC can undo.
+const undoLimit = 100
+
+// An RPC serves a JSON-RPC 2.0 API to an editor, much as a language server
+// does, for editors that would rather ask for what they need and be told of
+// changes than exchange shed's messages. It keeps a copy of the document to
+// answer from and to undo the editor's changes in.
+//
+// Editors call "open" first, then "edit", "undo", "getText" and
+// "getParticipants". They are sent "remoteEdit" notifications for other
+// participants' changes, "presence" when someone joins, leaves or changes
+// role, "connection" when the session starts and ends, and "error" when the
+// server rejects something. A rejected change is undone by "remoteEdit"
+// notifications once the document has been fetched again. Positions are byte
+// offsets, as in shed's operations.
+type RPC struct {
+	// Connect connects c, which the RPC has attached itself to, to the server
+	// for the named document, or for the default document if name is empty.
+	Connect func(c *Client, name string) error
+
+	// Logger, if set, records requests the RPC couldn't make sense of.
+	Logger *slog.Logger
+
+	frames comms.Framer
+	wmu    sync.Mutex
+
+	// edits serializes the editor's changes, so that they reach the client
+	// in the order they were applied to text.
+	edits sync.Mutex
+
+	mu           sync.Mutex
+	editor       comms.Transport
+	loaded       chan error
+	text         string
+	rev          int
+	self         int
+	participants []comms.Participant
+	undo         []undoable
+	exchange     Exchange
+
+	// sent and answered count the operations sent to the server and those it
+	// has acknowledged or rejected, to tell which changes it has yet to take.
+	sent, answered int
+
+	// reload is closed once the document has been fetched again after the
+	// server rejected a change, which text still holds until then.
+	reload chan struct{}
+}
+
+// An undoable is the changes that would undo one of the editor's, and how
+// many operations had been sent to the server once it was.
+type undoable struct {
+	ops  []ot.Operation
+	sent int
+}
+
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResult struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+}
+
+type rpcFailure struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcNotification struct {
+	Version string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// asRPCError returns err as a JSON-RPC error.
+func asRPCError(err error) *rpcError {
+	var re *rpcError
+	if errors.As(err, &re) {
+		return re
+	}
+	var e comms.ErrorMessage
+	if errors.As(err, &e) {
+		return &rpcError{Code: rpcShedError, Message: e.Message, Data: struct {
+			Code string `json:"code"`
+		}{e.Code}}
+	}
+	return &rpcError{Code: rpcShedError, Message: err.Error()}
+}
+
+// textResult is the RPC's copy of the document, after Rev changes.
+type textResult struct {
+	Text string `json:"text"`
+	Rev  int    `json:"rev"`
+}
+
+// presence is who is in the session, and which of them the editor is.
+type presence struct {
+	Self         int                 `json:"self"`
+	Participants []comms.Participant `json:"participants"`
+}
+
+type remoteEdit struct {
+	Op     ot.Operation `json:"op"`
+	Author string       `json:"author,omitempty"`
+	Rev    int          `json:"rev"`
+}
+
+type connection struct {
+	State   string `json:"state"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r *RPC) logger() *slog.Logger {
+	if r.Logger == nil {
+		return discard
+	}
+	return r.Logger
+}
+
+// Serve answers the editor's requests, read from frames, until the editor
+// disconnects, then leaves the session.
+func (r *RPC) Serve(frames comms.Framer) error {
+	r.frames = frames
+	defer r.close()
+	for {
+		frame, err := frames.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if reply := r.handle(frame); reply != nil {
+			if err := r.write(reply); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// close leaves the session, if one is open.
+func (r *RPC) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.editor != nil {
+		r.editor.Close()
+	}
+}
+
+func (r *RPC) write(v any) error {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+	return r.frames.WriteFrame(frame)
+}
+
+func (r *RPC) notify(method string, params any) {
+	if err := r.write(rpcNotification{"2.0", method, params}); err != nil {
+		r.logger().Debug("notifying the editor", "method", method, "error", err)
+	}
+}
+
+// handle answers a frame holding a request or a batch of them, returning nil
+// if there is nothing to answer.
+func (r *RPC) handle(frame []byte) any {
+	frame = bytes.TrimSpace(frame)
+	if len(frame) == 0 || frame[0] != '[' {
+		return r.answer(frame)
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(frame, &batch); err != nil {
+		return rpcFailure{"2.0", nil, &rpcError{Code: rpcParseError, Message: "invalid JSON"}}
+	}
+	if len(batch) == 0 {
+		return rpcFailure{"2.0", nil, &rpcError{Code: rpcInvalidRequest, Message: "empty batch"}}
+	}
+	var replies []any
+	for _, req := range batch {
+		if reply := r.answer(req); reply != nil {
+			replies = append(replies, reply)
+		}
+	}
+	if len(replies) == 0 {
+		return nil
+	}
+	return replies
+}
+
+// answer carries out a request, returning nil if it was a notification.
+func (r *RPC) answer(raw []byte) any {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if !json.Valid(raw) {
+			return rpcFailure{"2.0", nil, &rpcError{Code: rpcParseError, Message: "invalid JSON"}}
+		}
+		return rpcFailure{"2.0", nil, &rpcError{Code: rpcInvalidRequest, Message: "requests must be objects"}}
+	}
+	if req.Version != "2.0" || req.Method == "" {
+		return rpcFailure{"2.0", req.Id, &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"}}
+	}
+	result, err := r.call(req.Method, req.Params)
+	if req.Id == nil {
+		if err != nil {
+			r.logger().Warn("editor notification failed", "method", req.Method, "error", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return rpcFailure{"2.0", req.Id, asRPCError(err)}
+	}
+	return rpcResult{"2.0", req.Id, result}
+}
+
+func (r *RPC) call(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "open":
+		var p struct {
+			Document string `json:"document"`
+			Name     string `json:"name"`
+			Colour   string `json:"colour"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return r.open(p.Document, p.Name, p.Colour)
+	case "edit":
+		var p struct {
+			Ops  []json.RawMessage `json:"ops"`
+			Text *string           `json:"text"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if (p.Ops == nil) == (p.Text == nil) {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "edits need either ops or text"}
+		}
+		ops := make([]ot.Operation, len(p.Ops))
+		for i, data := range p.Ops {
+			op, err := ot.Unmarshal(data)
+			if err != nil || op == nil {
+				return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid operation %s", data)}
+			}
+			ops[i] = op
+		}
+		return nil, r.edit(ops, p.Text)
+	case "undo":
+		return nil, r.undoEdit()
+	case "getText":
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.editor == nil {
+			return nil, notOpen
+		}
+		return textResult{r.text, r.rev}, nil
+	case "getParticipants":
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.editor == nil {
+			return nil, notOpen
+		}
+		return r.presence(), nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("there is no method %q", method)}
+}
+
+var notOpen = comms.ErrorMessage{Code: comms.INVALID, Message: "no document is open"}
+
+// decodeParams decodes a request's named parameters into v.
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// open joins the session on the named document and loads it.
+func (r *RPC) open(document, name, colour string) (any, error) {
+	r.mu.Lock()
+	if r.editor != nil {
+		r.mu.Unlock()
+		return nil, comms.ErrorMessage{Code: comms.INVALID, Message: "a document is open already"}
+	}
+	editor, inner := comms.Pipe()
+	loaded := make(chan error, 1)
+	r.editor, r.loaded = editor, loaded
+	r.text, r.rev, r.self, r.participants, r.undo = "", 0, 0, nil, nil
+	r.sent, r.answered, r.reload = 0, 0, nil
+	r.exchange = Exchange{}
+	r.mu.Unlock()
+
+	c := new(Client)
+	c.Attach(inner)
+	if err := r.Connect(c, document); err != nil {
+		editor.Close()
+		r.mu.Lock()
+		r.editor, r.loaded = nil, nil
+		r.mu.Unlock()
+		return nil, err
+	}
+	go r.receive(editor)
+	if err := editor.Send(comms.JoinSession{Name: name, Colour: colour}); err != nil {
+		return nil, err
+	}
+	if err := editor.Send(comms.FetchDocument{}); err != nil {
+		return nil, err
+	}
+	if err := <-loaded; err != nil {
+		return nil, err
+	}
+
+	r.notify("connection", connection{State: "connected"})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return struct {
+		textResult
+		presence
+	}{textResult{r.text, r.rev}, r.presence()}, nil
+}
+
+// presence returns who is in the session. The caller must hold r.mu.
+func (r *RPC) presence() presence {
+	return presence{r.self, slices.Clone(r.participants)}
+}
+
+// edit applies the editor's changes, given as operations or as the text the
+// document should now hold, and sends them to the server.
+func (r *RPC) edit(ops []ot.Operation, text *string) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.edits.Unlock()
+	if text != nil && r.editor != nil {
+		ops = ot.Diff(r.text, *text)
+	}
+	inverse, msgs, err := r.apply(ops)
+	if err == nil && len(ops) > 0 {
+		r.undo = append(r.undo, undoable{inverse, r.sent})
+		if len(r.undo) > undoLimit {
+			r.undo = slices.Delete(r.undo, 0, len(r.undo)-undoLimit)
+		}
+	}
+	editor := r.editor
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return send(editor, msgs)
+}
+
+// undoEdit undoes the editor's last change that hasn't been undone, as it
+// stands after the changes made since.
+func (r *RPC) undoEdit() error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.edits.Unlock()
+	switch {
+	case r.editor == nil:
+		r.mu.Unlock()
+		return notOpen
+	case len(r.undo) == 0:
+		r.mu.Unlock()
+		return comms.ErrorMessage{Code: comms.INVALID, Message: "there is nothing to undo"}
+	}
+	u := r.undo[len(r.undo)-1]
+	r.undo = r.undo[:len(r.undo)-1]
+	_, msgs, err := r.apply(u.ops)
+	if err != nil {
+		r.undo = append(r.undo, u)
+		r.mu.Unlock()
+		return err
+	}
+	editor := r.editor
+	r.mu.Unlock()
+	return send(editor, msgs)
+}
+
+// lock takes r.edits and r.mu to make the editor's changes, first waiting for
+// the document to be fetched again if the server has rejected a change.
+func (r *RPC) lock() error {
+	for {
+		r.edits.Lock()
+		r.mu.Lock()
+		reload := r.reload
+		if reload == nil {
+			return nil
+		}
+		r.mu.Unlock()
+		r.edits.Unlock()
+		<-reload
+	}
+}
+
+func send(editor comms.Transport, msgs []comms.OpMessage) error {
+	for _, m := range msgs {
+		if err := editor.Send(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply applies the editor's changes to the document, all or none of them,
+// returning the changes that would undo them and the messages to send them
+// in, and counts them as sent. The caller must hold r.mu.
+func (r *RPC) apply(ops []ot.Operation) ([]ot.Operation, []comms.OpMessage, error) {
+	if r.editor == nil {
+		return nil, nil, notOpen
+	}
+	if !r.canEdit() {
+		return nil, nil, comms.ErrorMessage{Code: comms.READ_ONLY, Message: "viewers cannot edit the document"}
+	}
+	text := r.text
+	inverse := make([]ot.Operation, 0, len(ops))
+	for _, op := range ops {
+		if err := ot.Validate(op, text); err != nil {
+			return nil, nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("%v: %v", op, err)}
+		}
+		switch op := op.(type) {
+		case ot.Insertion:
+			inverse = append(inverse, ot.Deletion{Pos: op.Pos, Len: uint(len(op.Text))})
+		case ot.Deletion:
+			inverse = append(inverse, ot.Insertion{Pos: op.Pos, Text: text[op.Pos : op.Pos+op.Len]})
+		}
+		text = op.Apply(text)
+	}
+	msgs := make([]comms.OpMessage, 0, len(ops))
+	for _, op := range ops {
+		r.rebaseUndo(op)
+		msgs = append(msgs, r.exchange.Send(op))
+	}
+	r.text = text
+	r.sent += len(ops)
+	slices.Reverse(inverse)
+	return inverse, msgs, nil
+}
+
+// canEdit reports whether the editor's role lets it edit. The caller must
+// hold r.mu.
+func (r *RPC) canEdit() bool {
+	for _, p := range r.participants {
+		if p.Id == r.self {
+			return p.Role.CanEdit()
+		}
+	}
+	return true
+}
+
+// rebaseUndo keeps the changes that would undo the editor's changes
+// applicable once on has been applied. The caller must hold r.mu.
+func (r *RPC) rebaseUndo(on ot.Operation) {
+	for _, u := range r.undo {
+		x := on
+		for i := range u.ops {
+			u.ops[i], x = u.ops[i].Rebase(x), x.Rebase(u.ops[i])
+		}
+	}
+}
+
+// receive keeps the document and participants up to date with what the
+// client passes on, notifying the editor, until the session ends.
+func (r *RPC) receive(editor comms.Transport) {
+	reason := "lost connection to the server"
+	for {
+		m, err := editor.Receive()
+		if err != nil {
+			break
+		}
+		switch m := m.(type) {
+		case *comms.Document:
+			r.reloaded(m)
+			r.ready(nil)
+		case *comms.OpMessage:
+			r.remote(m)
+		case *comms.AcknowledgeChange:
+			r.mu.Lock()
+			r.rev++
+			r.answered++
+			r.exchange.Ack()
+			r.mu.Unlock()
+		case *comms.ErrorMessage:
+			if m.Rejected() {
+				r.reject()
+			}
+			if m.Code == comms.CONNECTION_LOST {
+				reason = m.Message
+			} else if !r.ready(*m) {
+				r.notify("error", m)
+			}
+		default:
+			r.updatePresence(m)
+		}
+	}
+
+	r.mu.Lock()
+	if r.editor == editor {
+		r.editor = nil
+	}
+	if r.reload != nil {
+		close(r.reload)
+		r.reload = nil
+	}
+	r.mu.Unlock()
+	if !r.ready(comms.ErrorMessage{Code: comms.CONNECTION_LOST, Message: reason}) {
+		r.notify("connection", connection{State: "disconnected", Message: reason})
+	}
+}
+
+// ready reports the outcome of loading the document to open, if it is still
+// waiting, and reports whether it was.
+func (r *RPC) ready(err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loaded == nil {
+		return false
+	}
+	r.loaded <- err
+	r.loaded = nil
+	return true
+}
+
+// reject forgets the editor's changes from the one the server rejected on,
+// which never reach it, and fetches the document again to undo them.
+func (r *RPC) reject() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.undo = slices.DeleteFunc(r.undo, func(u undoable) bool { return u.sent > r.answered })
+	r.sent = r.answered
+	if r.reload != nil || r.editor == nil {
+		return
+	}
+	r.reload = make(chan struct{})
+	editor := r.editor
+	go func() {
+		// Changes already applied go first, to be dropped by the client
+		// along with the rejected one.
+		r.edits.Lock()
+		defer r.edits.Unlock()
+		editor.Send(comms.FetchDocument{})
+	}()
+}
+
+// reloaded takes the document the server sent, telling the editor how it
+// differs from text if it was fetched again after a rejected change.
+func (r *RPC) reloaded(m *comms.Document) {
+	// Changes received before the document are already part of it.
+	r.mu.Lock()
+	var ops []ot.Operation
+	if r.reload != nil {
+		ops = ot.Diff(r.text, m.Text)
+		for _, op := range ops {
+			r.rebaseUndo(op)
+		}
+		close(r.reload)
+		r.reload = nil
+	}
+	r.exchange.Reset()
+	r.text, r.rev = m.Text, m.Rev
+	r.mu.Unlock()
+	for _, op := range ops {
+		r.notify("remoteEdit", remoteEdit{Op: op, Rev: m.Rev})
+	}
+}
+
+// remote applies another participant's change and tells the editor of it.
+func (r *RPC) remote(m *comms.OpMessage) {
+	r.mu.Lock()
+	op := r.exchange.Receive(m)
+	if r.loaded != nil || r.reload != nil {
+		r.mu.Unlock()
+		return
+	}
+	if err := ot.Validate(op, r.text); err != nil {
+		r.mu.Unlock()
+		r.logger().Error("applying a remote change", "op", op, "error", err)
+		return
+	}
+	r.text = op.Apply(r.text)
+	r.rev++
+	r.rebaseUndo(op)
+	e := remoteEdit{op, m.Author, r.rev}
+	r.mu.Unlock()
+	r.notify("remoteEdit", e)
+}
+
+// updatePresence keeps track of who is in the session, telling the editor of
+// changes once the document has loaded.
+func (r *RPC) updatePresence(m comms.Message) {
+	r.mu.Lock()
+	switch m := m.(type) {
+	case *comms.Roster:
+		r.self = m.Self
+		r.participants = m.Participants
+	case *comms.ParticipantJoined:
+		r.participants = append(r.participants, m.Participant)
+	case *comms.ParticipantLeft:
+		r.participants = slices.DeleteFunc(r.participants, func(p comms.Participant) bool { return p.Id == m.Id })
+	case *comms.RoleChanged:
+		for i := range r.participants {
+			if r.participants[i].Id == m.Id {
+				r.participants[i].Role = m.Role
+			}
+		}
+	default:
+		r.mu.Unlock()
+		return
+	}
+	loading, p := r.loaded != nil, r.presence()
+	r.mu.Unlock()
+	if !loading {
+		r.notify("presence", p)
+	}
+}
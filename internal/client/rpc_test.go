@@ -0,0 +1,259 @@
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// rpcReply is anything an RPC sends an editor, decoded loosely.
+type rpcReply struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcEditor struct {
+	t       *testing.T
+	frames  comms.Framer
+	replies chan []byte
+}
+
+func (e *rpcEditor) send(frame string) {
+	e.t.Helper()
+	if err := e.frames.WriteFrame([]byte(frame)); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *rpcEditor) next() rpcReply {
+	var reply rpcReply
+	json.Unmarshal(<-e.replies, &reply)
+	return reply
+}
+
+func setupRPC(t *testing.T) (*rpcEditor, *MockServer) {
+	s := new(MockServer)
+	server, client := comms.Pipe()
+	s.Accept(server)
+	r := &RPC{Connect: func(c *Client, name string) error {
+		return c.Connect(client)
+	}}
+	a, b := net.Pipe()
+	go r.Serve(comms.LineFrames(a))
+	t.Cleanup(func() { b.Close() })
+
+	e := &rpcEditor{t, comms.LineFrames(b), make(chan []byte, 16)}
+	go func() {
+		for {
+			frame, err := e.frames.ReadFrame()
+			if err != nil {
+				return
+			}
+			e.replies <- frame
+		}
+	}()
+	return e, s
+}
+
+// open opens the document, with text, as participant 1, taking every message
+// sent until the editor is answered.
+func open(t *testing.T, e *rpcEditor, s *MockServer, text string) rpcReply {
+	t.Helper()
+	e.send(`{"jsonrpc":"2.0","id":1,"method":"open","params":{"name":"alice"}}`)
+	<-s.cOut
+	<-s.cOut
+	s.cIn <- comms.Roster{Self: 1, Participants: []comms.Participant{{Id: 1, Name: "alice", Role: comms.EDITOR}}}
+	s.cIn <- comms.Document{Text: text, Rev: 3}
+	if n := e.next(); n.Method != "connection" {
+		t.Fatalf("expected a connection notification, got %+v", n)
+	}
+	return e.next()
+}
+
+func TestRPCOpensDocument(t *testing.T) {
+	// Given an editor speaking JSON-RPC
+	e, s := setupRPC(t)
+
+	// When it opens the document
+	reply := open(t, e, s, "hello")
+
+	// Then it should be answered with the text and who is there
+	var got struct {
+		Text         string              `json:"text"`
+		Rev          int                 `json:"rev"`
+		Self         int                 `json:"self"`
+		Participants []comms.Participant `json:"participants"`
+	}
+	json.Unmarshal(reply.Result, &got)
+	if string(reply.Id) != "1" || got.Text != "hello" || got.Rev != 3 || got.Self != 1 || len(got.Participants) != 1 {
+		t.Errorf("unexpected reply %+v, %+v", reply, got)
+	}
+}
+
+func TestRPCSendsEditsAndNotifiesOfRemoteOnes(t *testing.T) {
+	// Given an editor that has opened a document
+	e, s := setupRPC(t)
+	open(t, e, s, "hello")
+
+	// When it edits the document
+	e.send(`{"jsonrpc":"2.0","id":2,"method":"edit","params":{"ops":[{"type":"insertion","pos":5,"text":" world"}]}}`)
+
+	// Then the change should be sent to the server
+	if reply := e.next(); reply.Error != nil {
+		t.Fatal(reply.Error)
+	}
+	if op, _ := comms.AsOp(<-s.cOut); op != (ot.Insertion{Pos: 5, Text: " world"}) {
+		t.Errorf("unexpected op %v", op)
+	}
+
+	// When someone else changes the document
+	s.cIn <- comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "*"}, Author: "bob"}
+
+	// Then the editor should be told of it, once rebased on its own change
+	n := e.next()
+	if n.Method != "remoteEdit" || string(n.Params) != `{"op":{"pos":0,"text":"*","type":"insertion"},"author":"bob","rev":4}` {
+		t.Errorf("unexpected notification %s %s", n.Method, n.Params)
+	}
+
+	// Then the text should include both changes
+	e.send(`{"jsonrpc":"2.0","id":3,"method":"getText"}`)
+	if reply := e.next(); string(reply.Result) != `{"text":"*hello world","rev":4}` {
+		t.Errorf("unexpected text %s", reply.Result)
+	}
+}
+
+func TestRPCUndoesEditsAfterRemoteOnes(t *testing.T) {
+	// Given an editor that has deleted text, and someone else's later change
+	e, s := setupRPC(t)
+	open(t, e, s, "hello world")
+	e.send(`{"jsonrpc":"2.0","id":2,"method":"edit","params":{"text":"hello"}}`)
+	e.next()
+	<-s.cOut
+	s.cIn <- comms.AcknowledgeChange{}
+	s.cIn <- comms.OpMessage{Op: ot.Insertion{Pos: 0, Text: "oh, "}}
+	e.next()
+
+	// When the editor undoes its change
+	e.send(`{"jsonrpc":"2.0","id":3,"method":"undo"}`)
+	if reply := e.next(); reply.Error != nil {
+		t.Fatal(reply.Error)
+	}
+
+	// Then the deleted text should be restored where it now belongs
+	if op, _ := comms.AsOp(<-s.cOut); op != (ot.Insertion{Pos: 9, Text: " world"}) {
+		t.Errorf("unexpected op %v", op)
+	}
+	e.send(`{"jsonrpc":"2.0","id":4,"method":"getText"}`)
+	if reply := e.next(); string(reply.Result) != `{"text":"oh, hello world","rev":5}` {
+		t.Errorf("unexpected text %s", reply.Result)
+	}
+
+	// Then there should be nothing left to undo
+	e.send(`{"jsonrpc":"2.0","id":5,"method":"undo"}`)
+	if reply := e.next(); reply.Error == nil || reply.Error.Code != rpcShedError {
+		t.Errorf("expected an error, got %+v", reply)
+	}
+}
+
+func TestRPCUndoesRejectedEdits(t *testing.T) {
+	// Given an editor that has opened a document
+	e, s := setupRPC(t)
+	open(t, e, s, "hello")
+
+	// When it makes a change that the server rejects
+	e.send(`{"jsonrpc":"2.0","id":2,"method":"edit","params":{"ops":[{"type":"insertion","pos":5,"text":" world"}]}}`)
+	e.next()
+	<-s.cOut
+	s.cIn <- comms.ErrorMessage{Code: comms.RATE_LIMITED, Message: "slow down", Change: true}
+
+	// Then the editor should be told, and the document fetched again
+	if n := e.next(); n.Method != "error" {
+		t.Errorf("expected an error notification, got %+v", n)
+	}
+	if msg := <-s.cOut; msg.Kind() != comms.FETCH_DOCUMENT {
+		t.Fatalf("server received %v, expected the request for the document", msg)
+	}
+	s.cIn <- comms.Document{Text: "hello", Rev: 3}
+
+	// Then the editor should be told how to undo the change
+	if n := e.next(); n.Method != "remoteEdit" || string(n.Params) != `{"op":{"pos":5,"len":6,"type":"deletion"},"rev":3}` {
+		t.Errorf("unexpected notification %s %s", n.Method, n.Params)
+	}
+	e.send(`{"jsonrpc":"2.0","id":3,"method":"getText"}`)
+	if reply := e.next(); string(reply.Result) != `{"text":"hello","rev":3}` {
+		t.Errorf("unexpected text %s", reply.Result)
+	}
+
+	// Then the change should no longer be there to undo
+	e.send(`{"jsonrpc":"2.0","id":4,"method":"undo"}`)
+	if reply := e.next(); reply.Error == nil || reply.Error.Code != rpcShedError {
+		t.Errorf("expected an error, got %+v", reply)
+	}
+}
+
+func TestRPCNotifiesOfPresenceAndConnection(t *testing.T) {
+	// Given an editor that has opened a document
+	e, s := setupRPC(t)
+	open(t, e, s, "")
+
+	// When someone joins
+	s.cIn <- comms.ParticipantJoined{Participant: comms.Participant{Id: 2, Name: "bob"}}
+
+	// Then the editor should be told who is there
+	if n := e.next(); n.Method != "presence" || string(n.Params) != `{"self":1,"participants":[{"id":1,"name":"alice","colour":"","role":"editor"},{"id":2,"name":"bob","colour":""}]}` {
+		t.Errorf("unexpected notification %s %s", n.Method, n.Params)
+	}
+
+	// When the server disconnects
+	s.client.Close()
+
+	// Then the editor should be told the connection was lost
+	if n := e.next(); n.Method != "connection" || string(n.Params) != `{"state":"disconnected","message":"lost connection to the server"}` {
+		t.Errorf("unexpected notification %s %s", n.Method, n.Params)
+	}
+}
+
+func TestRPCRejectsBadRequests(t *testing.T) {
+	e, _ := setupRPC(t)
+	for frame, want := range map[string]int{
+		`{"jsonrpc":"2.0","id":1,"method":"fly"}`:                 rpcMethodNotFound,
+		`{"jsonrpc":"2.0","id":1,"method":"getText"}`:             rpcShedError,
+		`{"jsonrpc":"2.0","id":1,"method":"edit","params":{}}`:    rpcInvalidParams,
+		`{"jsonrpc":"2.0","id":1,"method":"open","params":["x"]}`: rpcInvalidParams,
+		`{"jsonrpc":"1.0","id":1,"method":"getText"}`:             rpcInvalidRequest,
+		`{"jsonrpc":"2.0","id":1,`:                                rpcParseError,
+		`[]`:                                                      rpcInvalidRequest,
+	} {
+		e.send(frame)
+		reply := e.next()
+		if reply.Error == nil || reply.Error.Code != want {
+			t.Errorf("%s: got %+v, expected error %d", frame, reply, want)
+		}
+	}
+}
+
+func TestRPCAnswersBatches(t *testing.T) {
+	// Given an editor that has opened a document
+	e, s := setupRPC(t)
+	open(t, e, s, "hi")
+
+	// When it sends a batch of a notification and two requests
+	e.send(`[{"jsonrpc":"2.0","method":"edit","params":{"text":"hi!"}},{"jsonrpc":"2.0","id":"a","method":"getText"},{"jsonrpc":"2.0","id":"b"}]`)
+
+	// Then the requests should be answered together, in order
+	frame := <-e.replies
+	var replies []rpcReply
+	if err := json.Unmarshal(frame, &replies); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 || string(replies[0].Result) != `{"text":"hi!","rev":3}` || replies[1].Error == nil || replies[1].Error.Code != rpcInvalidRequest {
+		t.Errorf("unexpected replies %s", frame)
+	}
+	<-s.cOut
+}
@@ -2,22 +2,56 @@ package comms
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 )
 
-func ChanToWriter(ch <-chan Message, w io.Writer) {
+var (
+	UnrecognizedKindError = errors.New("unrecognized message kind")
+	MissingBodyError      = errors.New("message has no body")
+)
+
+// ChanToWriter writes every message from ch to w until ch is closed or
+// writing fails, returning the error that stopped it, if any.
+func ChanToWriter(ch <-chan Message, w io.Writer) error {
 	for m := range ch {
-		if err := writeMessage(w, m); err != nil {
-			break
+		if err := WriteMessage(w, m); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func ReaderToChan(r io.Reader, ch chan<- Message) {
+// ReaderToChan forwards every message read from r to ch until reading fails,
+// returning the error.
+func ReaderToChan(r io.Reader, ch chan<- Message) error {
 	for {
-		m, err := readMessage(r)
+		m, err := ReadMessage(r)
 		if err != nil {
-			break
+			return err
+		}
+		ch <- m
+	}
+}
+
+// ChanToTransport sends every message from ch until ch is closed or sending
+// fails, returning the error that stopped it, if any.
+func ChanToTransport(ch <-chan Message, t Transport) error {
+	for m := range ch {
+		if err := t.Send(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TransportToChan forwards every message received from t to ch until
+// receiving fails, returning the error.
+func TransportToChan(t Transport, ch chan<- Message) error {
+	for {
+		m, err := t.Receive()
+		if err != nil {
+			return err
 		}
 		ch <- m
 	}
@@ -28,29 +62,45 @@ type message struct {
 	Body json.RawMessage `json:"body"`
 }
 
-func readMessage(r io.Reader) (m Message, err error) {
+func ReadMessage(r io.Reader) (Message, error) {
 	content, err := ReadContent(r)
 	if err != nil {
-		return
+		return nil, err
 	}
+	return Decode([]byte(content))
+}
+
+func WriteMessage(w io.Writer, msg Message) error {
+	content, err := Encode(msg)
+	if err != nil {
+		return err
+	}
+	return WriteContent(w, string(content))
+}
 
+// Decode parses a message from the JSON envelope produced by Encode.
+func Decode(content []byte) (m Message, err error) {
 	var wrapper message
-	if err = json.Unmarshal([]byte(content), &wrapper); err != nil {
+	if err = json.Unmarshal(content, &wrapper); err != nil {
 		return
 	}
-	m = MessageOfKind(wrapper.Kind)
-	err = json.Unmarshal(wrapper.Body, &m)
-	return
+	if m = MessageOfKind(wrapper.Kind); m == nil {
+		return nil, UnrecognizedKindError
+	}
+	if err = json.Unmarshal(wrapper.Body, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, MissingBodyError
+	}
+	return m, nil
 }
 
-func writeMessage(w io.Writer, msg Message) error {
+// Encode wraps a message in a JSON envelope recording its kind.
+func Encode(msg Message) ([]byte, error) {
 	body, err := json.Marshal(msg)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	content, err := json.Marshal(message{Kind: msg.Kind(), Body: body})
-	if err != nil {
-		return err
-	}
-	return WriteContent(w, string(content))
+	return json.Marshal(message{Kind: msg.Kind(), Body: body})
 }
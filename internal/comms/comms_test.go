@@ -1,6 +1,7 @@
 package comms_test
 
 import (
+	"errors"
 	"net"
 	"sync"
 	"testing"
@@ -17,8 +18,8 @@ func TestReadWrite(t *testing.T) {
 	alice := make(chan comms.Message)
 	bob := make(chan comms.Message)
 
-	m1 := comms.OpMessage{ot.Insertion{Pos: 2, Text: "hello"}}
-	m2 := comms.OpMessage{ot.Deletion{Pos: 2, Len: 3}}
+	m1 := comms.OpMessage{Op: ot.Insertion{Pos: 2, Text: "hello"}}
+	m2 := comms.OpMessage{Op: ot.Deletion{Pos: 2, Len: 3}}
 
 	var wg sync.WaitGroup
 	wg.Go(func() {
@@ -39,3 +40,52 @@ func TestReadWrite(t *testing.T) {
 	})
 	wg.Wait()
 }
+
+func TestDecodeRejectsNullBody(t *testing.T) {
+	m, err := comms.Decode([]byte(`{"kind":1,"body":null}`))
+	if !errors.Is(err, comms.MissingBodyError) {
+		t.Errorf("Decode returned %v, %v, expected MissingBodyError", m, err)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	alice, bob := comms.Pipe()
+
+	want := comms.OpMessage{Op: ot.Insertion{Pos: 2, Text: "hello"}}
+	go alice.Send(want)
+	got, err := bob.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.(*comms.OpMessage) != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	alice.Close()
+	if _, err := bob.Receive(); !errors.Is(err, comms.ClosedError) {
+		t.Errorf("expected ClosedError after closing, got %v", err)
+	}
+	if err := bob.Send(want); !errors.Is(err, comms.ClosedError) {
+		t.Errorf("expected ClosedError after closing, got %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	connA, connB := net.Pipe()
+	alice, bob := comms.NewStream(connA), comms.NewStream(connB)
+	defer alice.Close()
+	defer bob.Close()
+
+	want := comms.OpMessage{Op: ot.Deletion{Pos: 2, Len: 3}}
+	go alice.Send(want)
+	got, err := bob.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.(*comms.OpMessage) != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if peer := bob.Remote(); peer.Addr != connB.RemoteAddr().String() {
+		t.Errorf("got peer %+v, expected address %s", peer, connB.RemoteAddr())
+	}
+}
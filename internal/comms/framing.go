@@ -0,0 +1,155 @@
+package comms
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var MalformedHeaderError = errors.New("malformed header")
+
+// A Framer reads and writes whole frames, such as messages' JSON envelopes,
+// on a byte stream. Frames are written one at a time.
+type Framer interface {
+	ReadFrame() ([]byte, error)
+	WriteFrame(p []byte) error
+}
+
+// A Framing frames data on byte streams in some way.
+type Framing func(rw io.ReadWriter) Framer
+
+// NewFramedStream returns a Transport that frames messages on a byte stream
+// with framing. Closing the transport closes rw if it is an io.Closer.
+func NewFramedStream(rw io.ReadWriter, framing Framing) Transport {
+	return &stream{rw: rw, f: framing(rw)}
+}
+
+// LengthFrames precedes each frame with its length as a 4-byte big-endian
+// integer, as ReadContent and WriteContent do.
+func LengthFrames(rw io.ReadWriter) Framer {
+	return lengthFramer{rw}
+}
+
+type lengthFramer struct {
+	rw io.ReadWriter
+}
+
+func (f lengthFramer) ReadFrame() ([]byte, error) {
+	content, err := ReadContent(f.rw)
+	return []byte(content), err
+}
+
+func (f lengthFramer) WriteFrame(p []byte) error {
+	return WriteContent(f.rw, string(p))
+}
+
+// LineFrames writes each frame on a line of its own, as newline-delimited
+// JSON, for peers such as editor scripts that find length prefixes awkward.
+// Frames must not contain newlines, which JSON never needs. Blank lines are
+// skipped.
+func LineFrames(rw io.ReadWriter) Framer {
+	return lineFramer{bufio.NewReader(rw), rw}
+}
+
+type lineFramer struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+// ReadFrame returns the next line that isn't blank, without its line ending.
+// A last line without one is returned too.
+func (f lineFramer) ReadFrame() ([]byte, error) {
+	for {
+		var line []byte
+		for {
+			chunk, err := f.r.ReadSlice('\n')
+			line = append(line, chunk...)
+			if len(line) > MaxPayloadSize+2 {
+				return nil, PayloadTooLargeError
+			}
+			if errors.Is(err, bufio.ErrBufferFull) {
+				continue
+			}
+			if errors.Is(err, io.EOF) && len(strings.TrimSpace(string(line))) > 0 {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read line: %w", err)
+			}
+			break
+		}
+		if content := strings.TrimRight(string(line), "\r\n"); strings.TrimSpace(content) != "" {
+			return []byte(content), nil
+		}
+	}
+}
+
+func (f lineFramer) WriteFrame(p []byte) error {
+	if len(p) > MaxPayloadSize {
+		return PayloadTooLargeError
+	}
+	_, err := f.w.Write(append(p[:len(p):len(p)], '\n'))
+	return err
+}
+
+// HeaderFrames frames data as the Language Server Protocol does: a
+// Content-Length header, then a blank line, then the frame. Other headers
+// are ignored.
+func HeaderFrames(rw io.ReadWriter) Framer {
+	return headerFramer{bufio.NewReader(rw), rw}
+}
+
+type headerFramer struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (f headerFramer) ReadFrame() ([]byte, error) {
+	length, seen := -1, false
+	for {
+		line, err := f.r.ReadSlice('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		header := strings.TrimRight(string(line), "\r\n")
+		if header == "" {
+			if !seen {
+				// Tolerate blank lines between frames.
+				continue
+			}
+			break
+		}
+		seen = true
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", MalformedHeaderError, header)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+				return nil, fmt.Errorf("%w: %q", MalformedHeaderError, header)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("%w: no Content-Length", MalformedHeaderError)
+	}
+	if length > MaxPayloadSize {
+		return nil, PayloadTooLargeError
+	}
+	data, err := readExactly(f.r, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	return data, nil
+}
+
+func (f headerFramer) WriteFrame(p []byte) error {
+	if len(p) > MaxPayloadSize {
+		return PayloadTooLargeError
+	}
+	_, err := f.w.Write(append([]byte("Content-Length: "+strconv.Itoa(len(p))+"\r\n\r\n"), p...))
+	return err
+}
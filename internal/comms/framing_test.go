@@ -0,0 +1,90 @@
+package comms_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// wire reads what a transport receives from in and records what it sends.
+type wire struct {
+	io.Reader
+	out bytes.Buffer
+}
+
+func (w *wire) Write(p []byte) (int, error) {
+	return w.out.Write(p)
+}
+
+func TestLineStream(t *testing.T) {
+	want := comms.OpMessage{Op: ot.Insertion{Pos: 1, Text: "a\nb"}}
+	envelope, _ := comms.Encode(want)
+
+	// Given lines with blank lines between them, CRLF endings and no ending
+	// on the last
+	w := &wire{Reader: strings.NewReader("\n" + string(envelope) + "\r\n\n" + string(envelope))}
+	s := comms.NewFramedStream(w, comms.LineFrames)
+
+	// Then each should be received
+	for range 2 {
+		got, err := s.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got.(*comms.OpMessage) != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := s.Receive(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v at the end, expected EOF", err)
+	}
+
+	// Then sent messages should take one line each
+	s.Send(want)
+	s.Send(want)
+	if got, want := w.out.String(), string(envelope)+"\n"+string(envelope)+"\n"; got != want {
+		t.Errorf("sent %q, expected %q", got, want)
+	}
+}
+
+func TestHeaderStream(t *testing.T) {
+	want := comms.OpMessage{Op: ot.Deletion{Pos: 2, Len: 3}}
+	envelope, _ := comms.Encode(want)
+	length := strconv.Itoa(len(envelope))
+
+	// Given messages with headers in any case, and other headers
+	w := &wire{Reader: strings.NewReader(
+		"Content-Length: " + length + "\r\n\r\n" + string(envelope) +
+			"Content-Type: application/json\r\ncontent-length:" + length + "\r\n\r\n" + string(envelope) +
+			"Content-Length: many\r\n\r\n",
+	)}
+	s := comms.NewFramedStream(w, comms.HeaderFrames)
+
+	// Then each should be received
+	for range 2 {
+		got, err := s.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got.(*comms.OpMessage) != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	// Then a header that can't be read should fail
+	if _, err := s.Receive(); !errors.Is(err, comms.MalformedHeaderError) {
+		t.Errorf("got %v, expected MalformedHeaderError", err)
+	}
+
+	// Then sent messages should have a Content-Length header
+	s.Send(want)
+	if got, want := w.out.String(), "Content-Length: "+length+"\r\n\r\n"+string(envelope); got != want {
+		t.Errorf("sent %q, expected %q", got, want)
+	}
+}
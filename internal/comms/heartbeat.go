@@ -0,0 +1,115 @@
+package comms
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMissedHeartbeats is how many heartbeat intervals may pass without
+// hearing from a peer before Heartbeat gives up on it.
+const DefaultMissedHeartbeats = 3
+
+var HeartbeatTimeoutError = errors.New("peer stopped responding")
+
+// Heartbeat wraps t so that a Ping is sent every interval and pings from the
+// peer are answered. Pings and pongs are not returned by Receive. If nothing
+// at all is received for missed intervals, the transport is closed and Receive
+// returns HeartbeatTimeoutError.
+//
+// If t supports read deadlines, they are moved forward as messages arrive, so
+// that a peer which vanishes without closing the connection is noticed even
+// while Receive is blocked.
+func Heartbeat(t Transport, interval time.Duration, missed int) Transport {
+	if missed <= 0 {
+		missed = DefaultMissedHeartbeats
+	}
+	h := &heartbeat{
+		Transport: t,
+		timeout:   interval * time.Duration(missed),
+		lastSeen:  time.Now(),
+		stop:      make(chan struct{}),
+	}
+	h.extendDeadline()
+	go h.beat(interval)
+	return h
+}
+
+type heartbeat struct {
+	Transport
+	timeout time.Duration
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	expired  bool
+	stop     chan struct{}
+	stopped  bool
+}
+
+func (h *heartbeat) Receive() (Message, error) {
+	for {
+		m, err := h.Transport.Receive()
+		if err != nil {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if h.expired || errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, HeartbeatTimeoutError
+			}
+			return nil, err
+		}
+
+		h.mu.Lock()
+		h.lastSeen = time.Now()
+		h.mu.Unlock()
+		h.extendDeadline()
+
+		switch m.Kind() {
+		case PING:
+			if err := h.Send(Pong{}); err != nil {
+				return nil, err
+			}
+		case PONG:
+		default:
+			return m, nil
+		}
+	}
+}
+
+func (h *heartbeat) Close() error {
+	h.mu.Lock()
+	if !h.stopped {
+		h.stopped = true
+		close(h.stop)
+	}
+	h.mu.Unlock()
+	return h.Transport.Close()
+}
+
+func (h *heartbeat) beat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+
+		h.mu.Lock()
+		h.expired = time.Since(h.lastSeen) > h.timeout
+		expired := h.expired
+		h.mu.Unlock()
+		if expired {
+			h.Close()
+			return
+		}
+		h.Send(Ping{})
+	}
+}
+
+func (h *heartbeat) extendDeadline() {
+	if d, ok := h.Transport.(interface{ SetReadDeadline(time.Time) error }); ok {
+		d.SetReadDeadline(time.Now().Add(h.timeout))
+	}
+}
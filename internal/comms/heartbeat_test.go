@@ -0,0 +1,77 @@
+package comms_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+func TestHeartbeatAnswersPings(t *testing.T) {
+	a, b := comms.Pipe()
+	alice := comms.Heartbeat(a, time.Hour, 0)
+	defer alice.Close()
+	go alice.Receive()
+
+	b.Send(comms.Ping{})
+	if got, err := b.Receive(); err != nil || got.Kind() != comms.PONG {
+		t.Errorf("got %v (%v), expected a pong", got, err)
+	}
+}
+
+func TestHeartbeatHidesPings(t *testing.T) {
+	a, b := comms.Pipe()
+	alice := comms.Heartbeat(a, time.Hour, 0)
+	defer alice.Close()
+
+	want := comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	go func() {
+		b.Send(comms.Pong{})
+		b.Send(want)
+	}()
+	if got, err := alice.Receive(); err != nil || *got.(*comms.OpMessage) != want {
+		t.Errorf("got %v (%v), expected %v", got, err, want)
+	}
+}
+
+func TestHeartbeatSendsPings(t *testing.T) {
+	a, b := comms.Pipe()
+	alice := comms.Heartbeat(a, 5*time.Millisecond, 0)
+	defer alice.Close()
+
+	if got, err := b.Receive(); err != nil || got.Kind() != comms.PING {
+		t.Errorf("got %v (%v), expected a ping", got, err)
+	}
+}
+
+func TestHeartbeatGivesUpOnSilentPeer(t *testing.T) {
+	// The peer never reads or writes, as if its machine went to sleep.
+	a, _ := net.Pipe()
+	alice := comms.Heartbeat(comms.NewStream(a), 5*time.Millisecond, 2)
+	defer alice.Close()
+
+	if _, err := alice.Receive(); !errors.Is(err, comms.HeartbeatTimeoutError) {
+		t.Errorf("expected HeartbeatTimeoutError, got %v", err)
+	}
+}
+
+func TestHeartbeatGivesUpWithoutDeadlines(t *testing.T) {
+	a, b := comms.Pipe()
+	alice := comms.Heartbeat(a, 5*time.Millisecond, 2)
+	defer alice.Close()
+	go func() {
+		// Swallow pings without answering them.
+		for {
+			if _, err := b.Receive(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, err := alice.Receive(); !errors.Is(err, comms.HeartbeatTimeoutError) {
+		t.Errorf("expected HeartbeatTimeoutError, got %v", err)
+	}
+}
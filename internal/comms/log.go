@@ -0,0 +1,57 @@
+package comms
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Logged returns a Transport that logs every message sent and received on t
+// at the level reported by level, which may change while the transport is in
+// use. Messages are only encoded for the log when the logger would keep them.
+func Logged(t Transport, logger *slog.Logger, level slog.Leveler) Transport {
+	return &logged{Transport: t, logger: logger, level: level}
+}
+
+type logged struct {
+	Transport
+	logger *slog.Logger
+	level  slog.Leveler
+}
+
+func (l *logged) Send(m Message) error {
+	err := l.Transport.Send(m)
+	l.log("sent message", m, err)
+	return err
+}
+
+func (l *logged) Receive() (Message, error) {
+	m, err := l.Transport.Receive()
+	if err == nil {
+		l.log("received message", m, nil)
+	}
+	return m, err
+}
+
+func (l *logged) log(msg string, m Message, err error) {
+	level := l.level.Level()
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	body, _ := Encode(m)
+	attrs := []slog.Attr{slog.String("kind", m.Kind().String()), slog.String("message", string(body))}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// SetReadDeadline passes deadlines on to the logged transport, if it takes
+// them.
+func (l *logged) SetReadDeadline(t time.Time) error {
+	if d, ok := l.Transport.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
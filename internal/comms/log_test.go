@@ -0,0 +1,40 @@
+package comms_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+func TestLoggedTransport(t *testing.T) {
+	// Given a logged transport
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	var level slog.LevelVar
+	level.Set(slog.LevelDebug)
+	a, b := comms.Pipe()
+	a = comms.Logged(a, logger, &level)
+
+	// When a message is sent at a level the logger drops
+	go b.Receive()
+	a.Send(comms.Ping{})
+
+	// Then nothing should be logged
+	if buf.Len() > 0 {
+		t.Fatalf("logged %q", buf.String())
+	}
+
+	// When the level is raised and a message is received
+	level.Set(slog.LevelInfo)
+	go b.Send(comms.JoinSession{Name: "alice"})
+	a.Receive()
+
+	// Then it should be logged with its kind
+	got := buf.String()
+	if !strings.Contains(got, "received message") || !strings.Contains(got, "kind=join_session") || !strings.Contains(got, "alice") {
+		t.Errorf("logged %q", got)
+	}
+}
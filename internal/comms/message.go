@@ -3,6 +3,7 @@ package comms
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/shed-protocol/shed/internal/ot"
 )
@@ -12,16 +13,153 @@ type MessageKind uint8
 const (
 	BUFFER_OP MessageKind = iota + 1
 	ACK_CHANGE
+	JOIN_SESSION
+	ROSTER
+	PARTICIPANT_JOINED
+	PARTICIPANT_LEFT
+	AUTHENTICATE
+	ERROR
+	SET_ROLE
+	ROLE_CHANGED
+	PING
+	PONG
+	FETCH_DOCUMENT
+	DOCUMENT
+	FETCH_HISTORY
+	HISTORY
+	FETCH_REVISION
+	REVISION
+	FETCH_DIFF
+	DIFF
+	CREATE_CHECKPOINT
+	FETCH_CHECKPOINTS
+	CHECKPOINTS
+	REVERT
+	REVERTED
+	FETCH_BLAME
+	BLAME
+	LIST_DOCUMENTS
+	DOCUMENTS
+	CREATE_DOCUMENT
+	DELETE_DOCUMENT
+	RENAME_DOCUMENT
+	DOCUMENT_EVENT
 )
 
+var kindNames = [...]string{
+	BUFFER_OP:          "buffer_op",
+	ACK_CHANGE:         "ack_change",
+	JOIN_SESSION:       "join_session",
+	ROSTER:             "roster",
+	PARTICIPANT_JOINED: "participant_joined",
+	PARTICIPANT_LEFT:   "participant_left",
+	AUTHENTICATE:       "authenticate",
+	ERROR:              "error",
+	SET_ROLE:           "set_role",
+	ROLE_CHANGED:       "role_changed",
+	PING:               "ping",
+	PONG:               "pong",
+	FETCH_DOCUMENT:     "fetch_document",
+	DOCUMENT:           "document",
+	FETCH_HISTORY:      "fetch_history",
+	HISTORY:            "history",
+	FETCH_REVISION:     "fetch_revision",
+	REVISION:           "revision",
+	FETCH_DIFF:         "fetch_diff",
+	DIFF:               "diff",
+	CREATE_CHECKPOINT:  "create_checkpoint",
+	FETCH_CHECKPOINTS:  "fetch_checkpoints",
+	CHECKPOINTS:        "checkpoints",
+	REVERT:             "revert",
+	REVERTED:           "reverted",
+	FETCH_BLAME:        "fetch_blame",
+	BLAME:              "blame",
+	LIST_DOCUMENTS:     "list_documents",
+	DOCUMENTS:          "documents",
+	CREATE_DOCUMENT:    "create_document",
+	DELETE_DOCUMENT:    "delete_document",
+	RENAME_DOCUMENT:    "rename_document",
+	DOCUMENT_EVENT:     "document_event",
+}
+
+// String names the kind for logs.
+func (k MessageKind) String() string {
+	if int(k) < len(kindNames) && kindNames[k] != "" {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", k)
+}
+
 func MessageOfKind(k MessageKind) Message {
 	switch k {
 	case BUFFER_OP:
 		return &OpMessage{}
 	case ACK_CHANGE:
 		return &AcknowledgeChange{}
+	case JOIN_SESSION:
+		return &JoinSession{}
+	case ROSTER:
+		return &Roster{}
+	case PARTICIPANT_JOINED:
+		return &ParticipantJoined{}
+	case PARTICIPANT_LEFT:
+		return &ParticipantLeft{}
+	case AUTHENTICATE:
+		return &Authenticate{}
+	case ERROR:
+		return &ErrorMessage{}
+	case SET_ROLE:
+		return &SetRole{}
+	case ROLE_CHANGED:
+		return &RoleChanged{}
+	case PING:
+		return &Ping{}
+	case PONG:
+		return &Pong{}
+	case FETCH_DOCUMENT:
+		return &FetchDocument{}
+	case DOCUMENT:
+		return &Document{}
+	case FETCH_HISTORY:
+		return &FetchHistory{}
+	case HISTORY:
+		return &History{}
+	case FETCH_REVISION:
+		return &FetchRevision{}
+	case REVISION:
+		return &Revision{}
+	case FETCH_DIFF:
+		return &FetchDiff{}
+	case DIFF:
+		return &Diff{}
+	case CREATE_CHECKPOINT:
+		return &CreateCheckpoint{}
+	case FETCH_CHECKPOINTS:
+		return &FetchCheckpoints{}
+	case CHECKPOINTS:
+		return &Checkpoints{}
+	case REVERT:
+		return &Revert{}
+	case REVERTED:
+		return &Reverted{}
+	case FETCH_BLAME:
+		return &FetchBlame{}
+	case BLAME:
+		return &Blame{}
+	case LIST_DOCUMENTS:
+		return &ListDocuments{}
+	case DOCUMENTS:
+		return &Documents{}
+	case CREATE_DOCUMENT:
+		return &CreateDocument{}
+	case DELETE_DOCUMENT:
+		return &DeleteDocument{}
+	case RENAME_DOCUMENT:
+		return &RenameDocument{}
+	case DOCUMENT_EVENT:
+		return &DocumentEvent{}
 	default:
-		panic("unrecognized message kind")
+		return nil
 	}
 }
 
@@ -29,47 +167,54 @@ type Message interface {
 	Kind() MessageKind
 }
 
+// An OpMessage carries a change to the document. Author, set by the server on
+// the changes it relays, names the user who made the change.
+//
+// Rev, set by clients on the changes they send the server, is the revision of
+// the document the change was made to; the server rebases it over the changes
+// made since. Seen, set by editors and clients on the changes they pass each
+// other, counts the changes the other has passed on that it had taken into
+// account, so that changes that cross on the way can be rebased over each
+// other. Changes without them are taken to be made to the latest version.
 type OpMessage struct {
-	Op ot.Operation `json:"op"`
+	Op     ot.Operation `json:"op"`
+	Author string       `json:"author,omitempty"`
+	Rev    *int         `json:"rev,omitempty"`
+	Seen   *int         `json:"seen,omitempty"`
 }
 
 func (OpMessage) Kind() MessageKind {
 	return BUFFER_OP
 }
 
+// AsOp returns the operation carried by m, if it is an OpMessage.
+func AsOp(m Message) (op ot.Operation, ok bool) {
+	switch m := m.(type) {
+	case OpMessage:
+		op, ok = m.Op, true
+	case *OpMessage:
+		op, ok = m.Op, true
+	}
+	return
+}
+
 func (m *OpMessage) UnmarshalJSON(body []byte) error {
 	type opWrapper struct {
-		Op json.RawMessage `json:"op"`
+		Op     json.RawMessage `json:"op"`
+		Author string          `json:"author"`
+		Rev    *int            `json:"rev"`
+		Seen   *int            `json:"seen"`
 	}
 	var w1 opWrapper
 	if err := json.Unmarshal(body, &w1); err != nil {
 		return err
 	}
 
-	type typeWrapper struct {
-		Type string `json:"type"`
-	}
-	var w2 typeWrapper
-	if err := json.Unmarshal(w1.Op, &w2); err != nil {
+	op, err := ot.Unmarshal(w1.Op)
+	if err != nil {
 		return err
 	}
-
-	switch w2.Type {
-	case "insertion":
-		var op ot.Insertion
-		if err := json.Unmarshal(w1.Op, &op); err != nil {
-			return err
-		}
-		m.Op = op
-	case "deletion":
-		var op ot.Deletion
-		if err := json.Unmarshal(w1.Op, &op); err != nil {
-			return err
-		}
-		m.Op = op
-	default:
-		return fmt.Errorf("unrecognized operation type: %q", w2.Type)
-	}
+	m.Op, m.Author, m.Rev, m.Seen = op, w1.Author, w1.Rev, w1.Seen
 	return nil
 }
 
@@ -79,3 +224,447 @@ type AcknowledgeChange struct {
 func (AcknowledgeChange) Kind() MessageKind {
 	return ACK_CHANGE
 }
+
+// A Participant is a user taking part in an editing session.
+type Participant struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Colour string `json:"colour"`
+	Role   Role   `json:"role,omitempty"`
+}
+
+// JoinSession is sent by a client to declare who is editing.
+type JoinSession struct {
+	Name   string `json:"name"`
+	Colour string `json:"colour"`
+}
+
+func (JoinSession) Kind() MessageKind {
+	return JOIN_SESSION
+}
+
+// Roster is sent to a client once it has joined, listing everyone present.
+// Self is the participant ID the server assigned to the receiving client.
+type Roster struct {
+	Self         int           `json:"self"`
+	Participants []Participant `json:"participants"`
+}
+
+func (Roster) Kind() MessageKind {
+	return ROSTER
+}
+
+// ParticipantJoined is sent to everyone else on the document when a client
+// joins its session.
+type ParticipantJoined struct {
+	Participant Participant `json:"participant"`
+}
+
+func (ParticipantJoined) Kind() MessageKind {
+	return PARTICIPANT_JOINED
+}
+
+// ParticipantLeft is sent to everyone else on the document when a client that
+// had joined disconnects.
+type ParticipantLeft struct {
+	Id int `json:"id"`
+}
+
+func (ParticipantLeft) Kind() MessageKind {
+	return PARTICIPANT_LEFT
+}
+
+// Authenticate is the first message sent on a new connection when the server
+// requires authentication. It carries either the server's shared secret or a
+// signed bearer token, along with the name of the document to edit.
+type Authenticate struct {
+	Document string `json:"document"`
+	Secret   string `json:"secret,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+func (Authenticate) Kind() MessageKind {
+	return AUTHENTICATE
+}
+
+// Error codes carried by an ErrorMessage.
+const (
+	UNAUTHORIZED    = "unauthorized"
+	FORBIDDEN       = "forbidden"
+	READ_ONLY       = "read_only"
+	CONNECTION_LOST = "connection_lost"
+	RATE_LIMITED    = "rate_limited"
+	TOO_MANY_CONNS  = "too_many_connections"
+	TOO_LARGE       = "document_too_large"
+	GOING_AWAY      = "going_away"
+	INTERNAL        = "internal"
+	KICKED          = "kicked"
+	NO_SUCH_REV     = "no_such_revision"
+	INVALID         = "invalid_request"
+	NO_SUCH_DOC     = "no_such_document"
+	EXISTS          = "document_exists"
+)
+
+// An ErrorMessage tells the receiver that a request was rejected.
+type ErrorMessage struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Change is set if the error answers a change, which the server has
+	// discarded rather than applied.
+	Change bool `json:"change,omitempty"`
+}
+
+func (ErrorMessage) Kind() MessageKind {
+	return ERROR
+}
+
+func (e ErrorMessage) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Rejected reports whether e means a change was discarded rather than
+// applied, so that its sender should stop waiting for an acknowledgement.
+// Its copy of the document still holds the change, so it should fetch the
+// document again; clients drop an editor's changes until it does. Errors
+// answering other requests, even with the same code, don't.
+func (e ErrorMessage) Rejected() bool {
+	return e.Change
+}
+
+// A Role determines what a participant may do to a document.
+type Role string
+
+const (
+	OWNER  Role = "owner"
+	EDITOR Role = "editor"
+	VIEWER Role = "viewer"
+)
+
+// CanEdit reports whether a participant with the role may change the document.
+func (r Role) CanEdit() bool {
+	return r == OWNER || r == EDITOR
+}
+
+// Valid reports whether r is one of the defined roles.
+func (r Role) Valid() bool {
+	return r == OWNER || r == EDITOR || r == VIEWER
+}
+
+// SetRole is sent by a document's owner to change another participant's role.
+type SetRole struct {
+	Id   int  `json:"id"`
+	Role Role `json:"role"`
+}
+
+func (SetRole) Kind() MessageKind {
+	return SET_ROLE
+}
+
+// RoleChanged announces that a participant's role has changed.
+type RoleChanged struct {
+	Id   int  `json:"id"`
+	Role Role `json:"role"`
+}
+
+func (RoleChanged) Kind() MessageKind {
+	return ROLE_CHANGED
+}
+
+// Ping asks the receiver to show it is still there by replying with a Pong.
+type Ping struct {
+}
+
+func (Ping) Kind() MessageKind {
+	return PING
+}
+
+type Pong struct {
+}
+
+func (Pong) Kind() MessageKind {
+	return PONG
+}
+
+// FetchDocument asks the server for the current contents of the session's
+// document.
+type FetchDocument struct {
+}
+
+func (FetchDocument) Kind() MessageKind {
+	return FETCH_DOCUMENT
+}
+
+// A Document is the server's copy of a document, after Rev changes have been
+// made to it.
+type Document struct {
+	Text string `json:"text"`
+	Rev  int    `json:"rev"`
+}
+
+func (Document) Kind() MessageKind {
+	return DOCUMENT
+}
+
+// A Change is one of the changes made to a document, as recorded by the
+// server. Rev is the document's revision once the change was made.
+type Change struct {
+	Rev    int          `json:"rev"`
+	Author string       `json:"author,omitempty"`
+	Time   time.Time    `json:"time,omitzero"`
+	Op     ot.Operation `json:"op"`
+}
+
+func (c *Change) UnmarshalJSON(body []byte) error {
+	var w struct {
+		Rev    int             `json:"rev"`
+		Author string          `json:"author"`
+		Time   time.Time       `json:"time"`
+		Op     json.RawMessage `json:"op"`
+	}
+	if err := json.Unmarshal(body, &w); err != nil {
+		return err
+	}
+	op, err := ot.Unmarshal(w.Op)
+	if err != nil {
+		return err
+	}
+	*c = Change{Rev: w.Rev, Author: w.Author, Time: w.Time, Op: op}
+	return nil
+}
+
+// FetchHistory asks the server for the changes made to the session's document
+// after revision From, oldest first. At most Limit are sent, or all of them if
+// Limit is 0.
+type FetchHistory struct {
+	From  int `json:"from"`
+	Limit int `json:"limit,omitempty"`
+}
+
+func (FetchHistory) Kind() MessageKind {
+	return FETCH_HISTORY
+}
+
+// History answers FetchHistory. Rev is the document's current revision.
+type History struct {
+	Changes []Change `json:"changes"`
+	Rev     int      `json:"rev"`
+}
+
+func (History) Kind() MessageKind {
+	return HISTORY
+}
+
+// FetchRevision asks the server for the session's document as it was at
+// revision Rev or, if Time is set, as it was at that time.
+type FetchRevision struct {
+	Rev  int       `json:"rev"`
+	Time time.Time `json:"time,omitzero"`
+}
+
+func (FetchRevision) Kind() MessageKind {
+	return FETCH_REVISION
+}
+
+// A Revision is the document as it was after Rev changes, the last of which
+// was made at Time.
+type Revision struct {
+	Text string    `json:"text"`
+	Rev  int       `json:"rev"`
+	Time time.Time `json:"time,omitzero"`
+}
+
+func (Revision) Kind() MessageKind {
+	return REVISION
+}
+
+// FetchDiff asks the server how the session's document changed between two
+// revisions.
+type FetchDiff struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (FetchDiff) Kind() MessageKind {
+	return FETCH_DIFF
+}
+
+// Diff answers FetchDiff with the document's text at revision From and the
+// changes that turned it into revision To.
+type Diff struct {
+	From    int      `json:"from"`
+	To      int      `json:"to"`
+	Text    string   `json:"text"`
+	Changes []Change `json:"changes"`
+}
+
+func (Diff) Kind() MessageKind {
+	return DIFF
+}
+
+// A Checkpoint names a revision of a document.
+type Checkpoint struct {
+	Name   string    `json:"name"`
+	Rev    int       `json:"rev"`
+	Author string    `json:"author,omitempty"`
+	Time   time.Time `json:"time,omitzero"`
+}
+
+// CreateCheckpoint asks the server to name a revision of the session's
+// document. Rev, if set, is the revision to name; otherwise it is the current
+// one. A checkpoint replaces any other of the same name.
+type CreateCheckpoint struct {
+	Name string `json:"name"`
+	Rev  *int   `json:"rev,omitempty"`
+}
+
+func (CreateCheckpoint) Kind() MessageKind {
+	return CREATE_CHECKPOINT
+}
+
+// FetchCheckpoints asks the server for the checkpoints of the session's
+// document.
+type FetchCheckpoints struct {
+}
+
+func (FetchCheckpoints) Kind() MessageKind {
+	return FETCH_CHECKPOINTS
+}
+
+// Checkpoints answers CreateCheckpoint and FetchCheckpoints with every
+// checkpoint of the document, oldest first.
+type Checkpoints struct {
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+func (Checkpoints) Kind() MessageKind {
+	return CHECKPOINTS
+}
+
+// Revert asks the server to restore the session's document to the text it
+// had at revision Rev or, if Checkpoint is set, at the named checkpoint. The
+// server makes the changes that restore it like any other, sending them to
+// every session including this one, then answers with Reverted.
+type Revert struct {
+	Rev        int    `json:"rev"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+}
+
+func (Revert) Kind() MessageKind {
+	return REVERT
+}
+
+// Reverted answers Revert once the document has the text of revision To
+// again. Rev is the document's revision afterwards.
+type Reverted struct {
+	To  int `json:"to"`
+	Rev int `json:"rev"`
+}
+
+func (Reverted) Kind() MessageKind {
+	return REVERTED
+}
+
+// FetchBlame asks the server who wrote each part of the session's document.
+type FetchBlame struct {
+}
+
+func (FetchBlame) Kind() MessageKind {
+	return FETCH_BLAME
+}
+
+// A Span is a stretch of a document written by one author in the change
+// that took the document to revision Rev. Pos and Len count bytes, as
+// operations do.
+type Span struct {
+	Pos    int    `json:"pos"`
+	Len    int    `json:"len"`
+	Author string `json:"author,omitempty"`
+	Rev    int    `json:"rev"`
+}
+
+// Blame answers FetchBlame with the document's text at revision Rev and the
+// spans that cover it, in order.
+type Blame struct {
+	Text  string `json:"text"`
+	Rev   int    `json:"rev"`
+	Spans []Span `json:"spans"`
+}
+
+func (Blame) Kind() MessageKind {
+	return BLAME
+}
+
+// ListDocuments asks the server for the names of the documents starting with
+// Prefix that the session may open. From then on, the server tells the
+// session of documents under Prefix that others create, delete or rename.
+type ListDocuments struct {
+	Prefix string `json:"prefix,omitempty"`
+}
+
+func (ListDocuments) Kind() MessageKind {
+	return LIST_DOCUMENTS
+}
+
+// Documents answers ListDocuments, CreateDocument, DeleteDocument and
+// RenameDocument with the names of the documents under the prefix the session
+// last listed, in order.
+type Documents struct {
+	Names []string `json:"names"`
+}
+
+func (Documents) Kind() MessageKind {
+	return DOCUMENTS
+}
+
+// CreateDocument asks the server to create an empty document. It fails with
+// EXISTS if the document has been opened or has changes.
+type CreateDocument struct {
+	Name string `json:"name"`
+}
+
+func (CreateDocument) Kind() MessageKind {
+	return CREATE_DOCUMENT
+}
+
+// DeleteDocument asks the server to delete a document, disconnecting everyone
+// editing it. Only the document's owners may.
+type DeleteDocument struct {
+	Name string `json:"name"`
+}
+
+func (DeleteDocument) Kind() MessageKind {
+	return DELETE_DOCUMENT
+}
+
+// RenameDocument asks the server to give a document a new name, disconnecting
+// everyone editing it so that they reopen it under the new one. Only the
+// document's owners may.
+type RenameDocument struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (RenameDocument) Kind() MessageKind {
+	return RENAME_DOCUMENT
+}
+
+// Actions reported by a DocumentEvent.
+const (
+	CREATED = "created"
+	DELETED = "deleted"
+	RENAMED = "renamed"
+)
+
+// A DocumentEvent tells a session that has listed documents that one under
+// its prefix was created, deleted or renamed to To. Author names the user who
+// did so, if known.
+type DocumentEvent struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	To     string `json:"to,omitempty"`
+	Author string `json:"author,omitempty"`
+}
+
+func (DocumentEvent) Kind() MessageKind {
+	return DOCUMENT_EVENT
+}
@@ -8,6 +8,31 @@ func TestMessageKinds(t *testing.T) {
 	kinds := []MessageKind{
 		BUFFER_OP,
 		ACK_CHANGE,
+		JOIN_SESSION,
+		ROSTER,
+		PARTICIPANT_JOINED,
+		PARTICIPANT_LEFT,
+		AUTHENTICATE,
+		ERROR,
+		SET_ROLE,
+		ROLE_CHANGED,
+		PING,
+		PONG,
+		FETCH_DOCUMENT,
+		DOCUMENT,
+		FETCH_HISTORY,
+		HISTORY,
+		FETCH_REVISION,
+		REVISION,
+		FETCH_DIFF,
+		DIFF,
+		CREATE_CHECKPOINT,
+		FETCH_CHECKPOINTS,
+		CHECKPOINTS,
+		REVERT,
+		REVERTED,
+		FETCH_BLAME,
+		BLAME,
 	}
 	for _, k := range kinds {
 		msg := MessageOfKind(k)
@@ -16,3 +41,9 @@ func TestMessageKinds(t *testing.T) {
 		}
 	}
 }
+
+func TestUnrecognizedMessageKind(t *testing.T) {
+	if msg := MessageOfKind(0); msg != nil {
+		t.Errorf("MessageOfKind(0) returned %T, expected nil", msg)
+	}
+}
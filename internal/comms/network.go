@@ -0,0 +1,101 @@
+package comms
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// UnixPrefix marks an address as the path of a Unix domain socket rather than
+// a TCP host and port.
+const UnixPrefix = "unix:"
+
+// Listen listens on a TCP address, or on a Unix domain socket if addr starts
+// with UnixPrefix. A socket left behind by a previous listener is replaced,
+// but not one that is still being listened on. The new socket is only ever
+// accessible to the current user.
+func Listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, UnixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, inUse(path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, inUse(path)
+		}
+	}
+
+	// The socket is made in a directory only the current user can enter,
+	// then moved into place, so that no one else can connect to it before
+	// its mode is set.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".s")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		l.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, path: path, unlink: true}, nil
+}
+
+func inUse(path string) error {
+	return &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: syscall.EADDRINUSE}
+}
+
+// A unixListener listens on a socket moved to path after it was made,
+// removing it when closed as net.UnixListener would.
+type unixListener struct {
+	*net.UnixListener
+	path   string
+	unlink bool
+	once   sync.Once
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// SetUnlinkOnClose sets whether the socket is removed when the listener is
+// closed, which it is by default.
+func (l *unixListener) SetUnlinkOnClose(unlink bool) {
+	l.unlink = unlink
+}
+
+func (l *unixListener) Close() error {
+	l.once.Do(func() {
+		if l.unlink {
+			os.Remove(l.path)
+		}
+	})
+	return l.UnixListener.Close()
+}
+
+// Dial connects to an address understood by Listen.
+func Dial(addr string) (net.Conn, error) {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		return net.Dial("unix", path)
+	}
+	return net.Dial("tcp", addr)
+}
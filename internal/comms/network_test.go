@@ -0,0 +1,87 @@
+package comms_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shed.sock")
+	l, err := comms.Listen(comms.UnixPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket has mode %v (%v), expected 0600", info.Mode(), err)
+	}
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		comms.WriteContent(c, "hello")
+		c.Close()
+	}()
+	c, err := comms.Dial(comms.UnixPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got, err := comms.ReadContent(c); err != nil || got != "hello" {
+		t.Errorf("got %q (%v), expected hello", got, err)
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shed.sock")
+	l, err := comms.Listen(comms.UnixPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing a Unix listener removes its socket, so leave one behind the
+	// way a crashed process would.
+	l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+	l.Close()
+
+	l, err = comms.Listen(comms.UnixPrefix + path)
+	if err != nil {
+		t.Fatalf("could not replace stale socket: %s", err)
+	}
+	l.Close()
+}
+
+func TestListenKeepsLiveSocket(t *testing.T) {
+	// Given a socket someone is listening on
+	path := filepath.Join(t.TempDir(), "shed.sock")
+	l, err := comms.Listen(comms.UnixPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// When another listener tries to take its place
+	_, err = comms.Listen(comms.UnixPrefix + path)
+
+	// Then it should be told the address is in use
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("got %v, expected the address to be in use", err)
+	}
+
+	// Then the first should still be reachable
+	if c, err := comms.Dial(comms.UnixPrefix + path); err != nil {
+		t.Errorf("could not reach the first listener: %s", err)
+	} else {
+		c.Close()
+	}
+	if got := l.Addr().String(); got != path {
+		t.Errorf("listening on %s, expected %s", got, path)
+	}
+}
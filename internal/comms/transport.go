@@ -1,14 +1,20 @@
 package comms
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net"
+	"sync"
+	"time"
 )
 
-const MaxPayloadSize int = 1024 * 1024
+// MaxPayloadSize bounds the size of a message. Programs may change it before
+// making any connections.
+var MaxPayloadSize int = 1024 * 1024
 
 var PayloadTooLargeError = errors.New("message too long")
 
@@ -54,3 +60,199 @@ func WriteContent(w io.Writer, msg string) error {
 	_, err := w.Write(data)
 	return err
 }
+
+// A Transport carries whole messages between two peers.
+type Transport interface {
+	Send(Message) error
+	Receive() (Message, error)
+	Close() error
+
+	// Remote describes the peer at the other end of the transport.
+	Remote() Peer
+}
+
+// A Peer identifies the remote end of a Transport.
+type Peer struct {
+	// Addr is the peer's network address, if it has one.
+	Addr string
+
+	// Name is the identity the peer proved while the connection was set up,
+	// such as the common name of a verified TLS client certificate.
+	Name string
+}
+
+// ClosedError is returned by transports that have been closed.
+var ClosedError = errors.New("transport closed")
+
+// NewStream returns a Transport that frames messages on a byte stream with
+// WriteContent. Closing the transport closes rw if it is an io.Closer.
+//
+// A stream over a *tls.Conn whose handshake has completed reports the common
+// name of the peer's verified certificate as its name.
+func NewStream(rw io.ReadWriter) Transport {
+	return NewFramedStream(rw, LengthFrames)
+}
+
+// A stream carries messages' JSON envelopes on rw, framed by f.
+type stream struct {
+	rw  io.ReadWriter
+	f   Framer
+	wmu sync.Mutex
+}
+
+func (s *stream) Send(m Message) error {
+	content, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.f.WriteFrame(content)
+}
+
+func (s *stream) Receive() (Message, error) {
+	content, err := s.f.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	return Decode(content)
+}
+
+func (s *stream) Close() error {
+	if c, ok := s.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (s *stream) Remote() Peer {
+	return peerOf(s.rw)
+}
+
+func (s *stream) SetReadDeadline(t time.Time) error {
+	if d, ok := s.rw.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// A MessageConn sends and receives whole messages, such as WebSocket frames.
+type MessageConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage([]byte) error
+	Close() error
+}
+
+// NewMessageStream returns a Transport that sends each message as a single
+// JSON envelope on c.
+func NewMessageStream(c MessageConn) Transport {
+	return &messageStream{c: c}
+}
+
+type messageStream struct {
+	c   MessageConn
+	wmu sync.Mutex
+}
+
+func (s *messageStream) Send(m Message) error {
+	content, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	if len(content) > MaxPayloadSize {
+		return PayloadTooLargeError
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.c.WriteMessage(content)
+}
+
+func (s *messageStream) Receive() (Message, error) {
+	content, err := s.c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxPayloadSize {
+		return nil, PayloadTooLargeError
+	}
+	return Decode(content)
+}
+
+func (s *messageStream) Close() error {
+	return s.c.Close()
+}
+
+func (s *messageStream) Remote() Peer {
+	return peerOf(s.c)
+}
+
+func (s *messageStream) SetReadDeadline(t time.Time) error {
+	if d, ok := s.c.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// Pipe returns two connected in-memory transports. Messages are copied through
+// their JSON encoding, so each side receives the same types it would from a
+// network transport and never shares memory with the sender.
+func Pipe() (Transport, Transport) {
+	ab, ba := make(chan []byte), make(chan []byte)
+	done := make(chan struct{})
+	var once sync.Once
+	closer := func() error {
+		once.Do(func() { close(done) })
+		return nil
+	}
+	return &pipe{ab, ba, done, closer}, &pipe{ba, ab, done, closer}
+}
+
+type pipe struct {
+	out   chan<- []byte
+	in    <-chan []byte
+	done  chan struct{}
+	close func() error
+}
+
+func (p *pipe) Send(m Message) error {
+	content, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	select {
+	case p.out <- content:
+		return nil
+	case <-p.done:
+		return ClosedError
+	}
+}
+
+func (p *pipe) Receive() (Message, error) {
+	select {
+	case content := <-p.in:
+		return Decode(content)
+	case <-p.done:
+		return nil, ClosedError
+	}
+}
+
+func (p *pipe) Close() error {
+	return p.close()
+}
+
+func (p *pipe) Remote() Peer {
+	return Peer{Addr: "pipe"}
+}
+
+func peerOf(v any) Peer {
+	var p Peer
+	if c, ok := v.(interface{ RemoteAddr() net.Addr }); ok {
+		p.Addr = c.RemoteAddr().String()
+	}
+	if c, ok := v.(*tls.Conn); ok {
+		if chains := c.ConnectionState().VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+			p.Name = chains[0][0].Subject.CommonName
+		}
+	}
+	return p
+}
@@ -38,7 +38,7 @@ func TestReadReturnsMessage(t *testing.T) {
 }
 
 func TestReadFailsOnLongMessage(t *testing.T) {
-	const n = uint32(comms.MaxPayloadSize) + 1
+	n := uint32(comms.MaxPayloadSize) + 1
 	header := binary.BigEndian.AppendUint32(nil, n)
 	alice, bob := net.Pipe()
 
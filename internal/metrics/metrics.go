@@ -0,0 +1,211 @@
+// Package metrics keeps counts and timings and writes them in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Counter only goes up. The zero value is ready to use.
+type Counter struct {
+	n atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.n.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.n.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.n.Load()
+}
+
+// A Gauge goes up and down. The zero value is ready to use.
+type Gauge struct {
+	n atomic.Int64
+}
+
+func (g *Gauge) Add(n int64) {
+	g.n.Add(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.n.Load()
+}
+
+// A CounterVec is a set of counters told apart by the value of one label.
+// The zero value is ready to use.
+type CounterVec struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// With returns the counter for a label value, creating it if need be.
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[value]
+	if !ok {
+		if v.counters == nil {
+			v.counters = make(map[string]*Counter)
+		}
+		c = new(Counter)
+		v.counters[value] = c
+	}
+	return c
+}
+
+// values returns the counts by label value, ordered by label value.
+func (v *CounterVec) values() ([]string, []uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	labels := make([]string, 0, len(v.counters))
+	for l := range v.counters {
+		labels = append(labels, l)
+	}
+	slices.Sort(labels)
+	counts := make([]uint64, len(labels))
+	for i, l := range labels {
+		counts[i] = v.counters[l].Value()
+	}
+	return labels, counts
+}
+
+// LatencyBuckets suit operations that take between a millisecond and a few
+// seconds, in seconds.
+var LatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// A Histogram counts observations in buckets by their upper bound.
+type Histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	n      uint64
+}
+
+// NewHistogram returns a histogram with buckets for each of the ascending
+// upper bounds given.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.n++
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// A Writer writes metrics in the text exposition format, keeping the first
+// error it meets.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Flush writes anything buffered and returns the first error met.
+func (w *Writer) Flush() error {
+	if err := w.w.Flush(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+func (w *Writer) header(name, typ, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (w *Writer) Counter(name, help string, n uint64) {
+	w.header(name, "counter", help)
+	w.printf("%s %d\n", name, n)
+}
+
+func (w *Writer) Gauge(name, help string, v float64) {
+	w.header(name, "gauge", help)
+	w.printf("%s %s\n", name, formatFloat(v))
+}
+
+// CounterVec writes a sample for each value of v's label, which is called
+// label.
+func (w *Writer) CounterVec(name, help, label string, v *CounterVec) {
+	w.header(name, "counter", help)
+	values, counts := v.values()
+	for i, value := range values {
+		w.printf("%s{%s=%s} %d\n", name, label, quote(value), counts[i])
+	}
+}
+
+func (w *Writer) Histogram(name, help string, h *Histogram) {
+	h.mu.Lock()
+	counts, sum, n := slices.Clone(h.counts), h.sum, h.n
+	h.mu.Unlock()
+
+	w.header(name, "histogram", help)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += counts[i]
+		w.printf("%s_bucket{le=%q} %d\n", name, formatFloat(bound), cumulative)
+	}
+	w.printf("%s_bucket{le=\"+Inf\"} %d\n", name, n)
+	w.printf("%s_sum %s\n%s_count %d\n", name, formatFloat(sum), name, n)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// Handler serves the metrics written by write.
+func Handler(write func(w *Writer)) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := NewWriter(rw)
+		write(w)
+		w.Flush()
+	})
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriterFormatsMetrics(t *testing.T) {
+	// Given a counter, a labelled counter and a histogram with samples
+	var c Counter
+	c.Add(3)
+	var v CounterVec
+	v.With("rate_limited").Inc()
+	v.With(`say "hi"`).Add(2)
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	// When they are written out
+	var b strings.Builder
+	w := NewWriter(&b)
+	w.Counter("shed_ops_total", "Changes received.", c.Value())
+	w.CounterVec("shed_errors_total", "Errors sent.", "code", &v)
+	w.Histogram("shed_save_seconds", "Time to save.", h)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then they should be in the text exposition format
+	want := `# HELP shed_ops_total Changes received.
+# TYPE shed_ops_total counter
+shed_ops_total 3
+# HELP shed_errors_total Errors sent.
+# TYPE shed_errors_total counter
+shed_errors_total{code="rate_limited"} 1
+shed_errors_total{code="say \"hi\""} 2
+# HELP shed_save_seconds Time to save.
+# TYPE shed_save_seconds histogram
+shed_save_seconds_bucket{le="0.1"} 1
+shed_save_seconds_bucket{le="1"} 2
+shed_save_seconds_bucket{le="+Inf"} 3
+shed_save_seconds_sum 5.55
+shed_save_seconds_count 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nexpected\n%s", got, want)
+	}
+}
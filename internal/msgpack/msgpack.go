@@ -0,0 +1,337 @@
+// Package msgpack implements enough of MessagePack to speak msgpack-RPC with
+// Neovim.
+//
+// Values decode as nil, bool, int64, uint64 (only for integers too large for
+// int64), float64, string, []byte, []any, map[string]any and Ext.
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+var (
+	UnsupportedTypeError = errors.New("msgpack: unsupported type")
+	FormatError          = errors.New("msgpack: malformed data")
+)
+
+// maxLength bounds the length of strings, arrays and maps read, so that a
+// corrupt length can't exhaust memory.
+const maxLength = 64 << 20
+
+// An Ext is an application-defined value, such as a Neovim buffer handle,
+// whose meaning depends on its type.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// Int returns the integer an Ext holds, as Neovim's handles do.
+func (e Ext) Int() (int64, error) {
+	v, err := NewDecoder(bytes.NewReader(e.Data)).Decode()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := AsInt(v)
+	if !ok {
+		return 0, FormatError
+	}
+	return n, nil
+}
+
+// AsInt returns v as an int64, if it is an integer that fits.
+func AsInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// Marshal returns the MessagePack encoding of v, which may be nil, a bool, an
+// integer, a float64, a string, a []byte, an Ext, or a []any, []string or
+// map[string]any of those.
+func Marshal(v any) ([]byte, error) {
+	return appendValue(nil, v)
+}
+
+func appendValue(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return appendInt(b, int64(v)), nil
+	case int64:
+		return appendInt(b, v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), v), nil
+		}
+		return appendInt(b, int64(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	case string:
+		b = appendLength(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(b, v...), nil
+	case []byte:
+		b = appendLength(b, len(v), 0, -1, 0xc4, 0xc5, 0xc6)
+		return append(b, v...), nil
+	case []string:
+		b = appendLength(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, s := range v {
+			b, _ = appendValue(b, s)
+		}
+		return b, nil
+	case []any:
+		b = appendLength(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			var err error
+			if b, err = appendValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		b = appendLength(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for k, e := range v {
+			b, _ = appendValue(b, k)
+			var err error
+			if b, err = appendValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case Ext:
+		switch n := len(v.Data); n {
+		case 1, 2, 4, 8, 16:
+			// fixext 1 to fixext 16
+			b = append(b, 0xd4+byte(bits.TrailingZeros(uint(n))))
+		default:
+			b = appendLength(b, len(v.Data), 0, -1, 0xc7, 0xc8, 0xc9)
+		}
+		b = append(b, byte(v.Type))
+		return append(b, v.Data...), nil
+	}
+	return nil, fmt.Errorf("%w: %T", UnsupportedTypeError, v)
+}
+
+func appendInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 0x7f, n < 0 && n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+}
+
+// appendLength appends the header of a string, binary, array, map or ext of
+// n elements: fix|n if n fits in fixMax, or else the 8, 16 or 32-bit form.
+// A zero code means the type has no such form.
+func appendLength(b []byte, n int, fix byte, fixMax int, c8, c16, c32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint8 && c8 != 0:
+		return append(b, c8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, c16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, c32), uint32(n))
+}
+
+// A Decoder reads MessagePack values from a stream.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{bufio.NewReader(r)}
+}
+
+// Decode reads the next value. It returns io.EOF only if the stream ends
+// between values.
+func (d *Decoder) Decode() (any, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	v, err := d.value(c)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func (d *Decoder) value(c byte) (any, error) {
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.bytes(int(c&0x1f), true)
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(c - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(n, false)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.length(c - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (c - 0xcc))
+		if n > math.MaxInt64 {
+			return n, err
+		}
+		return int64(n), err
+	case 0xd0:
+		n, err := d.uint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.uint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.uint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.uint(8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(c - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(n, true)
+	case 0xdc, 0xdd:
+		n, err := d.length(c - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n)
+	case 0xde, 0xdf:
+		n, err := d.length(c - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n)
+	}
+	return nil, fmt.Errorf("%w: unknown code %#x", FormatError, c)
+}
+
+// uint reads an n-byte big-endian unsigned integer.
+func (d *Decoder) uint(n int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[8-n:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// length reads a length of 1, 2 or 4 bytes, for size 0, 1 or 2.
+func (d *Decoder) length(size byte) (int, error) {
+	n, err := d.uint(1 << size)
+	if err != nil {
+		return 0, err
+	}
+	if n > maxLength {
+		return 0, fmt.Errorf("%w: length %d", FormatError, n)
+	}
+	return int(n), nil
+}
+
+func (d *Decoder) bytes(n int, str bool) (any, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	if str {
+		return string(b), nil
+	}
+	return b, nil
+}
+
+func (d *Decoder) ext(n int) (any, error) {
+	t, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return nil, err
+	}
+	return Ext{int8(t), data}, nil
+}
+
+func (d *Decoder) arrayOf(n int) (any, error) {
+	a := make([]any, 0, min(n, 1024))
+	for range n {
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (d *Decoder) mapOf(n int) (any, error) {
+	m := make(map[string]any, min(n, 1024))
+	for range n {
+		k, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: map key of type %T", FormatError, k)
+		}
+		if m[key], err = d.Decode(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,88 @@
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, v := range []any{
+		nil, true, false,
+		int64(0), int64(127), int64(-32), int64(-33), int64(200), int64(-200),
+		int64(70000), int64(-70000), int64(math.MaxInt64), int64(math.MinInt64), uint64(math.MaxUint64),
+		1.5, "", "héllo", strings.Repeat("x", 40), strings.Repeat("x", 300), strings.Repeat("x", 70000),
+		[]byte{1, 2, 3},
+		[]any{int64(1), "two", []any{nil}},
+		make([]any, 20),
+		map[string]any{"a": int64(1), "b": []any{"c"}},
+		Ext{Type: 0, Data: []byte{7}},
+		Ext{Type: 2, Data: []byte{1, 2, 3}},
+	} {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := NewDecoder(bytes.NewReader(b)).Decode()
+		if err != nil {
+			t.Errorf("decoding %v: %v", v, err)
+		} else if !reflect.DeepEqual(got, v) {
+			t.Errorf("got %#v, expected %#v", got, v)
+		}
+	}
+}
+
+func TestMarshalConverts(t *testing.T) {
+	// Given values of types that decode as others
+	for v, want := range map[any]any{
+		42:             int64(42),
+		uint64(42):     int64(42),
+		"strings only": "strings only",
+	} {
+		// When they are encoded and decoded
+		b, _ := Marshal(v)
+		got, err := NewDecoder(bytes.NewReader(b)).Decode()
+
+		// Then they should come back as the decoded type
+		if err != nil || got != want {
+			t.Errorf("%#v came back as %#v, %v", v, got, err)
+		}
+	}
+	b, _ := Marshal([]string{"a", "b"})
+	if got, _ := NewDecoder(bytes.NewReader(b)).Decode(); !reflect.DeepEqual(got, []any{"a", "b"}) {
+		t.Errorf("[]string came back as %#v", got)
+	}
+}
+
+func TestDecodesNeovimHandles(t *testing.T) {
+	// A buffer handle, as Neovim sends it: fixext 1 of type 0 holding 5.
+	v, err := NewDecoder(bytes.NewReader([]byte{0xd4, 0x00, 0x05})).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := v.(Ext).Int(); err != nil || n != 5 {
+		t.Errorf("got %d, %v, expected 5", n, err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	d := NewDecoder(bytes.NewReader(nil))
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+	d = NewDecoder(bytes.NewReader([]byte{0x92, 0x01}))
+	if _, err := d.Decode(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF in a value, got %v", err)
+	}
+	d = NewDecoder(bytes.NewReader([]byte{0x81, 0x01, 0x01}))
+	if _, err := d.Decode(); !errors.Is(err, FormatError) {
+		t.Errorf("expected FormatError for an integer key, got %v", err)
+	}
+	if _, err := Marshal(struct{}{}); !errors.Is(err, UnsupportedTypeError) {
+		t.Errorf("expected UnsupportedTypeError, got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package ot
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// refineLimit is the most characters a replaced block of lines may hold, old
+// and new together, for Diff to compare it character by character.
+const refineLimit = 1 << 13
+
+// Diff returns the operations that turn a into b, to be applied in order. It
+// finds the fewest lines to delete and insert with Myers' algorithm, then
+// compares each block of replaced lines the same way character by character,
+// so that changing a word doesn't replace its whole line. Blocks too large to
+// compare quickly are replaced whole. Operations never split a UTF-8
+// sequence.
+func Diff(a, b string) []Operation {
+	al, bl := lines(a), lines(b)
+	ids := make(map[string]int)
+	ai, bi := intern(al, ids), intern(bl, ids)
+
+	var s script
+	i, j := 0, 0
+	for _, m := range matches(len(ai), len(bi), func(x, y int) bool { return ai[x] == bi[y] }) {
+		s.replace(strings.Join(al[i:m.a], ""), strings.Join(bl[j:m.b], ""))
+		s.keep(len(strings.Join(al[m.a:m.a+m.n], "")))
+		i, j = m.a+m.n, m.b+m.n
+	}
+	s.replace(strings.Join(al[i:], ""), strings.Join(bl[j:], ""))
+	return s.ops
+}
+
+// lines splits s after each newline.
+func lines(s string) []string {
+	return strings.SplitAfter(s, "\n")
+}
+
+// intern numbers each distinct line, so that lines compare as integers.
+func intern(lines []string, ids map[string]int) []int {
+	n := make([]int, len(lines))
+	for i, l := range lines {
+		id, ok := ids[l]
+		if !ok {
+			id = len(ids)
+			ids[l] = id
+		}
+		n[i] = id
+	}
+	return n
+}
+
+// A script collects the operations that turn one text into another as the
+// two are walked from start to end.
+type script struct {
+	ops []Operation
+	pos uint
+}
+
+// keep moves past n bytes the texts share.
+func (s *script) keep(n int) {
+	s.pos += uint(n)
+}
+
+func (s *script) delete(n int) {
+	if n == 0 {
+		return
+	}
+	if i := len(s.ops) - 1; i >= 0 {
+		if d, ok := s.ops[i].(Deletion); ok && d.Pos == s.pos {
+			d.Len += uint(n)
+			s.ops[i] = d
+			return
+		}
+	}
+	s.ops = append(s.ops, Deletion{Pos: s.pos, Len: uint(n)})
+}
+
+func (s *script) insert(text string) {
+	if text == "" {
+		return
+	}
+	if i := len(s.ops) - 1; i >= 0 {
+		if ins, ok := s.ops[i].(Insertion); ok && ins.Pos+uint(len(ins.Text)) == s.pos {
+			ins.Text += text
+			s.ops[i] = ins
+			s.pos += uint(len(text))
+			return
+		}
+	}
+	s.ops = append(s.ops, Insertion{Pos: s.pos, Text: text})
+	s.pos += uint(len(text))
+}
+
+// replace replaces old, the text at the current position, with new,
+// character by character if they are small enough.
+func (s *script) replace(old, new string) {
+	if old == "" || new == "" || utf8.RuneCountInString(old)+utf8.RuneCountInString(new) > refineLimit {
+		s.delete(len(old))
+		s.insert(new)
+		return
+	}
+	ar, br := []rune(old), []rune(new)
+	i, j := 0, 0
+	for _, m := range matches(len(ar), len(br), func(x, y int) bool { return ar[x] == br[y] }) {
+		s.delete(len(string(ar[i:m.a])))
+		s.insert(string(br[j:m.b]))
+		s.keep(len(string(ar[m.a : m.a+m.n])))
+		i, j = m.a+m.n, m.b+m.n
+	}
+	s.delete(len(string(ar[i:])))
+	s.insert(string(br[j:]))
+}
+
+// A match is a run of n elements that two sequences share, starting at a in
+// the first and b in the second.
+type match struct {
+	a, b, n int
+}
+
+// matches returns the runs that two sequences, of lengths n and m, share in a
+// longest common subsequence, in order. eq reports whether element i of the
+// first equals element j of the second. It uses the linear space variant of
+// Myers' algorithm, which splits the sequences where the shortest edit script
+// from either end meets and compares each half in turn.
+func matches(n, m int, eq func(i, j int) bool) []match {
+	d := differ{eq: eq}
+	d.compare(0, n, 0, m)
+	return d.matches
+}
+
+type differ struct {
+	eq      func(i, j int) bool
+	matches []match
+
+	// vf and vb hold the furthest reaching paths on each diagonal, forwards
+	// and backwards, reused by every split.
+	vf, vb []int
+}
+
+// add records that a run of n elements is shared, joining it to the last if
+// they touch.
+func (d *differ) add(a, b, n int) {
+	if n == 0 {
+		return
+	}
+	if i := len(d.matches) - 1; i >= 0 {
+		if last := &d.matches[i]; last.a+last.n == a && last.b+last.n == b {
+			last.n += n
+			return
+		}
+	}
+	d.matches = append(d.matches, match{a, b, n})
+}
+
+// compare finds the runs shared by elements a0 to a1 of the first sequence
+// and b0 to b1 of the second.
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	p := 0
+	for a0+p < a1 && b0+p < b1 && d.eq(a0+p, b0+p) {
+		p++
+	}
+	d.add(a0, b0, p)
+	a0, b0 = a0+p, b0+p
+	s := 0
+	for a0 < a1-s && b0 < b1-s && d.eq(a1-s-1, b1-s-1) {
+		s++
+	}
+	a1, b1 = a1-s, b1-s
+
+	if a0 < a1 && b0 < b1 {
+		x, y, ok := d.split(a0, a1, b0, b1)
+		if ok {
+			d.compare(a0, x, b0, y)
+			d.compare(x, a1, y, b1)
+		}
+	}
+	d.add(a1, b1, s)
+}
+
+// split returns where the forward and backward paths of a shortest edit
+// script between the two ranges meet. The ranges must differ at either end.
+// It reports false if the paths don't meet inside the ranges, in which case
+// they share nothing worth finding.
+func (d *differ) split(a0, a1, b0, b1 int) (x, y int, ok bool) {
+	n, m := a1-a0, b1-b0
+	maxD := (n + m + 1) / 2
+	off := maxD + 1
+	if size := 2*maxD + 3; len(d.vf) < size {
+		d.vf, d.vb = make([]int, size), make([]int, size)
+	}
+	vf, vb := d.vf[:2*maxD+3], d.vb[:2*maxD+3]
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[off+1], vb[off+1] = 0, 0
+	delta := n - m
+	front := delta%2 != 0
+
+	// Diagonals that leave the ranges are trimmed from either end.
+	var fStart, fEnd, bStart, bEnd int
+	for D := 0; D <= maxD; D++ {
+		for k := -D + fStart; k <= D-fEnd; k += 2 {
+			var x int
+			if k == -D || k != D && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.eq(a0+x, b0+y) {
+				x, y = x+1, y+1
+			}
+			vf[off+k] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case front:
+				if c := off + delta - k; c >= 0 && c < len(vb) && vb[c] != -1 && x >= n-vb[c] {
+					return d.splitAt(a0, a1, b0, b1, x, y)
+				}
+			}
+		}
+		for k := -D + bStart; k <= D-bEnd; k += 2 {
+			var x int
+			if k == -D || k != D && vb[off+k-1] < vb[off+k+1] {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.eq(a1-x-1, b1-y-1) {
+				x, y = x+1, y+1
+			}
+			vb[off+k] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !front:
+				if c := off + delta - k; c >= 0 && c < len(vf) && vf[c] != -1 && vf[c] >= n-x {
+					fx := vf[c]
+					return d.splitAt(a0, a1, b0, b1, fx, fx-(delta-k))
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// splitAt returns the point x, y of the ranges as absolute positions, unless
+// splitting there wouldn't divide them.
+func (d *differ) splitAt(a0, a1, b0, b1, x, y int) (int, int, bool) {
+	x, y = a0+x, b0+y
+	if x == a0 && y == b0 || x == a1 && y == b1 {
+		return 0, 0, false
+	}
+	return x, y, true
+}
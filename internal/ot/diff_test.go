@@ -0,0 +1,131 @@
+package ot_test
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// apply applies ops to text in turn, failing the test if one doesn't fit.
+func apply(t *testing.T, text string, ops []ot.Operation) string {
+	t.Helper()
+	for _, op := range ops {
+		if err := ot.Validate(op, text); err != nil {
+			t.Fatalf("%v doesn't apply to %q: %s", op, text, err)
+		}
+		text = op.Apply(text)
+	}
+	return text
+}
+
+// edits counts the characters ops delete and insert.
+func edits(ops []ot.Operation) int {
+	n := 0
+	for _, op := range ops {
+		switch op := op.(type) {
+		case ot.Insertion:
+			n += len(op.Text)
+		case ot.Deletion:
+			n += int(op.Len)
+		}
+	}
+	return n
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range len(a) {
+		for j := range len(b) {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		a, b string
+		ops  []ot.Operation
+	}{
+		{a: "", b: "", ops: nil},
+		{a: "hello", b: "hello", ops: nil},
+		{a: "", b: "hello", ops: []ot.Operation{ot.Insertion{Pos: 0, Text: "hello"}}},
+		{a: "hello world", b: "hello", ops: []ot.Operation{ot.Deletion{Pos: 5, Len: 6}}},
+		{a: "the cat sat", b: "the cot sat", ops: []ot.Operation{ot.Deletion{Pos: 5, Len: 1}, ot.Insertion{Pos: 5, Text: "o"}}},
+		{a: "naïve", b: "naöve", ops: []ot.Operation{ot.Deletion{Pos: 2, Len: 2}, ot.Insertion{Pos: 2, Text: "ö"}}},
+		{
+			a:   "one\ntwo\nthree\nfour\n",
+			b:   "one\nthree\nfour\nfive\n",
+			ops: []ot.Operation{ot.Deletion{Pos: 4, Len: 4}, ot.Insertion{Pos: 15, Text: "five\n"}},
+		},
+		{
+			a:   "func f() {\n\treturn 1\n}\n",
+			b:   "func f() {\n\treturn 2\n}\n",
+			ops: []ot.Operation{ot.Deletion{Pos: 19, Len: 1}, ot.Insertion{Pos: 19, Text: "2"}},
+		},
+	}
+
+	for _, c := range cases {
+		ops := ot.Diff(c.a, c.b)
+		if got := apply(t, c.a, ops); got != c.b {
+			t.Errorf("Diff(%q, %q) gives %q", c.a, c.b, got)
+		}
+		if !slices.Equal(ops, c.ops) {
+			t.Errorf("Diff(%q, %q) returned %v, expected %v", c.a, c.b, ops, c.ops)
+		}
+	}
+}
+
+func TestDiffIsMinimalWithinLines(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	word := func() string {
+		b := make([]byte, r.IntN(30))
+		for i := range b {
+			b[i] = "abc"[r.IntN(3)]
+		}
+		return string(b)
+	}
+	for range 500 {
+		a, b := word(), word()
+		ops := ot.Diff(a, b)
+		if got := apply(t, a, ops); got != b {
+			t.Fatalf("Diff(%q, %q) gives %q", a, b, got)
+		}
+		if got, want := edits(ops), len(a)+len(b)-2*lcs(a, b); got != want {
+			t.Errorf("Diff(%q, %q) returned %v, editing %d characters rather than %d", a, b, ops, got, want)
+		}
+	}
+}
+
+func TestDiffTransformsAnyText(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	text := func() string {
+		var b strings.Builder
+		for range r.IntN(60) {
+			b.WriteString([]string{"a", "b", "\n", "é", "語"}[r.IntN(5)])
+		}
+		return b.String()
+	}
+	for range 500 {
+		a, b := text(), text()
+		ops := ot.Diff(a, b)
+		if got := apply(t, a, ops); got != b {
+			t.Fatalf("Diff(%q, %q) gives %q", a, b, got)
+		}
+		for _, op := range ops {
+			if ins, ok := op.(ot.Insertion); ok && !utf8.ValidString(ins.Text) {
+				t.Errorf("Diff(%q, %q) inserts %q, which splits a character", a, b, ins.Text)
+			}
+		}
+	}
+}
@@ -1,6 +1,14 @@
 package ot
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// OutOfRangeError is returned for operations that reach past the end of the
+// buffer they are applied to.
+var OutOfRangeError = errors.New("operation out of range")
 
 // An Operation is an atomic edit that can be applied to a buffer.
 type Operation interface {
@@ -24,6 +32,39 @@ type Deletion struct {
 	Len uint `json:"len"`
 }
 
+// Validate returns an error if op cannot be applied to buf.
+func Validate(op Operation, buf string) error {
+	switch op := op.(type) {
+	case Insertion:
+		if op.Pos > uint(len(buf)) {
+			return OutOfRangeError
+		}
+	case Deletion:
+		if op.Pos > uint(len(buf)) || op.Len > uint(len(buf))-op.Pos {
+			return OutOfRangeError
+		}
+	default:
+		panic("unhandled operation type")
+	}
+	return nil
+}
+
+// Clamp returns op trimmed to fit within a buffer of length n, so that it can
+// be applied even if it was made against a different version of the buffer.
+func Clamp(op Operation, n uint) Operation {
+	switch op := op.(type) {
+	case Insertion:
+		op.Pos = min(op.Pos, n)
+		return op
+	case Deletion:
+		op.Pos = min(op.Pos, n)
+		op.Len = min(op.Len, n-op.Pos)
+		return op
+	default:
+		panic("unhandled operation type")
+	}
+}
+
 func (op Insertion) Apply(buf string) string {
 	return buf[:op.Pos] + op.Text + buf[op.Pos:]
 }
@@ -111,3 +152,26 @@ func (op Deletion) MarshalJSON() ([]byte, error) {
 		deletion(op), "deletion",
 	})
 }
+
+// Unmarshal decodes an operation encoded as JSON by its MarshalJSON method.
+func Unmarshal(data []byte) (Operation, error) {
+	var w struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+
+	switch w.Type {
+	case "insertion":
+		var op Insertion
+		err := json.Unmarshal(data, &op)
+		return op, err
+	case "deletion":
+		var op Deletion
+		err := json.Unmarshal(data, &op)
+		return op, err
+	default:
+		return nil, fmt.Errorf("unrecognized operation type: %q", w.Type)
+	}
+}
@@ -209,3 +209,47 @@ func FuzzDeleteDeleteCommutativity(f *testing.F) {
 		}
 	})
 }
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		op ot.Operation
+		ok bool
+	}{
+		{ot.Insertion{Pos: 0, Text: "a"}, true},
+		{ot.Insertion{Pos: 5, Text: "a"}, true},
+		{ot.Insertion{Pos: 6, Text: "a"}, false},
+		{ot.Deletion{Pos: 0, Len: 5}, true},
+		{ot.Deletion{Pos: 5, Len: 0}, true},
+		{ot.Deletion{Pos: 3, Len: 3}, false},
+		{ot.Deletion{Pos: 6, Len: 0}, false},
+		{ot.Deletion{Pos: 1, Len: ^uint(0)}, false},
+	}
+
+	for _, c := range cases {
+		if err := ot.Validate(c.op, "hello"); (err == nil) != c.ok {
+			t.Errorf("Validate(%#v, %q) returned %v", c.op, "hello", err)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	cases := []struct {
+		op, want ot.Operation
+	}{
+		{ot.Insertion{Pos: 2, Text: "a"}, ot.Insertion{Pos: 2, Text: "a"}},
+		{ot.Insertion{Pos: 9, Text: "a"}, ot.Insertion{Pos: 5, Text: "a"}},
+		{ot.Deletion{Pos: 1, Len: 2}, ot.Deletion{Pos: 1, Len: 2}},
+		{ot.Deletion{Pos: 3, Len: 9}, ot.Deletion{Pos: 3, Len: 2}},
+		{ot.Deletion{Pos: 9, Len: 1}, ot.Deletion{Pos: 5, Len: 0}},
+	}
+
+	for _, c := range cases {
+		got := ot.Clamp(c.op, 5)
+		if got != c.want {
+			t.Errorf("Clamp(%#v, 5) = %#v, expected %#v", c.op, got, c.want)
+		}
+		if err := ot.Validate(got, "hello"); err != nil {
+			t.Errorf("Clamp(%#v, 5) is still invalid", c.op)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package ot
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	MalformedPatchError = errors.New("malformed patch")
+	PatchConflictError  = errors.New("patch does not apply")
+)
+
+// A hunk is one change in a unified diff: the lines it expects, starting at
+// line start, and the lines that replace them.
+type hunk struct {
+	start    int
+	old, new []string
+}
+
+// Patch returns the operations that apply a unified diff of one file, as
+// written by diff -u or git diff, to text. Lines outside hunks, such as file
+// headers, are ignored. A hunk whose lines aren't where it says is applied at
+// the nearest place they are found after the previous hunk, and the patch
+// fails with PatchConflictError if they aren't found at all.
+func Patch(text, patch string) ([]Operation, error) {
+	hunks, err := parsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	ls := lines(text)
+	if ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	var out []string
+	next, shift := 0, 0
+	for n, h := range hunks {
+		at := find(ls, h.old, next, h.start+shift)
+		if at < 0 {
+			return nil, fmt.Errorf("hunk %d: %w", n+1, PatchConflictError)
+		}
+		out = append(append(out, ls[next:at]...), h.new...)
+		next = at + len(h.old)
+		shift = at - h.start + len(h.new) - len(h.old)
+	}
+	out = append(out, ls[next:]...)
+	return Diff(text, strings.Join(out, "")), nil
+}
+
+// find returns where lines first appear in text at or after from, nearest to
+// want, or -1 if they don't.
+func find(text, lines []string, from, want int) int {
+	// want comes from the patch, and may be nowhere near the text.
+	want = min(max(want, from), len(text))
+	fits := func(at int) bool {
+		return at >= from && at+len(lines) <= len(text) && slices.Equal(text[at:at+len(lines)], lines)
+	}
+	for d := 0; want-d >= from || want+d+len(lines) <= len(text); d++ {
+		if fits(want - d) {
+			return want - d
+		}
+		if fits(want + d) {
+			return want + d
+		}
+	}
+	return -1
+}
+
+// parsePatch reads the hunks of a unified diff, with each line ending in a
+// newline unless the diff says it has none.
+func parsePatch(patch string) ([]hunk, error) {
+	var hunks []hunk
+	ls := lines(patch)
+	if ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	for i := 0; i < len(ls); {
+		header, ok := strings.CutPrefix(ls[i], "@@ -")
+		i++
+		if !ok {
+			continue
+		}
+		oldStart, oldLen, newLen, ok := parseHeader(header)
+		if !ok {
+			return nil, fmt.Errorf("%w: bad hunk header on line %d", MalformedPatchError, i)
+		}
+		h := hunk{start: max(oldStart-1, 0)}
+		if oldLen == 0 {
+			// Hunks that only add lines name the line they follow.
+			h.start = oldStart
+		}
+		var last *string
+		for len(h.old) < oldLen || len(h.new) < newLen || i < len(ls) && strings.HasPrefix(ls[i], `\`) {
+			if i == len(ls) {
+				return nil, fmt.Errorf("%w: hunk %d is cut short", MalformedPatchError, len(hunks)+1)
+			}
+			l := ls[i]
+			i++
+			if l == "\n" {
+				// Some tools strip the space from blank context lines.
+				l = " \n"
+			}
+			switch l[0] {
+			case ' ':
+				h.old = append(h.old, l[1:])
+				h.new = append(h.new, l[1:])
+				last = nil
+			case '-':
+				h.old = append(h.old, l[1:])
+				last = &h.old[len(h.old)-1]
+			case '+':
+				h.new = append(h.new, l[1:])
+				last = &h.new[len(h.new)-1]
+			case '\\':
+				// "\ No newline at end of file" applies to the line before.
+				if last != nil {
+					*last = strings.TrimSuffix(*last, "\n")
+				} else if len(h.old) > 0 {
+					h.old[len(h.old)-1] = strings.TrimSuffix(h.old[len(h.old)-1], "\n")
+					h.new[len(h.new)-1] = strings.TrimSuffix(h.new[len(h.new)-1], "\n")
+				}
+			default:
+				return nil, fmt.Errorf("%w: unexpected line %d", MalformedPatchError, i)
+			}
+			if len(h.old) > oldLen || len(h.new) > newLen {
+				return nil, fmt.Errorf("%w: hunk %d is longer than its header says", MalformedPatchError, len(hunks)+1)
+			}
+		}
+		hunks = append(hunks, h)
+	}
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("%w: no hunks", MalformedPatchError)
+	}
+	return hunks, nil
+}
+
+// parseHeader reads the rest of a hunk header after "@@ -", as in
+// "12,3 +12,4 @@".
+func parseHeader(s string) (oldStart, oldLen, newLen int, ok bool) {
+	old, rest, ok1 := strings.Cut(s, " +")
+	new, _, ok2 := strings.Cut(rest, " @@")
+	if !ok1 || !ok2 {
+		return 0, 0, 0, false
+	}
+	oldStart, oldLen, ok1 = parseRange(old)
+	_, newLen, ok2 = parseRange(new)
+	return oldStart, oldLen, newLen, ok1 && ok2
+}
+
+// parseRange reads a hunk's range of lines, as in "12,3", or "12" for one line.
+func parseRange(s string) (start, n int, ok bool) {
+	first, count, found := strings.Cut(s, ",")
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	n = 1
+	if found {
+		if n, err = strconv.Atoi(count); err != nil || n < 0 {
+			return 0, 0, false
+		}
+	}
+	return start, n, true
+}
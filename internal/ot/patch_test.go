@@ -0,0 +1,127 @@
+package ot_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+const before = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+`
+
+const patch = `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -3,5 +3,6 @@ package main
+ import "fmt"
+ 
+ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hello,")
++	fmt.Println("world")
+ }
+`
+
+const after = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello,")
+	fmt.Println("world")
+}
+`
+
+func TestPatch(t *testing.T) {
+	cases := []struct {
+		name, text, patch, want string
+	}{
+		{name: "in place", text: before, patch: patch, want: after},
+		{name: "moved down", text: "// Command main greets.\n" + before, patch: patch, want: "// Command main greets.\n" + after},
+		{name: "moved up", text: before[len("package main\n"):], patch: patch, want: after[len("package main\n"):]},
+		{
+			name:  "without final newline",
+			text:  "one\ntwo",
+			patch: "@@ -1,2 +1,2 @@\n one\n-two\n\\ No newline at end of file\n+three\n",
+			want:  "one\nthree\n",
+		},
+		{
+			name:  "adding lines",
+			text:  "one\nthree\n",
+			patch: "@@ -1,0 +2 @@\n+two\n",
+			want:  "one\ntwo\nthree\n",
+		},
+		{
+			name:  "far out of range",
+			text:  "one\ntwo\n",
+			patch: "@@ -999999999999,1 +999999999999,1 @@\n-two\n+three\n",
+			want:  "one\nthree\n",
+		},
+		{
+			name:  "new file",
+			text:  "",
+			patch: "--- /dev/null\n+++ b/new\n@@ -0,0 +1,2 @@\n+hello\n+world\n",
+			want:  "hello\nworld\n",
+		},
+	}
+
+	for _, c := range cases {
+		ops, err := ot.Patch(c.text, c.patch)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if got := apply(t, c.text, ops); got != c.want {
+			t.Errorf("%s: got %q, expected %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPatchRejectsConflicts(t *testing.T) {
+	// Given the lines a hunk removes have since changed
+	text := strings.Replace(before, `"hello"`, `"hi"`, 1)
+
+	// When the patch is applied
+	_, err := ot.Patch(text, patch)
+
+	// Then it should be refused
+	if !errors.Is(err, ot.PatchConflictError) {
+		t.Errorf("Patch returned %v, expected PatchConflictError", err)
+	}
+}
+
+func TestPatchRejectsHunksOutOfRange(t *testing.T) {
+	// Given a hunk that claims to start far past the end of the text
+	patch := "@@ -999999999999,1 +999999999999,1 @@\n-three\n+four\n"
+
+	// When its lines are nowhere in the text
+	_, err := ot.Patch("one\ntwo\n", patch)
+
+	// Then it should be refused, without searching all the way there
+	if !errors.Is(err, ot.PatchConflictError) {
+		t.Errorf("Patch returned %v, expected PatchConflictError", err)
+	}
+}
+
+func TestPatchRejectsMalformedPatches(t *testing.T) {
+	for _, patch := range []string{
+		"",
+		"not a patch\n",
+		"@@ -1,2 +1,2 @@\n one\n",
+		"@@ -1,2 +1 @@\n one\n two\n",
+		"@@ -x +1 @@\n",
+		"@@ -1 +1 @@\n*one\n",
+	} {
+		if _, err := ot.Patch("one\ntwo\n", patch); !errors.Is(err, ot.MalformedPatchError) {
+			t.Errorf("Patch(%q) returned %v, expected MalformedPatchError", patch, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+var (
+	NoSuchDocumentError = errors.New("no such document")
+	NoSuchSessionError  = errors.New("no such session")
+	NoSnapshotsError    = errors.New("the server's store can't take snapshots")
+)
+
+// DocumentInfo describes a document known to a server.
+type DocumentInfo struct {
+	Name string `json:"name"`
+
+	// Loaded is false for documents that are saved but haven't been opened
+	// since the server started, whose other fields are unknown.
+	Loaded   bool `json:"loaded"`
+	Rev      int  `json:"rev"`
+	Size     int  `json:"size"`
+	Sessions int  `json:"sessions"`
+	Locked   bool `json:"locked"`
+}
+
+// SessionInfo describes a connected session.
+type SessionInfo struct {
+	Id       int        `json:"id"`
+	Document string     `json:"document"`
+	User     string     `json:"user,omitempty"`
+	Name     string     `json:"name,omitempty"`
+	Role     comms.Role `json:"role"`
+	Addr     string     `json:"addr,omitempty"`
+}
+
+// Documents lists the documents the server has open or saved, ordered by
+// name.
+func (s *Server) Documents() ([]DocumentInfo, error) {
+	var saved []string
+	if s.Store != nil {
+		var err error
+		if saved, err = s.Store.Documents(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make(map[string]*DocumentInfo)
+	for _, name := range saved {
+		infos[name] = &DocumentInfo{Name: name}
+	}
+	for name, d := range s.documents {
+		if !s.open(name) {
+			continue
+		}
+		infos[name] = &DocumentInfo{
+			Name:   name,
+			Loaded: true,
+			Rev:    d.rev,
+			Size:   len(d.text),
+			Locked: d.locked,
+		}
+	}
+	for _, sess := range s.sessions {
+		infos[sess.document].Sessions++
+	}
+
+	docs := make([]DocumentInfo, 0, len(infos))
+	for _, info := range infos {
+		docs = append(docs, *info)
+	}
+	slices.SortFunc(docs, func(a, b DocumentInfo) int { return strings.Compare(a.Name, b.Name) })
+	return docs, nil
+}
+
+// Sessions lists the connected sessions, ordered by ID.
+func (s *Server) Sessions() []SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]SessionInfo, 0, len(s.sessions))
+	for id, sess := range s.sessions {
+		info := SessionInfo{
+			Id:       id,
+			Document: sess.document,
+			User:     sess.identity.Name,
+			Role:     sess.role,
+			Addr:     sess.addr,
+		}
+		if sess.participant != nil {
+			info.Name = sess.participant.Name
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b SessionInfo) int { return a.Id - b.Id })
+	return infos
+}
+
+// Text returns the server's copy of a document, loading it if need be.
+func (s *Server) Text(name string) (comms.Document, error) {
+	if err := s.exists(name); err != nil {
+		return comms.Document{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(name); err != nil {
+		return comms.Document{}, err
+	}
+	d := s.document(name)
+	return comms.Document{Text: d.text, Rev: d.rev}, nil
+}
+
+// Kick disconnects a session, telling it why.
+func (s *Server) Kick(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return NoSuchSessionError
+	}
+	s.logger().Info("kicking session", "session", id)
+	s.kick(id, sess, "removed by an administrator")
+	return nil
+}
+
+// Snapshot saves a document's text in the store, so that it loads quickly
+// when the server restarts.
+func (s *Server) Snapshot(name string) error {
+	snap, ok := s.Store.(store.Snapshotter)
+	if !ok {
+		return NoSnapshotsError
+	}
+	doc, err := s.Text(name)
+	if err != nil {
+		return err
+	}
+	return snap.Snapshot(name, doc.Text, doc.Rev)
+}
+
+// Lock makes a document read-only for everyone, or writable again. Locks
+// last until the server stops.
+func (s *Server) Lock(name string, locked bool) error {
+	if err := s.exists(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.document(name).locked = locked
+	s.logger().Info("locking document", "document", name, "locked", locked)
+	return nil
+}
+
+// Delete disconnects everyone editing a document and forgets it.
+func (s *Server) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.drop(name, nil); err != nil {
+		return err
+	}
+	s.logger().Info("deleted document", "document", name)
+	return nil
+}
+
+// Rename gives a document a new name, disconnecting everyone editing it so
+// that they reopen it under the new one. Roles granted by owners are dropped,
+// and the ACL for the new name applies.
+func (s *Server) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.move(from, to, nil); err != nil {
+		return err
+	}
+	s.logger().Info("renamed document", "document", from, "to", to)
+	return nil
+}
+
+// drop deletes a document and tells everyone listing documents, other than
+// the session by that deleted it, if any. The caller must hold s.mu.
+func (s *Server) drop(name string, by *session) error {
+	if err := s.known(name); err != nil {
+		return err
+	}
+	if s.Store != nil {
+		if err := s.Store.Delete(name); err != nil {
+			return err
+		}
+	}
+	s.kickAll(name, "the document was deleted")
+	delete(s.documents, name)
+	s.announce(by, comms.DocumentEvent{Action: comms.DELETED, Name: name})
+	return nil
+}
+
+// move renames a document, like drop. The caller must hold s.mu.
+func (s *Server) move(from, to string, by *session) error {
+	if err := s.known(from); err != nil {
+		return err
+	}
+	if s.known(to) == nil {
+		return store.ExistsError
+	}
+	if err := s.load(from); err != nil {
+		return err
+	}
+	if s.Store != nil {
+		if err := s.Store.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	s.kickAll(from, fmt.Sprintf("the document was renamed to %q", to))
+	old := s.document(from)
+	delete(s.documents, from)
+	d := s.document(to)
+	d.text, d.rev, d.history, d.authors = old.text, old.rev, old.history, old.authors
+	d.checkpoints = old.checkpoints
+	d.loaded, d.locked = true, old.locked
+	s.announce(by, comms.DocumentEvent{Action: comms.RENAMED, Name: from, To: to})
+	return nil
+}
+
+// exists returns NoSuchDocumentError unless a document is open or saved.
+func (s *Server) exists(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.known(name)
+}
+
+// known is exists for callers that hold s.mu.
+func (s *Server) known(name string) error {
+	if s.open(name) {
+		return nil
+	}
+	if s.Store != nil {
+		saved, err := s.Store.Documents()
+		if err != nil {
+			return err
+		}
+		if slices.Contains(saved, name) {
+			return nil
+		}
+	}
+	return NoSuchDocumentError
+}
+
+// open reports whether a document has been loaded, changed or is being
+// edited. The caller must hold s.mu.
+func (s *Server) open(name string) bool {
+	if d, ok := s.documents[name]; ok && (d.loaded || d.rev > 0) {
+		return true
+	}
+	for _, sess := range s.sessions {
+		if sess.document == name {
+			return true
+		}
+	}
+	return false
+}
+
+// kickAll disconnects everyone editing a document. The caller must hold s.mu.
+func (s *Server) kickAll(doc, reason string) {
+	for id, sess := range s.sessions {
+		if sess.document == doc {
+			s.kick(id, sess, reason)
+		}
+	}
+}
+
+// kick disconnects a session after telling it why. The caller must hold s.mu.
+func (s *Server) kick(id int, sess *session, reason string) {
+	s.remove(id, sess)
+	// Once removed from s.sessions, nothing else sends to the session, so
+	// its writer can be told to finish without holding s.mu.
+	go func() {
+		sess.in <- comms.ErrorMessage{Code: comms.KICKED, Message: reason}
+		close(sess.in)
+	}()
+}
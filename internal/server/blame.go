@@ -0,0 +1,102 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+// A run is a stretch of a document written by one author in one change.
+type run struct {
+	len    int
+	author string
+	rev    int
+}
+
+// An attribution records who wrote each byte of a document, as the runs that
+// cover it in order.
+type attribution []run
+
+// attribute returns the attribution of the document made by changes.
+func attribute(changes []store.Change) attribution {
+	var a attribution
+	n := 0
+	for i, c := range changes {
+		op := ot.Clamp(c.Op, uint(n))
+		a.apply(op, c.Author, i+1)
+		switch op := op.(type) {
+		case ot.Insertion:
+			n += len(op.Text)
+		case ot.Deletion:
+			n -= int(op.Len)
+		}
+	}
+	return a
+}
+
+// apply updates a for op, made by author in the change that took the document
+// to revision rev. op must fit the document.
+func (a *attribution) apply(op ot.Operation, author string, rev int) {
+	switch op := op.(type) {
+	case ot.Insertion:
+		if op.Text != "" {
+			i := a.split(int(op.Pos))
+			*a = slices.Insert(*a, i, run{len: len(op.Text), author: author, rev: rev})
+		}
+	case ot.Deletion:
+		if op.Len > 0 {
+			i := a.split(int(op.Pos))
+			j := a.split(int(op.Pos + op.Len))
+			*a = slices.Delete(*a, i, j)
+		}
+	}
+}
+
+// split divides the run that spans pos, if any, so that one starts there, and
+// returns its index.
+func (a *attribution) split(pos int) int {
+	off := 0
+	for i, r := range *a {
+		if off == pos {
+			return i
+		}
+		if pos < off+r.len {
+			left, right := r, r
+			left.len, right.len = pos-off, r.len-(pos-off)
+			(*a)[i] = left
+			*a = slices.Insert(*a, i+1, right)
+			return i + 1
+		}
+		off += r.len
+	}
+	return len(*a)
+}
+
+// spans lists the runs of a with their positions, joining neighbours from the
+// same change.
+func (a attribution) spans() []comms.Span {
+	spans := make([]comms.Span, 0, len(a))
+	pos := 0
+	for _, r := range a {
+		if n := len(spans); n > 0 && spans[n-1].Rev == r.rev && spans[n-1].Author == r.author {
+			spans[n-1].Len += r.len
+		} else {
+			spans = append(spans, comms.Span{Pos: pos, Len: r.len, Author: r.author, Rev: r.rev})
+		}
+		pos += r.len
+	}
+	return spans
+}
+
+// blame sends a session who wrote each part of its document. The caller must
+// hold s.mu.
+func (s *Server) blame(id int) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	sess.in <- comms.Blame{Text: d.text, Rev: d.rev, Spans: d.authors.spans()}
+}
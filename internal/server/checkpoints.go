@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+// checkpoint names a revision of a session's document and sends the session
+// the document's checkpoints. The caller must hold s.mu.
+func (s *Server) checkpoint(id int, msg comms.CreateCheckpoint) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	if !sess.role.CanEdit() {
+		sess.in <- comms.ErrorMessage{Code: comms.FORBIDDEN, Message: "viewers cannot create checkpoints"}
+		return
+	}
+	if msg.Name == "" {
+		sess.in <- comms.ErrorMessage{Code: comms.INVALID, Message: "checkpoints need a name"}
+		return
+	}
+	rev := d.rev
+	if msg.Rev != nil {
+		rev = *msg.Rev
+	}
+	if rev < 0 || rev > d.rev {
+		sess.in <- noSuchRevision(rev)
+		return
+	}
+
+	checkpoints := slices.DeleteFunc(slices.Clone(d.checkpoints), func(c store.Checkpoint) bool {
+		return c.Name == msg.Name
+	})
+	checkpoints = append(checkpoints, store.Checkpoint{Name: msg.Name, Rev: rev, Author: sess.author(), Time: time.Now()})
+	if cp, ok := s.Store.(store.Checkpointer); ok {
+		if err := cp.SaveCheckpoints(sess.document, checkpoints); err != nil {
+			sess.log.Error("saving checkpoints", "error", err)
+			sess.in <- comms.ErrorMessage{Code: comms.INTERNAL, Message: "could not save the checkpoint"}
+			return
+		}
+	}
+	d.checkpoints = checkpoints
+	sess.log.Info("created checkpoint", "name", msg.Name, "rev", rev)
+	s.checkpoints(id)
+}
+
+// checkpoints sends a session the checkpoints of its document. The caller must
+// hold s.mu.
+func (s *Server) checkpoints(id int) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	cs := make([]comms.Checkpoint, 0, len(d.checkpoints))
+	for _, c := range d.checkpoints {
+		cs = append(cs, comms.Checkpoint(c))
+	}
+	sess.in <- comms.Checkpoints{Checkpoints: cs}
+}
+
+// revert restores a session's document to the text of an earlier revision.
+// The changes that restore it are recorded and sent to every session editing
+// the document, the reverting one included, as though it had made them. The
+// caller must hold s.mu.
+func (s *Server) revert(id int, msg comms.Revert) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	if !sess.role.CanEdit() {
+		sess.in <- comms.ErrorMessage{Code: comms.FORBIDDEN, Message: "viewers cannot revert the document"}
+		return
+	}
+	if d.locked {
+		sess.in <- comms.ErrorMessage{Code: comms.READ_ONLY, Message: "the document is locked"}
+		return
+	}
+	rev := msg.Rev
+	if msg.Checkpoint != "" {
+		i := slices.IndexFunc(d.checkpoints, func(c store.Checkpoint) bool {
+			return c.Name == msg.Checkpoint
+		})
+		if i < 0 {
+			sess.in <- comms.ErrorMessage{Code: comms.NO_SUCH_REV, Message: fmt.Sprintf("there is no checkpoint %q", msg.Checkpoint)}
+			return
+		}
+		rev = d.checkpoints[i].Rev
+	}
+	if rev < 0 || rev > d.rev {
+		sess.in <- noSuchRevision(rev)
+		return
+	}
+
+	// The changes are checked before any is made, so that the document isn't
+	// left half reverted. Only the store failing can stop them now, and the
+	// changes it saved before that are sent like the rest.
+	ops := ot.Diff(d.text, d.textAt(rev))
+	text := d.text
+	for _, op := range ops {
+		if err := s.fits(text, op); err != nil {
+			sess.in <- err.(comms.ErrorMessage)
+			return
+		}
+		text = op.Apply(text)
+	}
+	for _, op := range ops {
+		if _, err := s.apply(sess, op); err != nil {
+			sess.in <- err.(comms.ErrorMessage)
+			return
+		}
+		change := comms.OpMessage{Op: op, Author: sess.author()}
+		for _, p := range s.sessions {
+			if p.document == sess.document {
+				s.stats.opsBroadcast.Inc()
+				p.in <- change
+			}
+		}
+	}
+	sess.log.Info("reverted document", "to", rev, "rev", d.rev)
+	sess.in <- comms.Reverted{To: rev, Rev: d.rev}
+}
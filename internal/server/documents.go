@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+// listDocuments sends a session the names of the documents under a prefix and
+// has it told of documents created, deleted and renamed under the prefix from
+// then on. The caller must hold s.mu.
+func (s *Server) listDocuments(id int, msg comms.ListDocuments) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	sess.watching, sess.prefix = true, msg.Prefix
+	s.sendDocuments(sess)
+}
+
+// createDocument creates an empty document for a session. The document is
+// kept until the server stops, and in s.Store once it has changes. The
+// caller must hold s.mu.
+func (s *Server) createDocument(id int, msg comms.CreateDocument) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	if msg.Name == "" {
+		sess.in <- comms.ErrorMessage{Code: comms.INVALID, Message: "documents need a name"}
+		return
+	}
+	if err := s.mayChange(sess, msg.Name, comms.EDITOR); err != nil {
+		sess.in <- err.(comms.ErrorMessage)
+		return
+	}
+	switch err := s.known(msg.Name); {
+	case err == nil:
+		sess.in <- documentError(sess, msg.Name, store.ExistsError)
+		return
+	case !errors.Is(err, NoSuchDocumentError):
+		sess.in <- documentError(sess, msg.Name, err)
+		return
+	}
+
+	s.document(msg.Name).loaded = true
+	s.announce(sess, comms.DocumentEvent{Action: comms.CREATED, Name: msg.Name})
+	sess.log.Info("created document", "name", msg.Name)
+	s.sendDocuments(sess)
+}
+
+// deleteDocument deletes a document for a session. The caller must hold s.mu.
+func (s *Server) deleteDocument(id int, msg comms.DeleteDocument) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	if err := s.mayChange(sess, msg.Name, comms.OWNER); err != nil {
+		sess.in <- err.(comms.ErrorMessage)
+		return
+	}
+	if err := s.drop(msg.Name, sess); err != nil {
+		sess.in <- documentError(sess, msg.Name, err)
+		return
+	}
+	sess.log.Info("deleted document", "name", msg.Name)
+	// Sessions deleting their own document have been disconnected.
+	if _, ok := s.sessions[id]; ok {
+		s.sendDocuments(sess)
+	}
+}
+
+// renameDocument renames a document for a session. The caller must hold s.mu.
+func (s *Server) renameDocument(id int, msg comms.RenameDocument) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	if msg.To == "" {
+		sess.in <- comms.ErrorMessage{Code: comms.INVALID, Message: "documents need a name"}
+		return
+	}
+	if err := s.mayChange(sess, msg.From, comms.OWNER); err != nil {
+		sess.in <- err.(comms.ErrorMessage)
+		return
+	}
+	if err := s.mayChange(sess, msg.To, comms.EDITOR); err != nil {
+		sess.in <- err.(comms.ErrorMessage)
+		return
+	}
+	if err := s.move(msg.From, msg.To, sess); err != nil {
+		sess.in <- documentError(sess, msg.From, err)
+		return
+	}
+	sess.log.Info("renamed document", "name", msg.From, "to", msg.To)
+	if _, ok := s.sessions[id]; ok {
+		s.sendDocuments(sess)
+	}
+}
+
+// mayChange returns an error unless sess may create, delete or rename the
+// named document: it must be allowed to open it, as an editor to create it or
+// as an owner to delete or rename it, and the document must not be locked.
+// Errors are comms.ErrorMessages. The caller must hold s.mu.
+func (s *Server) mayChange(sess *session, name string, need comms.Role) error {
+	if !sess.identity.CanAccess(name) {
+		return comms.ErrorMessage{Code: comms.FORBIDDEN, Message: fmt.Sprintf("not allowed to open %q", name)}
+	}
+	role := s.roleOf(sess, name)
+	if name == sess.document {
+		role = sess.role
+	}
+	switch {
+	case !role.CanEdit():
+		return comms.ErrorMessage{Code: comms.FORBIDDEN, Message: fmt.Sprintf("viewers of %q cannot change it", name)}
+	case need == comms.OWNER && role != comms.OWNER:
+		return comms.ErrorMessage{Code: comms.FORBIDDEN, Message: fmt.Sprintf("only owners of %q can delete or rename it", name)}
+	}
+	if d, ok := s.documents[name]; ok && d.locked {
+		return comms.ErrorMessage{Code: comms.FORBIDDEN, Message: fmt.Sprintf("%q is locked", name)}
+	}
+	return nil
+}
+
+// documentError reports why a document couldn't be created, deleted or
+// renamed.
+func documentError(sess *session, name string, err error) comms.ErrorMessage {
+	switch {
+	case errors.Is(err, NoSuchDocumentError):
+		return comms.ErrorMessage{Code: comms.NO_SUCH_DOC, Message: fmt.Sprintf("there is no document %q", name)}
+	case errors.Is(err, store.ExistsError):
+		return comms.ErrorMessage{Code: comms.EXISTS, Message: "the document exists already"}
+	}
+	sess.log.Error("changing documents", "name", name, "error", err)
+	return comms.ErrorMessage{Code: comms.INTERNAL, Message: "could not change the documents"}
+}
+
+// sendDocuments sends a session the names of the documents under the prefix
+// it last listed. The caller must hold s.mu.
+func (s *Server) sendDocuments(sess *session) {
+	names := make([]string, 0)
+	if s.Store != nil {
+		saved, err := s.Store.Documents()
+		if err != nil {
+			sess.log.Error("listing documents", "error", err)
+			sess.in <- comms.ErrorMessage{Code: comms.INTERNAL, Message: "could not list the documents"}
+			return
+		}
+		names = append(names, saved...)
+	}
+	for name := range s.documents {
+		if s.open(name) {
+			names = append(names, name)
+		}
+	}
+	names = slices.DeleteFunc(names, func(name string) bool { return !sess.lists(name) })
+	slices.Sort(names)
+	sess.in <- comms.Documents{Names: slices.Compact(names)}
+}
+
+// announce tells every session listing documents, other than by, of a
+// document that was created, deleted or renamed by by, or by an
+// administrator if by is nil. The caller must hold s.mu.
+func (s *Server) announce(by *session, e comms.DocumentEvent) {
+	if by != nil {
+		e.Author = by.author()
+	}
+	for _, sess := range s.sessions {
+		if sess != by && sess.watching && (sess.lists(e.Name) || e.To != "" && sess.lists(e.To)) {
+			sess.in <- e
+		}
+	}
+}
+
+// lists reports whether the named document is among those sess has listed.
+func (sess *session) lists(name string) bool {
+	return strings.HasPrefix(name, sess.prefix) && sess.identity.CanAccess(name)
+}
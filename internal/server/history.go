@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/store"
+)
+
+// history sends a session the changes made to its document after a revision.
+// The caller must hold s.mu.
+func (s *Server) history(id int, msg comms.FetchHistory) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	if msg.From < 0 || msg.From > d.rev {
+		sess.in <- noSuchRevision(msg.From)
+		return
+	}
+	end := d.rev
+	if msg.Limit > 0 && msg.Limit < end-msg.From {
+		end = msg.From + msg.Limit
+	}
+	sess.in <- comms.History{Changes: changes(d, msg.From, end), Rev: d.rev}
+}
+
+// revision sends a session its document as it was at a revision or time. The
+// caller must hold s.mu.
+func (s *Server) revision(id int, msg comms.FetchRevision) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	rev := msg.Rev
+	if !msg.Time.IsZero() {
+		rev = sort.Search(len(d.history), func(i int) bool {
+			return d.history[i].Time.After(msg.Time)
+		})
+	}
+	if rev < 0 || rev > d.rev {
+		sess.in <- noSuchRevision(rev)
+		return
+	}
+	var at time.Time
+	if rev > 0 {
+		at = d.history[rev-1].Time
+	}
+	sess.in <- comms.Revision{Text: d.textAt(rev), Rev: rev, Time: at}
+}
+
+// diff sends a session the changes made to its document between two
+// revisions. The caller must hold s.mu.
+func (s *Server) diff(id int, msg comms.FetchDiff) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	for _, rev := range []int{msg.From, msg.To} {
+		if rev < 0 || rev > d.rev {
+			sess.in <- noSuchRevision(rev)
+			return
+		}
+	}
+	if msg.From > msg.To {
+		sess.in <- comms.ErrorMessage{Code: comms.NO_SUCH_REV, Message: "diffs run from an earlier revision to a later one"}
+		return
+	}
+	sess.in <- comms.Diff{
+		From:    msg.From,
+		To:      msg.To,
+		Text:    d.textAt(msg.From),
+		Changes: changes(d, msg.From, msg.To),
+	}
+}
+
+// textAt returns the document as it was after rev changes.
+func (d *document) textAt(rev int) string {
+	if rev == d.rev {
+		return d.text
+	}
+	return store.Replay("", d.history[:rev])
+}
+
+// changes returns the changes that took d from revision from to revision to.
+func changes(d *document, from, to int) []comms.Change {
+	cs := make([]comms.Change, 0, to-from)
+	for i, c := range d.history[from:to] {
+		cs = append(cs, comms.Change{Rev: from + i + 1, Author: c.Author, Time: c.Time, Op: c.Op})
+	}
+	return cs
+}
+
+func noSuchRevision(rev int) comms.ErrorMessage {
+	return comms.ErrorMessage{Code: comms.NO_SUCH_REV, Message: fmt.Sprintf("there is no revision %d", rev)}
+}
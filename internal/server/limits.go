@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// A Rate allows PerSecond units on average, in bursts of up to Burst units.
+// The zero Rate is unlimited.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// Limits bound what clients may ask of the server. Zero fields are unlimited.
+type Limits struct {
+	// SessionMessages and SessionBytes limit the traffic of each session.
+	SessionMessages Rate
+	SessionBytes    Rate
+
+	// IPMessages and IPBytes limit the combined traffic of every session
+	// connecting from the same address.
+	IPMessages Rate
+	IPBytes    Rate
+
+	// MaxConnections and MaxConnectionsPerDocument cap concurrent sessions,
+	// in total and on any one document.
+	MaxConnections            int
+	MaxConnectionsPerDocument int
+
+	// MaxDocumentSize caps the length of a document in bytes. Changes that
+	// would grow it further are rejected.
+	MaxDocumentSize int
+}
+
+// DefaultLimits are generous enough for people typing but stop a single
+// client from flooding the server.
+var DefaultLimits = Limits{
+	SessionMessages:           Rate{PerSecond: 100, Burst: 500},
+	SessionBytes:              Rate{PerSecond: 256 << 10, Burst: 2 << 20},
+	IPMessages:                Rate{PerSecond: 400, Burst: 2000},
+	IPBytes:                   Rate{PerSecond: 1 << 20, Burst: 8 << 20},
+	MaxConnections:            1000,
+	MaxConnectionsPerDocument: 100,
+	MaxDocumentSize:           16 << 20,
+}
+
+// A bucket is a token bucket refilled at a Rate.
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(r Rate) *bucket {
+	if r.PerSecond <= 0 {
+		return nil
+	}
+	return &bucket{rate: r, tokens: float64(max(r.Burst, 1)), last: time.Now()}
+}
+
+// take removes n tokens from b, reporting false if it holds too few. A nil
+// bucket never runs out.
+func (b *bucket) take(n int) bool {
+	if b == nil {
+		return true
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate.PerSecond
+	b.tokens = min(b.tokens, float64(max(b.rate.Burst, 1)))
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// give puts back n tokens taken from b.
+func (b *bucket) give(n int) {
+	if b != nil {
+		b.tokens = min(b.tokens+float64(n), float64(max(b.rate.Burst, 1)))
+	}
+}
+
+// A limiter meters the messages and bytes of one session or address.
+type limiter struct {
+	mu       sync.Mutex
+	messages *bucket
+	bytes    *bucket
+
+	// sessions counts the sessions sharing an address's limiter.
+	sessions int
+}
+
+func newLimiter(messages, bytes Rate) *limiter {
+	l := new(limiter)
+	l.set(messages, bytes)
+	return l
+}
+
+// set changes the rates l allows, refilling its buckets.
+func (l *limiter) set(messages, bytes Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages, l.bytes = newBucket(messages), newBucket(bytes)
+}
+
+// allow charges one message of size n to l, charging nothing if it has run
+// out of either.
+func (l *limiter) allow(n int) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.messages.take(1) {
+		return false
+	}
+	if !l.bytes.take(n) {
+		l.messages.give(1)
+		return false
+	}
+	return true
+}
+
+// refund takes back the charge for a message of size n that l allowed.
+func (l *limiter) refund(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages.give(1)
+	l.bytes.give(n)
+}
+
+func (l *limiter) metersBytes() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bytes != nil
+}
+
+// host strips the port from a peer's address, so that every connection from
+// one machine shares its limits.
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
+// admit checks that a new session fits within the connection limits, and
+// that the server isn't shutting down, then loads its document and attaches
+// the limiters it will be metered by. The caller must hold s.mu.
+func (s *Server) admit(sess *session, addr string) error {
+	if s.closing {
+		return goingAway
+	}
+	if limit := s.Limits.MaxConnections; limit > 0 && len(s.sessions) >= limit {
+		return comms.ErrorMessage{Code: comms.TOO_MANY_CONNS, Message: "the server is full"}
+	}
+	if limit := s.Limits.MaxConnectionsPerDocument; limit > 0 {
+		n := 0
+		for _, o := range s.sessions {
+			if o.document == sess.document {
+				n++
+			}
+		}
+		if n >= limit {
+			return comms.ErrorMessage{
+				Code:    comms.TOO_MANY_CONNS,
+				Message: fmt.Sprintf("%q has %d participants already", sess.document, n),
+			}
+		}
+	}
+	if err := s.load(sess.document); err != nil {
+		return comms.ErrorMessage{Code: comms.INTERNAL, Message: fmt.Sprintf("could not load %q", sess.document)}
+	}
+
+	// Limiters are attached even if they allow everything, in case the
+	// limits are tightened by Reload.
+	sess.limiter = newLimiter(s.Limits.SessionMessages, s.Limits.SessionBytes)
+	if addr == "" {
+		return nil
+	}
+	sess.addr = host(addr)
+	l, ok := s.addrs[sess.addr]
+	if !ok {
+		l = newLimiter(s.Limits.IPMessages, s.Limits.IPBytes)
+		s.addrs[sess.addr] = l
+	}
+	l.sessions++
+	sess.addrLimiter = l
+	return nil
+}
+
+// release forgets the limiter of a departing session's address once no one
+// else is using it. The caller must hold s.mu.
+func (s *Server) release(sess *session) {
+	if sess.addrLimiter == nil {
+		return
+	}
+	sess.addrLimiter.sessions--
+	if sess.addrLimiter.sessions == 0 {
+		delete(s.addrs, sess.addr)
+	}
+}
+
+// limit reports an error if m takes sess over its message or byte rate.
+// Errors returned by admit, limit and fits are comms.ErrorMessages.
+func (s *Server) limit(sess *session, m comms.Message) error {
+	n := 0
+	if sess.limiter.metersBytes() || sess.addrLimiter.metersBytes() {
+		b, _ := comms.Encode(m)
+		n = len(b)
+	}
+	if !sess.limiter.allow(n) {
+		return comms.ErrorMessage{Code: comms.RATE_LIMITED, Message: "too many messages, slow down"}
+	}
+	if !sess.addrLimiter.allow(n) {
+		// The session isn't charged for a message the server refused.
+		sess.limiter.refund(n)
+		return comms.ErrorMessage{Code: comms.RATE_LIMITED, Message: "too many messages from your address, slow down"}
+	}
+	return nil
+}
+
+// fits checks that applying op to text leaves it within the document size
+// limit. The caller must hold s.mu.
+func (s *Server) fits(text string, op ot.Operation) error {
+	ins, ok := op.(ot.Insertion)
+	if !ok {
+		return nil
+	}
+	if limit := s.Limits.MaxDocumentSize; limit > 0 && len(text)+len(ins.Text) > limit {
+		return comms.ErrorMessage{
+			Code:    comms.TOO_LARGE,
+			Message: fmt.Sprintf("documents may not be longer than %d bytes", limit),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/metrics"
+)
+
+// stats counts what a server does, for its metrics.
+type stats struct {
+	connections  metrics.Counter
+	received     metrics.CounterVec
+	sent         metrics.CounterVec
+	bytesIn      metrics.Counter
+	bytesOut     metrics.Counter
+	opsReceived  metrics.Counter
+	opsBroadcast metrics.Counter
+	transforms   metrics.Counter
+	errors       metrics.CounterVec
+	queued       metrics.Gauge
+	saves        *metrics.Histogram
+}
+
+// metered counts the messages and bytes that pass through a transport.
+type metered struct {
+	comms.Transport
+	stats *stats
+}
+
+func (m metered) Send(msg comms.Message) error {
+	err := m.Transport.Send(msg)
+	if err != nil {
+		return err
+	}
+	m.stats.sent.With(msg.Kind().String()).Inc()
+	m.stats.bytesOut.Add(size(msg))
+	switch e := msg.(type) {
+	case comms.ErrorMessage:
+		m.stats.errors.With(e.Code).Inc()
+	case *comms.ErrorMessage:
+		m.stats.errors.With(e.Code).Inc()
+	}
+	return nil
+}
+
+func (m metered) Receive() (comms.Message, error) {
+	msg, err := m.Transport.Receive()
+	if err != nil {
+		return nil, err
+	}
+	m.stats.received.With(msg.Kind().String()).Inc()
+	m.stats.bytesIn.Add(size(msg))
+	return msg, nil
+}
+
+func (m metered) SetReadDeadline(t time.Time) error {
+	if d, ok := m.Transport.(deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// size is the length of a message as sent on a stream, without framing.
+func size(m comms.Message) uint64 {
+	b, _ := comms.Encode(m)
+	return uint64(len(b))
+}
+
+// MetricsHandler serves the server's metrics in the Prometheus text format.
+func (s *Server) MetricsHandler() http.Handler {
+	return metrics.Handler(s.writeMetrics)
+}
+
+func (s *Server) writeMetrics(w *metrics.Writer) {
+	s.mu.Lock()
+	sessions := len(s.sessions)
+	open := make(map[string]bool)
+	for _, sess := range s.sessions {
+		open[sess.document] = true
+	}
+	s.mu.Unlock()
+
+	st := &s.stats
+	w.Gauge("shed_sessions", "Sessions connected.", float64(sessions))
+	w.Gauge("shed_documents_open", "Documents with at least one session.", float64(len(open)))
+	w.Counter("shed_connections_total", "Connections accepted.", st.connections.Value())
+	w.CounterVec("shed_messages_received_total", "Messages received from clients, by kind.", "kind", &st.received)
+	w.CounterVec("shed_messages_sent_total", "Messages sent to clients, by kind.", "kind", &st.sent)
+	w.Counter("shed_received_bytes_total", "Bytes of messages received from clients.", st.bytesIn.Value())
+	w.Counter("shed_sent_bytes_total", "Bytes of messages sent to clients.", st.bytesOut.Value())
+	w.Counter("shed_ops_received_total", "Changes received from clients.", st.opsReceived.Value())
+	w.Counter("shed_ops_broadcast_total", "Changes relayed to other clients, counting each recipient.", st.opsBroadcast.Value())
+	w.Counter("shed_transforms_total", "Changes adjusted to apply to a newer version of their document.", st.transforms.Value())
+	w.Gauge("shed_queued_messages", "Messages received and waiting to be handled.", float64(st.queued.Value()))
+	w.Histogram("shed_store_append_seconds", "Time taken to save a change.", st.saves)
+	w.CounterVec("shed_errors_total", "Errors sent to clients, by code.", "code", &st.errors)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"time"
+
+	"github.com/shed-protocol/shed/internal/auth"
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+// Config holds the settings of a Server that can be changed while it runs.
+type Config struct {
+	Auth              auth.Authenticator
+	ACL               map[string]map[string]comms.Role
+	DefaultRole       comms.Role
+	HeartbeatInterval time.Duration
+	MissedHeartbeats  int
+	Limits            Limits
+	Debug             []string
+}
+
+// Reload changes the settings of a running server without dropping any
+// sessions. New rate limits and debugging apply to existing sessions
+// straight away, and participants are given the roles the new ACL grants
+// them. Roles the ACL no longer mentions are left as they are. Everything else
+// applies to sessions that join afterwards.
+func (s *Server) Reload(c Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Auth = c.Auth
+	s.DefaultRole = c.DefaultRole
+	s.HeartbeatInterval = c.HeartbeatInterval
+	s.MissedHeartbeats = c.MissedHeartbeats
+	s.Limits = c.Limits
+	s.Debug = c.Debug
+	for _, sess := range s.sessions {
+		sess.limiter.set(c.Limits.SessionMessages, c.Limits.SessionBytes)
+		sess.debug.Store(s.debugs(sess))
+	}
+	for _, l := range s.addrs {
+		l.set(c.Limits.IPMessages, c.Limits.IPBytes)
+	}
+
+	s.ACL = c.ACL
+	for name, d := range s.documents {
+		for user, r := range c.ACL[name] {
+			if d.roles[user] != r {
+				s.grant(name, user, r)
+			}
+		}
+	}
+}
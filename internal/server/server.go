@@ -1,18 +1,82 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/shed-protocol/shed/internal/auth"
 	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/metrics"
+	"github.com/shed-protocol/shed/internal/ot"
+	"github.com/shed-protocol/shed/internal/store"
 )
 
+// HandshakeTimeout bounds how long a new connection may take to authenticate.
+const HandshakeTimeout = 10 * time.Second
+
+// ServerClosedError is returned by Serve once Shutdown has been called.
+var ServerClosedError = errors.New("server closed")
+
 type Server struct {
-	listener net.Listener
-	cOuts    chan MessageWithId
+	// Auth, if set, verifies the credentials of every new connection before
+	// its session is registered.
+	Auth auth.Authenticator
+
+	// ACL assigns roles to authenticated users, keyed by document and then by
+	// user name. Owners may change these at runtime.
+	ACL map[string]map[string]comms.Role
+
+	// DefaultRole is given to anyone the ACL and their credentials don't
+	// assign a role to. If empty, they are editors.
+	DefaultRole comms.Role
+
+	// HeartbeatInterval, if set, is how often sessions are pinged. Sessions
+	// that stay silent for MissedHeartbeats intervals are torn down.
+	HeartbeatInterval time.Duration
+	MissedHeartbeats  int
+
+	// Limits protect the server from clients that connect or send too much.
+	Limits Limits
+
+	// Store, if set, records every change, and documents are loaded from it
+	// when first opened.
+	Store store.Store
+
+	// Logger, if set, records what the server does. Every message sessions
+	// send and receive is logged at debug level.
+	Logger *slog.Logger
+
+	// Debug lists documents and user names whose sessions log every message
+	// at info level instead, so they can be followed without logging
+	// everyone else's.
+	Debug []string
+
+	cOuts chan MessageWithId
+
+	// wg counts the goroutines serving connections, so that Shutdown can
+	// wait for them to finish.
+	wg   sync.WaitGroup
+	stop sync.Once
+
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	sessions  map[int]*session
+	documents map[string]*document
+	nextId    int
 
-	mu   sync.Mutex
-	cIns map[int]chan<- comms.Message
+	// addrs holds the limiters shared by sessions from the same address.
+	addrs map[string]*limiter
+
+	stats stats
 }
 
 type MessageWithId struct {
@@ -20,39 +84,700 @@ type MessageWithId struct {
 	id  int
 }
 
+type session struct {
+	t           comms.Transport
+	in          chan<- comms.Message
+	document    string
+	identity    auth.Identity
+	role        comms.Role
+	participant *comms.Participant
+
+	addr        string
+	limiter     *limiter
+	addrLimiter *limiter
+
+	log   *slog.Logger
+	debug atomic.Bool
+
+	// watching is set once the session has listed the documents under
+	// prefix, and is told of changes to them.
+	watching bool
+	prefix   string
+}
+
+// author names the user making changes in a session.
+func (sess *session) author() string {
+	if sess.identity.Name != "" || sess.participant == nil {
+		return sess.identity.Name
+	}
+	return sess.participant.Name
+}
+
+// Level is the level the session's messages are logged at.
+func (sess *session) Level() slog.Level {
+	if sess.debug.Load() {
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+type document struct {
+	// roles holds the roles of authenticated users by name.
+	roles map[string]comms.Role
+
+	// text is the document after rev changes, applied in the order the server
+	// received them. The changes are kept in history.
+	text    string
+	rev     int
+	history []store.Change
+	loaded  bool
+
+	// authors records who wrote each part of text.
+	authors attribution
+
+	// checkpoints name revisions of the document, oldest first.
+	checkpoints []store.Checkpoint
+
+	// locked documents can't be changed by anyone.
+	locked bool
+}
+
+// discard is the logger of servers that haven't been given one.
+var discard = slog.New(slog.DiscardHandler)
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return discard
+	}
+	return s.Logger
+}
+
+// debugs reports whether sess is listed in s.Debug. The caller must hold s.mu.
+func (s *Server) debugs(sess *session) bool {
+	return slices.Contains(s.Debug, sess.document) ||
+		sess.identity.Name != "" && slices.Contains(s.Debug, sess.identity.Name)
+}
+
 func (s *Server) Init() {
-	s.cIns = make(map[int]chan<- comms.Message)
+	s.sessions = make(map[int]*session)
+	s.documents = make(map[string]*document)
+	s.addrs = make(map[string]*limiter)
+	s.listeners = make(map[net.Listener]struct{})
 	s.cOuts = make(chan MessageWithId)
+	s.stats.saves = metrics.NewHistogram(metrics.LatencyBuckets)
 }
 
 func (s *Server) Start() {
 	for m := range s.cOuts {
+		s.stats.queued.Add(-1)
 		s.mu.Lock()
-		for id, ch := range s.cIns {
-			if id == m.id {
-				ch <- comms.AcknowledgeChange{}
-			} else {
-				ch <- m.msg
+		switch msg := m.msg.(type) {
+		case comms.JoinSession:
+			s.join(m.id, msg)
+		case *comms.JoinSession:
+			s.join(m.id, *msg)
+		case comms.SetRole:
+			s.setRole(m.id, msg)
+		case *comms.SetRole:
+			s.setRole(m.id, *msg)
+		case comms.FetchDocument, *comms.FetchDocument:
+			s.fetch(m.id)
+		case comms.FetchHistory:
+			s.history(m.id, msg)
+		case *comms.FetchHistory:
+			s.history(m.id, *msg)
+		case comms.FetchRevision:
+			s.revision(m.id, msg)
+		case *comms.FetchRevision:
+			s.revision(m.id, *msg)
+		case comms.FetchDiff:
+			s.diff(m.id, msg)
+		case *comms.FetchDiff:
+			s.diff(m.id, *msg)
+		case comms.CreateCheckpoint:
+			s.checkpoint(m.id, msg)
+		case *comms.CreateCheckpoint:
+			s.checkpoint(m.id, *msg)
+		case comms.FetchCheckpoints, *comms.FetchCheckpoints:
+			s.checkpoints(m.id)
+		case comms.FetchBlame, *comms.FetchBlame:
+			s.blame(m.id)
+		case comms.Revert:
+			s.revert(m.id, msg)
+		case *comms.Revert:
+			s.revert(m.id, *msg)
+		case comms.ListDocuments:
+			s.listDocuments(m.id, msg)
+		case *comms.ListDocuments:
+			s.listDocuments(m.id, *msg)
+		case comms.CreateDocument:
+			s.createDocument(m.id, msg)
+		case *comms.CreateDocument:
+			s.createDocument(m.id, *msg)
+		case comms.DeleteDocument:
+			s.deleteDocument(m.id, msg)
+		case *comms.DeleteDocument:
+			s.deleteDocument(m.id, *msg)
+		case comms.RenameDocument:
+			s.renameDocument(m.id, msg)
+		case *comms.RenameDocument:
+			s.renameDocument(m.id, *msg)
+		default:
+			if m.msg.Kind() == comms.BUFFER_OP {
+				s.relay(m)
 			}
 		}
 		s.mu.Unlock()
 	}
 }
 
-func (s *Server) Accept(c net.Conn) {
+// Serve accepts connections from l until it is closed, framing messages on
+// each with comms.NewStream. TLS handshakes are completed before the
+// connection is accepted, so that client certificates identify the session.
+// Other errors accepting connections are retried after a delay, from 5ms up
+// to a second, doubling each time.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ServerClosedError
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				if s.closing {
+					return ServerClosedError
+				}
+				return err
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			s.logger().Warn("accepting a connection", "error", err, "retry", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		tc, ok := conn.(*tls.Conn)
+		if !ok {
+			s.Accept(comms.NewStream(conn))
+			continue
+		}
+		go func() {
+			tc.SetDeadline(time.Now().Add(HandshakeTimeout))
+			if err := tc.Handshake(); err != nil {
+				tc.Close()
+				return
+			}
+			tc.SetDeadline(time.Time{})
+			s.Accept(comms.NewStream(tc))
+		}()
+	}
+}
+
+func (s *Server) Accept(t comms.Transport) {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		t.Send(goingAway)
+		t.Close()
+		return
+	}
+	s.wg.Add(1)
+	authn := s.Auth
+	s.mu.Unlock()
+	s.stats.connections.Inc()
+	t = metered{t, &s.stats}
+
+	sess := &session{identity: auth.Identity{Name: t.Remote().Name}}
+	if authn == nil {
+		s.register(t, sess)
+		s.wg.Done()
+		return
+	}
+	go func() {
+		defer s.wg.Done()
+		d, _ := t.(deadliner)
+		if d != nil {
+			d.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+		}
+		if err := authenticate(authn, t, sess); err != nil {
+			var e comms.ErrorMessage
+			if !errors.As(err, &e) {
+				e = comms.ErrorMessage{Code: comms.UNAUTHORIZED, Message: err.Error()}
+			}
+			s.logger().Warn("authentication failed", "addr", t.Remote().Addr, "error", err)
+			t.Send(e)
+			t.Close()
+			return
+		}
+		if d != nil {
+			d.SetReadDeadline(time.Time{})
+		}
+		s.register(t, sess)
+	}()
+}
+
+// goingAway is the last message sessions receive when the server shuts down.
+var goingAway = comms.ErrorMessage{Code: comms.GOING_AWAY, Message: "the server is shutting down"}
+
+// Shutdown stops accepting connections and tells every session that the
+// server is going away. Changes already relayed are delivered before the
+// connections are closed. Shutdown waits for every session to finish, or
+// for ctx to be done, in which case the remaining connections are dropped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	sessions := s.sessions
+	s.sessions = make(map[int]*session)
+	s.mu.Unlock()
+	s.logger().Info("shutting down", "sessions", len(sessions))
+
+	// Once removed from s.sessions, nothing else sends to a session, so its
+	// writer can be told to finish without holding s.mu.
+	for _, sess := range sessions {
+		go func() {
+			sess.in <- goingAway
+			close(sess.in)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		s.stop.Do(func() { close(s.cOuts) })
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, sess := range sessions {
+			sess.t.Close()
+		}
+		return ctx.Err()
+	}
+}
+
+// A deadliner is a transport that can stop waiting for the peer.
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// authenticate reads the credentials a new connection opens with and checks
+// them with authn. A name established by the transport is kept if the
+// credentials don't carry one.
+func authenticate(authn auth.Authenticator, t comms.Transport, sess *session) error {
+	m, err := t.Receive()
+	if err != nil {
+		return err
+	}
+	creds, ok := m.(*comms.Authenticate)
+	if !ok {
+		return auth.InvalidCredentialsError
+	}
+	id, err := authn.Authenticate(*creds)
+	if err != nil {
+		return err
+	}
+	if !id.CanAccess(creds.Document) {
+		return comms.ErrorMessage{
+			Code:    comms.FORBIDDEN,
+			Message: fmt.Sprintf("not allowed to open %q", creds.Document),
+		}
+	}
+	if id.Name == "" {
+		id.Name = sess.identity.Name
+	}
+	sess.document = creds.Document
+	sess.identity = id
+	return nil
+}
+
+func (s *Server) register(t comms.Transport, sess *session) {
+	addr := t.Remote().Addr
+	s.mu.Lock()
+	if err := s.admit(sess, addr); err != nil {
+		s.mu.Unlock()
+		s.logger().Warn("turned away", "addr", addr, "document", sess.document, "user", sess.identity.Name, "error", err)
+		t.Send(err.(comms.ErrorMessage))
+		t.Close()
+		return
+	}
+	id := s.nextId
+	s.nextId++
+	sess.role = s.roleOf(sess, sess.document)
+	sess.debug.Store(s.debugs(sess))
+	interval, missed := s.HeartbeatInterval, s.MissedHeartbeats
+	s.mu.Unlock()
+
+	sess.log = s.logger().With("session", id, "document", sess.document)
+	if name := sess.identity.Name; name != "" {
+		sess.log = sess.log.With("user", name)
+	}
+	sess.log.Info("session opened", "addr", addr, "role", sess.role)
+	t = comms.Logged(t, sess.log, sess)
+	if interval > 0 {
+		t = comms.Heartbeat(t, interval, missed)
+	}
 	in := make(chan comms.Message)
 	out := make(chan comms.Message)
-	go comms.ChanToWriter(in, c)
-	go comms.ReaderToChan(c, out)
+	sess.t = t
+	sess.in = in
+	s.wg.Add(3)
+	go func() {
+		defer s.wg.Done()
+		comms.ChanToTransport(in, t)
+		t.Close()
+		for range in {
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		err := comms.TransportToChan(t, out)
+		sess.log.Info("session closed", "reason", err)
+		close(out)
+	}()
 
 	s.mu.Lock()
-	id := len(s.cIns)
-	s.cIns[id] = in
+	s.sessions[id] = sess
 	s.mu.Unlock()
 
 	go func() {
-		for {
-			s.cOuts <- MessageWithId{<-out, id}
+		defer s.wg.Done()
+		for m := range out {
+			if err := s.limit(sess, m); err != nil {
+				sess.log.Warn("rate limited", "kind", m.Kind().String())
+				// A session that has been kicked, or told the server is
+				// going away, may have had in closed under it.
+				e := err.(comms.ErrorMessage)
+				e.Change = m.Kind() == comms.BUFFER_OP
+				s.mu.Lock()
+				if s.sessions[id] == sess {
+					in <- e
+				}
+				s.mu.Unlock()
+				continue
+			}
+			s.stats.queued.Add(1)
+			s.cOuts <- MessageWithId{m, id}
 		}
+		s.leave(id)
 	}()
 }
+
+// peers calls f for every session other than id editing the same document.
+// The caller must hold s.mu.
+func (s *Server) peers(id int, f func(*session)) {
+	doc := s.sessions[id].document
+	for other, sess := range s.sessions {
+		if other != id && sess.document == doc {
+			f(sess)
+		}
+	}
+}
+
+// relay acknowledges a change to its sender and forwards it to everyone else
+// editing the same document. The caller must hold s.mu.
+func (s *Server) relay(m MessageWithId) {
+	sess, ok := s.sessions[m.id]
+	if !ok {
+		return
+	}
+	s.stats.opsReceived.Inc()
+	if !sess.role.CanEdit() {
+		sess.log.Debug("rejected change from viewer")
+		sess.in <- comms.ErrorMessage{Code: comms.READ_ONLY, Message: "viewers cannot edit the document", Change: true}
+		return
+	}
+	if s.document(sess.document).locked {
+		sess.log.Debug("rejected change to locked document")
+		sess.in <- comms.ErrorMessage{Code: comms.READ_ONLY, Message: "the document is locked", Change: true}
+		return
+	}
+	op, _ := comms.AsOp(m.msg)
+	if msg, ok := m.msg.(*comms.OpMessage); ok && msg.Rev != nil {
+		op = s.rebase(s.document(sess.document), op, *msg.Rev)
+	}
+	op, err := s.apply(sess, op)
+	if err != nil {
+		e := err.(comms.ErrorMessage)
+		e.Change = true
+		sess.in <- e
+		return
+	}
+	sess.in <- comms.AcknowledgeChange{}
+	change := comms.OpMessage{Op: op, Author: sess.author()}
+	s.peers(m.id, func(p *session) {
+		s.stats.opsBroadcast.Inc()
+		p.in <- change
+	})
+}
+
+// rebase returns op, made to revision rev of d, as it applies after the
+// changes made since. Revisions d doesn't have leave op as it is. The caller
+// must hold s.mu.
+func (s *Server) rebase(d *document, op ot.Operation, rev int) ot.Operation {
+	if rev < 0 || rev >= d.rev {
+		return op
+	}
+	s.stats.transforms.Inc()
+	for _, c := range d.history[rev:] {
+		op = op.Rebase(c.Op)
+	}
+	return op
+}
+
+// apply records a change made by sess to the server's copy of its document
+// and its history, returning the change as it was applied. Changes are
+// clamped to fit, in case they were made to an older version that the client
+// didn't say. The caller must hold s.mu.
+func (s *Server) apply(sess *session, op ot.Operation) (ot.Operation, error) {
+	name := sess.document
+	d := s.document(name)
+	if clamped := ot.Clamp(op, uint(len(d.text))); clamped != op {
+		s.stats.transforms.Inc()
+		op = clamped
+	}
+	if err := s.fits(d.text, op); err != nil {
+		sess.log.Warn("rejected change", "rev", d.rev, "error", err)
+		return nil, err
+	}
+	c := store.Change{Op: op, Author: sess.author(), Time: time.Now()}
+	if s.Store != nil {
+		start := time.Now()
+		err := s.Store.Append(name, c)
+		s.stats.saves.Since(start)
+		if err != nil {
+			sess.log.Error("saving change", "rev", d.rev, "error", err)
+			return nil, comms.ErrorMessage{Code: comms.INTERNAL, Message: "could not save the change"}
+		}
+	}
+	d.text = op.Apply(d.text)
+	d.rev++
+	d.history = append(d.history, c)
+	d.authors.apply(op, c.Author, d.rev)
+	sess.log.Debug("applied change", "rev", d.rev)
+	return op, nil
+}
+
+// fetch sends a session the server's copy of its document. The caller must
+// hold s.mu.
+func (s *Server) fetch(id int) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	d := s.document(sess.document)
+	sess.in <- comms.Document{Text: d.text, Rev: d.rev}
+}
+
+// join records the identity declared by a session, sends it the current
+// roster and announces it to everyone else. An authenticated name takes
+// precedence over the declared one. The caller must hold s.mu.
+func (s *Server) join(id int, msg comms.JoinSession) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	p := comms.Participant{Id: id, Name: msg.Name, Colour: msg.Colour, Role: sess.role}
+	if sess.identity.Name != "" {
+		p.Name = sess.identity.Name
+	}
+	sess.participant = &p
+	sess.log.Info("joined", "participant", id, "name", p.Name)
+
+	sess.in <- comms.Roster{Self: id, Participants: s.roster(sess.document)}
+	s.peers(id, func(o *session) {
+		o.in <- comms.ParticipantJoined{Participant: p}
+	})
+}
+
+// setRole changes the role of a participant on behalf of the document's owner.
+// A participant who authenticated by name is given the role in all of their
+// sessions. The caller must hold s.mu.
+func (s *Server) setRole(id int, msg comms.SetRole) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	if sess.role != comms.OWNER {
+		sess.in <- comms.ErrorMessage{Code: comms.FORBIDDEN, Message: "only owners can change roles"}
+		return
+	}
+	target, ok := s.sessions[msg.Id]
+	if !ok || target.document != sess.document || target.participant == nil {
+		sess.in <- comms.ErrorMessage{Code: comms.FORBIDDEN, Message: fmt.Sprintf("no participant %d", msg.Id)}
+		return
+	}
+	if !msg.Role.Valid() {
+		sess.in <- comms.ErrorMessage{Code: comms.FORBIDDEN, Message: fmt.Sprintf("unknown role %q", msg.Role)}
+		return
+	}
+
+	sess.log.Info("changing role", "participant", msg.Id, "role", msg.Role)
+	if name := target.identity.Name; name != "" {
+		s.grant(sess.document, name, msg.Role)
+	} else {
+		s.changeRole(msg.Id, msg.Role)
+	}
+}
+
+// grant gives a user a role on a document, in all of their sessions. The
+// caller must hold s.mu.
+func (s *Server) grant(doc, name string, role comms.Role) {
+	s.document(doc).roles[name] = role
+	for id, o := range s.sessions {
+		if o.document == doc && o.identity.Name == name {
+			s.changeRole(id, role)
+		}
+	}
+}
+
+// changeRole changes the role of a session and announces it to everyone
+// editing the same document. The caller must hold s.mu.
+func (s *Server) changeRole(id int, role comms.Role) {
+	sess := s.sessions[id]
+	sess.role = role
+	if sess.participant == nil {
+		return
+	}
+	sess.participant.Role = role
+	for _, p := range s.sessions {
+		if p.document == sess.document {
+			p.in <- comms.RoleChanged{Id: id, Role: role}
+		}
+	}
+}
+
+// roleOf decides the role sess has on a document, which is the role it starts
+// with if the document is its own. The caller must hold s.mu.
+func (s *Server) roleOf(sess *session, doc string) comms.Role {
+	if name := sess.identity.Name; name != "" {
+		roles := s.ACL[doc]
+		if d, ok := s.documents[doc]; ok {
+			roles = d.roles
+		}
+		if r, ok := roles[name]; ok {
+			return r
+		}
+	}
+	if sess.identity.Role.Valid() {
+		return sess.identity.Role
+	}
+	if s.DefaultRole.Valid() {
+		return s.DefaultRole
+	}
+	return comms.EDITOR
+}
+
+// document returns the state of the named document, creating it if this is
+// the first time it has been opened. The caller must hold s.mu.
+func (s *Server) document(name string) *document {
+	d, ok := s.documents[name]
+	if !ok {
+		d = &document{roles: make(map[string]comms.Role)}
+		for user, r := range s.ACL[name] {
+			d.roles[user] = r
+		}
+		s.documents[name] = d
+	}
+	return d
+}
+
+// load reads a document from s.Store the first time it is opened. The caller
+// must hold s.mu.
+func (s *Server) load(name string) error {
+	d := s.document(name)
+	if d.loaded || s.Store == nil {
+		return nil
+	}
+	text, history, err := s.read(name)
+	if err != nil {
+		s.logger().Error("loading document", "document", name, "error", err)
+		return err
+	}
+	if cp, ok := s.Store.(store.Checkpointer); ok {
+		if d.checkpoints, err = cp.LoadCheckpoints(name); err != nil {
+			s.logger().Error("loading checkpoints", "document", name, "error", err)
+			return err
+		}
+	}
+	d.text = text
+	d.rev = len(history)
+	d.history = history
+	d.authors = attribute(history)
+	d.loaded = true
+	s.logger().Info("loaded document", "document", name, "rev", d.rev)
+	return nil
+}
+
+// read returns a document's text and history from s.Store, replaying the
+// history from the document's snapshot if it has one.
+func (s *Server) read(name string) (text string, history []store.Change, err error) {
+	rev := 0
+	if snap, ok := s.Store.(store.Snapshotter); ok {
+		if text, rev, err = snap.LoadSnapshot(name); err != nil {
+			return "", nil, err
+		}
+	}
+	if history, err = s.Store.Load(name); err != nil {
+		return "", nil, err
+	}
+	if rev > len(history) {
+		// The log lost changes the snapshot includes, so the snapshot can't
+		// be trusted to agree with it.
+		text, rev = "", 0
+	}
+	return store.Replay(text, history[rev:]), history, nil
+}
+
+func (s *Server) leave(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	s.remove(id, sess)
+	close(sess.in)
+}
+
+// remove forgets a session and tells everyone else editing its document that
+// it left. The caller must hold s.mu.
+func (s *Server) remove(id int, sess *session) {
+	if sess.participant != nil {
+		s.peers(id, func(o *session) {
+			o.in <- comms.ParticipantLeft{Id: id}
+		})
+	}
+	s.release(sess)
+	delete(s.sessions, id)
+}
+
+// roster lists every session editing doc that has joined, ordered by
+// participant ID. The caller must hold s.mu.
+func (s *Server) roster(doc string) []comms.Participant {
+	ps := make([]comms.Participant, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		if sess.document == doc && sess.participant != nil {
+			ps = append(ps, *sess.participant)
+		}
+	}
+	slices.SortFunc(ps, func(a, b comms.Participant) int { return a.Id - b.Id })
+	return ps
+}
@@ -1,11 +1,31 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"log/slog"
+	"math"
+	"math/big"
 	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/shed-protocol/shed/internal/auth"
 	"github.com/shed-protocol/shed/internal/comms"
 	"github.com/shed-protocol/shed/internal/ot"
+	"github.com/shed-protocol/shed/internal/store"
 )
 
 type MockClient struct {
@@ -13,13 +33,13 @@ type MockClient struct {
 	sOut <-chan comms.Message
 }
 
-func (c *MockClient) Connect(conn net.Conn) {
+func (c *MockClient) Connect(conn comms.Transport) {
 	sIn := make(chan comms.Message)
 	sOut := make(chan comms.Message)
 	c.sIn = sIn
 	c.sOut = sOut
-	go comms.ChanToWriter(sIn, conn)
-	go comms.ReaderToChan(conn, sOut)
+	go comms.ChanToTransport(sIn, conn)
+	go comms.TransportToChan(conn, sOut)
 }
 
 func setupTwoClients() (alice *MockClient, bob *MockClient, s *Server, teardown func()) {
@@ -28,11 +48,11 @@ func setupTwoClients() (alice *MockClient, bob *MockClient, s *Server, teardown
 	s = new(Server)
 	s.Init()
 
-	a1, b1 := net.Pipe()
+	a1, b1 := comms.Pipe()
 	alice.Connect(a1)
 	s.Accept(b1)
 
-	a2, b2 := net.Pipe()
+	a2, b2 := comms.Pipe()
 	bob.Connect(a2)
 	s.Accept(b2)
 
@@ -68,7 +88,7 @@ func TestServerBroadcastsChanges(t *testing.T) {
 	defer teardown()
 
 	// When one client sends a change
-	want := comms.OpMessage{Op: ot.Insertion{Text: "hello", Pos: 2}}
+	want := comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
 	go func() {
 		alice.sIn <- want
 	}()
@@ -78,3 +98,1233 @@ func TestServerBroadcastsChanges(t *testing.T) {
 		t.Errorf("Bob got %v, but Alice sent %v", got, want)
 	}
 }
+
+func TestServerRebasesChangesToOlderRevisions(t *testing.T) {
+	// Given two clients have both seen "ab" at revision 1
+	alice, bob, _, teardown := setupTwoClients()
+	defer teardown()
+	rev := func(n int) *int { return &n }
+	go func() {
+		alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "ab"}, Rev: rev(0)}
+	}()
+	<-alice.sOut
+	<-bob.sOut
+
+	// When Alice inserts at the start
+	go func() {
+		alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "X"}, Rev: rev(1)}
+	}()
+	<-alice.sOut
+	<-bob.sOut
+
+	// And Bob, not having seen her change, appends to revision 1
+	go func() {
+		bob.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "Y", Pos: 2}, Rev: rev(1)}
+	}()
+	<-bob.sOut
+
+	// Then Alice should be sent Bob's change as it applies after hers
+	want := ot.Insertion{Text: "Y", Pos: 3}
+	if got := <-alice.sOut; got.(*comms.OpMessage).Op != want {
+		t.Errorf("Alice got %v, expected %v", got, want)
+	}
+}
+
+func TestServerSendsRosterOnJoin(t *testing.T) {
+	// Given two clients are connected to the server
+	alice, bob, _, teardown := setupTwoClients()
+	defer teardown()
+
+	// When both clients join
+	alice.sIn <- comms.JoinSession{Name: "alice", Colour: "#ff0000"}
+	<-alice.sOut
+	<-bob.sOut
+	go func() {
+		bob.sIn <- comms.JoinSession{Name: "bob", Colour: "#0000ff"}
+	}()
+
+	// Then the first client should be told about the second
+	msg, ok := (<-alice.sOut).(*comms.ParticipantJoined)
+	if !ok || msg.Participant.Name != "bob" {
+		t.Fatalf("Alice got %v, expected bob to join", msg)
+	}
+
+	// Then the second client should receive a roster listing both
+	roster, ok := (<-bob.sOut).(*comms.Roster)
+	if !ok {
+		t.Fatalf("Bob did not receive a roster")
+	}
+	if len(roster.Participants) != 2 || roster.Participants[roster.Self].Name != "bob" {
+		t.Errorf("Bob got roster %+v", roster)
+	}
+}
+
+func TestServerAnnouncesLeave(t *testing.T) {
+	// Given two clients have joined the server
+	alice := new(MockClient)
+	bob := new(MockClient)
+	s := new(Server)
+	s.Init()
+	go s.Start()
+
+	a1, b1 := comms.Pipe()
+	defer a1.Close()
+	alice.Connect(a1)
+	s.Accept(b1)
+	a2, b2 := comms.Pipe()
+	bob.Connect(a2)
+	s.Accept(b2)
+
+	alice.sIn <- comms.JoinSession{Name: "alice"}
+	<-alice.sOut
+	<-bob.sOut
+	bob.sIn <- comms.JoinSession{Name: "bob"}
+	<-alice.sOut
+	joined := (<-bob.sOut).(*comms.Roster)
+
+	// When one client disconnects
+	a2.Close()
+
+	// Then the other should be told it left
+	msg, ok := (<-alice.sOut).(*comms.ParticipantLeft)
+	if !ok || msg.Id != joined.Self {
+		t.Errorf("Alice got %v, expected participant %d to leave", msg, joined.Self)
+	}
+}
+
+func TestServerRejectsBadCredentials(t *testing.T) {
+	// Given the server requires a shared secret
+	s := new(Server)
+	s.Auth = auth.Keys{Secret: "hunter2"}
+	s.Init()
+	go s.Start()
+
+	// When a client connects with the wrong secret
+	a, b := comms.Pipe()
+	defer a.Close()
+	s.Accept(b)
+	go a.Send(comms.Authenticate{Secret: "hunter3"})
+
+	// Then the server should reply with an error and not register the session
+	msg, err := a.Receive()
+	if e, ok := msg.(*comms.ErrorMessage); err != nil || !ok || e.Code != comms.UNAUTHORIZED {
+		t.Errorf("got %v (%v), expected unauthorized error", msg, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sessions) != 0 {
+		t.Errorf("server registered %d sessions", len(s.sessions))
+	}
+}
+
+func TestServerSeparatesDocuments(t *testing.T) {
+	// Given three authenticated clients, two editing the same document
+	key := []byte("key")
+	s := new(Server)
+	s.Auth = auth.Keys{TokenKey: key}
+	s.Init()
+	go s.Start()
+
+	connect := func(doc string) *MockClient {
+		a, b := comms.Pipe()
+		t.Cleanup(func() { a.Close() })
+		s.Accept(b)
+		token := auth.IssueToken(key, auth.Identity{Documents: []string{doc}}, time.Time{})
+		a.Send(comms.Authenticate{Document: doc, Token: token})
+		c := new(MockClient)
+		c.Connect(a)
+		return c
+	}
+	alice, bob, carol := connect("notes"), connect("notes"), connect("todo")
+
+	// When one client sends a change
+	want := comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	alice.sIn <- want
+	<-alice.sOut
+
+	// Then only the client on the same document should receive it
+	if got := <-bob.sOut; *got.(*comms.OpMessage) != want {
+		t.Errorf("Bob got %v, but Alice sent %v", got, want)
+	}
+	select {
+	case got := <-carol.sOut:
+		t.Errorf("Carol got %v from another document", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestServerForbidsUnlistedDocument(t *testing.T) {
+	// Given the server accepts tokens
+	key := []byte("key")
+	s := new(Server)
+	s.Auth = auth.Keys{TokenKey: key}
+	s.Init()
+	go s.Start()
+
+	// When a client asks for a document its token does not allow
+	a, b := comms.Pipe()
+	defer a.Close()
+	s.Accept(b)
+	token := auth.IssueToken(key, auth.Identity{Documents: []string{"notes"}}, time.Time{})
+	go a.Send(comms.Authenticate{Document: "secrets", Token: token})
+
+	// Then the server should refuse it
+	msg, _ := a.Receive()
+	if e, ok := msg.(*comms.ErrorMessage); !ok || e.Code != comms.FORBIDDEN {
+		t.Errorf("got %v, expected forbidden error", msg)
+	}
+}
+
+func setupRoles(t *testing.T, roles map[string]comms.Role) (*Server, func(name string) *MockClient) {
+	key := []byte("key")
+	s := new(Server)
+	s.Auth = auth.Keys{TokenKey: key}
+	s.ACL = map[string]map[string]comms.Role{"notes": roles}
+	s.Init()
+	go s.Start()
+
+	return s, func(name string) *MockClient {
+		a, b := comms.Pipe()
+		t.Cleanup(func() { a.Close() })
+		s.Accept(b)
+		token := auth.IssueToken(key, auth.Identity{Name: name}, time.Time{})
+		a.Send(comms.Authenticate{Document: "notes", Token: token})
+		c := new(MockClient)
+		c.Connect(a)
+		return c
+	}
+}
+
+func TestServerRejectsChangesFromViewers(t *testing.T) {
+	// Given a viewer and an editor are connected
+	_, connect := setupRoles(t, map[string]comms.Role{"alice": comms.VIEWER})
+	alice, bob := connect("alice"), connect("bob")
+
+	// When the viewer sends a change
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+
+	// Then the server should reject it
+	if msg, ok := (<-alice.sOut).(*comms.ErrorMessage); !ok || msg.Code != comms.READ_ONLY {
+		t.Errorf("Alice got %v, expected a read-only error", msg)
+	}
+
+	// Then the viewer should still receive changes from the editor, naming
+	// their author
+	sent := comms.OpMessage{Op: ot.Insertion{Text: "world"}}
+	bob.sIn <- sent
+	<-bob.sOut
+	want := comms.OpMessage{Op: sent.Op, Author: "bob"}
+	if got := <-alice.sOut; *got.(*comms.OpMessage) != want {
+		t.Errorf("Alice got %v, expected %v", got, want)
+	}
+}
+
+func TestOwnerCanChangeRoles(t *testing.T) {
+	// Given an owner and an editor have joined
+	_, connect := setupRoles(t, map[string]comms.Role{"alice": comms.OWNER})
+	alice, bob := connect("alice"), connect("bob")
+	alice.sIn <- comms.JoinSession{}
+	<-alice.sOut
+	<-bob.sOut
+	bob.sIn <- comms.JoinSession{}
+	<-alice.sOut
+	roster := (<-bob.sOut).(*comms.Roster)
+
+	// When the owner makes the editor a viewer
+	alice.sIn <- comms.SetRole{Id: roster.Self, Role: comms.VIEWER}
+
+	// Then everyone should be told about the change
+	want := comms.RoleChanged{Id: roster.Self, Role: comms.VIEWER}
+	for _, c := range []*MockClient{alice, bob} {
+		if got, ok := (<-c.sOut).(*comms.RoleChanged); !ok || *got != want {
+			t.Errorf("got %v, expected %v", got, want)
+		}
+	}
+
+	// Then the former editor should no longer be able to edit
+	bob.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	if msg, ok := (<-bob.sOut).(*comms.ErrorMessage); !ok || msg.Code != comms.READ_ONLY {
+		t.Errorf("Bob got %v, expected a read-only error", msg)
+	}
+}
+
+func TestOnlyOwnersCanChangeRoles(t *testing.T) {
+	// Given an editor has joined
+	_, connect := setupRoles(t, nil)
+	alice := connect("alice")
+	alice.sIn <- comms.JoinSession{}
+	self := (<-alice.sOut).(*comms.Roster).Self
+
+	// When an editor tries to promote themselves
+	alice.sIn <- comms.SetRole{Id: self, Role: comms.OWNER}
+
+	// Then the server should refuse
+	if msg, ok := (<-alice.sOut).(*comms.ErrorMessage); !ok || msg.Code != comms.FORBIDDEN {
+		t.Errorf("Alice got %v, expected a forbidden error", msg)
+	}
+}
+
+// selfSigned returns a certificate for name that signs itself.
+func selfSigned(t *testing.T, name string) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func TestServerNamesParticipantsByClientCertificate(t *testing.T) {
+	// Given the server requires client certificates
+	serverCert, serverX509 := selfSigned(t, "localhost")
+	aliceCert, aliceX509 := selfSigned(t, "alice")
+	clients, servers := x509.NewCertPool(), x509.NewCertPool()
+	clients.AddCert(aliceX509)
+	servers.AddCert(serverX509)
+
+	s := new(Server)
+	s.Init()
+	go s.Start()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clients,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	// When a client presenting a certificate joins
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		ServerName:   "localhost",
+		RootCAs:      servers,
+		Certificates: []tls.Certificate{aliceCert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	alice := new(MockClient)
+	alice.Connect(comms.NewStream(conn))
+	alice.sIn <- comms.JoinSession{Name: "mallory"}
+
+	// Then the participant should be named after the certificate
+	roster := (<-alice.sOut).(*comms.Roster)
+	if got := roster.Participants[0].Name; got != "alice" {
+		t.Errorf("participant is named %q, expected alice", got)
+	}
+}
+
+func TestServerDropsSilentSessions(t *testing.T) {
+	// Given the server expects regular heartbeats
+	s := new(Server)
+	s.HeartbeatInterval = 5 * time.Millisecond
+	s.MissedHeartbeats = 2
+	s.Init()
+	go s.Start()
+
+	// When a client connects but never answers pings
+	a, b := comms.Pipe()
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, err := a.Receive(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	s.Accept(b)
+
+	// Then its session should be torn down
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("server kept the silent session open")
+	}
+	for {
+		s.mu.Lock()
+		n := len(s.sessions)
+		s.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func setupLimits(limits Limits) func() *MockClient {
+	s := &Server{Limits: limits}
+	s.Init()
+	go s.Start()
+	return func() *MockClient {
+		c := new(MockClient)
+		a, b := comms.Pipe()
+		c.Connect(a)
+		s.Accept(b)
+		return c
+	}
+}
+
+func expectError(t *testing.T, c *MockClient, code string) comms.ErrorMessage {
+	t.Helper()
+	select {
+	case msg := <-c.sOut:
+		e, ok := msg.(*comms.ErrorMessage)
+		if !ok || e.Code != code {
+			t.Errorf("got %v, expected a %s error", msg, code)
+			return comms.ErrorMessage{}
+		}
+		return *e
+	case <-time.After(time.Second):
+		t.Errorf("got nothing, expected a %s error", code)
+	}
+	return comms.ErrorMessage{}
+}
+
+func TestServerRateLimitsSessions(t *testing.T) {
+	// Given a server that allows one message at a time per session
+	connect := setupLimits(Limits{SessionMessages: Rate{PerSecond: 0.01, Burst: 1}})
+	alice := connect()
+
+	// When a client sends two changes in quick succession
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "a"}}
+	if msg := <-alice.sOut; msg.Kind() != comms.ACK_CHANGE {
+		t.Fatalf("Alice got %v, expected her first change to be acknowledged", msg)
+	}
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "b"}}
+
+	// Then the second should be rejected
+	if e := expectError(t, alice, comms.RATE_LIMITED); !e.Rejected() {
+		t.Errorf("expected %v to reject the change", e)
+	}
+
+	// When the client sends some other request
+	alice.sIn <- comms.FetchDocument{}
+
+	// Then it should be refused without being taken for a rejected change
+	if e := expectError(t, alice, comms.RATE_LIMITED); e.Rejected() {
+		t.Errorf("expected %v not to reject a change", e)
+	}
+}
+
+func TestServerRateLimitsAddresses(t *testing.T) {
+	// Given a server that allows one message at a time per address
+	connect := setupLimits(Limits{IPMessages: Rate{PerSecond: 0.01, Burst: 1}})
+	alice, bob := connect(), connect()
+
+	// When two clients at the same address each send a change
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-bob.sOut
+	if msg := <-alice.sOut; msg.Kind() != comms.ACK_CHANGE {
+		t.Fatalf("Alice got %v, expected her change to be acknowledged", msg)
+	}
+	bob.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "world"}}
+
+	// Then the second should be rejected
+	expectError(t, bob, comms.RATE_LIMITED)
+}
+
+func TestServerChargesSessionsOnlyForAllowedMessages(t *testing.T) {
+	// Given a server that allows a session two messages, but its address
+	// only one at a time
+	connect := setupLimits(Limits{
+		SessionMessages: Rate{PerSecond: 0.01, Burst: 2},
+		IPMessages:      Rate{PerSecond: 10, Burst: 1},
+	})
+	alice := connect()
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "a"}}
+	<-alice.sOut
+
+	// When a change is refused for its address
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "b"}}
+	expectError(t, alice, comms.RATE_LIMITED)
+
+	// Then the session should still be allowed its second message
+	time.Sleep(200 * time.Millisecond)
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "c"}}
+	if msg := <-alice.sOut; msg.Kind() != comms.ACK_CHANGE {
+		t.Errorf("Alice got %v, expected her change to be acknowledged", msg)
+	}
+}
+
+func TestServerKicksRateLimitedSessions(t *testing.T) {
+	// Given a client being rate limited that hasn't read the errors yet
+	s := &Server{Limits: Limits{SessionMessages: Rate{PerSecond: 0.01, Burst: 1}}}
+	s.Init()
+	go s.Start()
+	alice := new(MockClient)
+	a, b := comms.Pipe()
+	alice.Connect(a)
+	s.Accept(b)
+	for _, text := range []string{"a", "b", "c", "d", "e"} {
+		alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: text}}
+	}
+
+	// When it is kicked
+	kicked := make(chan error)
+	go func() { kicked <- s.Kick(s.Sessions()[0].Id) }()
+
+	// Then it should be told so
+	for msg := range alice.sOut {
+		if e, ok := msg.(*comms.ErrorMessage); ok && e.Code == comms.KICKED {
+			break
+		}
+	}
+	if err := <-kicked; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServerLimitsConnectionsPerDocument(t *testing.T) {
+	// Given a server that allows one participant per document
+	connect := setupLimits(Limits{MaxConnectionsPerDocument: 1})
+	connect()
+
+	// When a second client connects
+	bob := connect()
+
+	// Then it should be turned away
+	expectError(t, bob, comms.TOO_MANY_CONNS)
+}
+
+func TestServerCapsDocumentSize(t *testing.T) {
+	// Given a server that allows documents of five bytes
+	connect := setupLimits(Limits{MaxDocumentSize: 5})
+	alice := connect()
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-alice.sOut
+
+	// When a client tries to make the document longer
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "!", Pos: 5}}
+
+	// Then the change should be rejected
+	expectError(t, alice, comms.TOO_LARGE)
+
+	// Then deleting text should still be allowed
+	alice.sIn <- comms.OpMessage{Op: ot.Deletion{Len: 1}}
+	if msg := <-alice.sOut; msg.Kind() != comms.ACK_CHANGE {
+		t.Errorf("Alice got %v, expected her deletion to be acknowledged", msg)
+	}
+}
+
+func TestServerShutdownTellsClients(t *testing.T) {
+	// Given two clients are connected to the server
+	alice, bob, s, teardown := setupTwoClients()
+	defer teardown()
+
+	// When one client sends a change and the server shuts down
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-alice.sOut
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done <- s.Shutdown(ctx)
+	}()
+
+	// Then the change should still reach the other client
+	if msg := <-bob.sOut; msg.Kind() != comms.BUFFER_OP {
+		t.Fatalf("Bob got %v, expected Alice's change", msg)
+	}
+
+	// Then both clients should be told the server is going away
+	expectError(t, alice, comms.GOING_AWAY)
+	expectError(t, bob, comms.GOING_AWAY)
+
+	// Then the shutdown should complete
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown failed: %s", err)
+	}
+}
+
+func TestServerShutdownStopsServing(t *testing.T) {
+	// Given a server listening for connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := new(Server)
+	s.Init()
+	go s.Start()
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(l)
+	}()
+
+	defer l.Close()
+
+	// When the server shuts down
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then it should stop serving
+	if err := <-served; err != ServerClosedError {
+		t.Errorf("Serve returned %v, expected %v", err, ServerClosedError)
+	}
+
+	// Then new connections should be turned away
+	client := new(MockClient)
+	a, b := comms.Pipe()
+	client.Connect(a)
+	go s.Accept(b)
+	expectError(t, client, comms.GOING_AWAY)
+}
+
+// failingListener fails to accept any connection until it is closed.
+type failingListener struct {
+	net.Listener
+	accepts atomic.Int32
+	closed  atomic.Bool
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.closed.Load() {
+		return nil, net.ErrClosed
+	}
+	l.accepts.Add(1)
+	return nil, errors.New("too many open files")
+}
+
+func (l *failingListener) Close() error {
+	l.closed.Store(true)
+	return nil
+}
+
+func TestServerBacksOffWhenAcceptFails(t *testing.T) {
+	// Given a listener that fails to accept connections
+	l := new(failingListener)
+	defer l.Close()
+	s := new(Server)
+	s.Init()
+
+	// When the server serves it for a while
+	go s.Serve(l)
+	time.Sleep(100 * time.Millisecond)
+
+	// Then it should wait longer each time before trying again
+	if n := l.accepts.Load(); n > 6 {
+		t.Errorf("server tried to accept %d times in 100ms", n)
+	}
+}
+
+func TestServerSendsDocument(t *testing.T) {
+	// Given one client has edited the document
+	alice, bob, _, teardown := setupTwoClients()
+	defer teardown()
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-alice.sOut
+	<-bob.sOut
+	alice.sIn <- comms.OpMessage{Op: ot.Deletion{Pos: 4, Len: 1}}
+	<-alice.sOut
+	<-bob.sOut
+
+	// When another client asks for the document
+	bob.sIn <- comms.FetchDocument{}
+
+	// Then it should be sent the server's copy
+	want := comms.Document{Text: "hell", Rev: 2}
+	if got, ok := (<-bob.sOut).(*comms.Document); !ok || *got != want {
+		t.Errorf("Bob got %v, expected %v", got, want)
+	}
+}
+
+func TestServerLoadsDocumentsFromStore(t *testing.T) {
+	// Given a document was saved by an earlier server
+	dir, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	dir.Append("notes", store.Change{Op: ot.Insertion{Text: "hello"}})
+
+	// When a client opens it and makes a change
+	s := &Server{Auth: auth.Keys{Secret: "s3cret"}, Store: dir}
+	s.Init()
+	go s.Start()
+	alice := new(MockClient)
+	a, b := comms.Pipe()
+	alice.Connect(a)
+	s.Accept(b)
+	alice.sIn <- comms.Authenticate{Document: "notes", Secret: "s3cret"}
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Pos: 5, Text: "!"}}
+	<-alice.sOut
+	alice.sIn <- comms.FetchDocument{}
+
+	// Then the client should see the saved text with its change
+	want := comms.Document{Text: "hello!", Rev: 2}
+	if got, ok := (<-alice.sOut).(*comms.Document); !ok || *got != want {
+		t.Errorf("Alice got %v, expected %v", got, want)
+	}
+
+	// Then the change should have been saved too
+	if ops, err := dir.Load("notes"); err != nil || len(ops) != 2 {
+		t.Errorf("store holds %v, %v, expected two changes", ops, err)
+	}
+}
+
+// A fullStore can't save changes.
+type fullStore struct {
+	store.Store
+}
+
+func (fullStore) Append(string, store.Change) error {
+	return errors.New("no space left on device")
+}
+
+func TestServerRejectsChangesItCannotSave(t *testing.T) {
+	// Given a server whose store can't save changes
+	dir, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	s := &Server{Store: fullStore{dir}}
+	s.Init()
+	go s.Start()
+	alice := new(MockClient)
+	a, b := comms.Pipe()
+	alice.Connect(a)
+	s.Accept(b)
+
+	// When a client makes a change
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+
+	// Then the change should be rejected
+	if e := expectError(t, alice, comms.INTERNAL); !e.Rejected() {
+		t.Errorf("expected %v to reject the change", e)
+	}
+}
+
+func TestServerLoadsDocumentsFromSnapshots(t *testing.T) {
+	// Given a saved document has a snapshot and a change made after it
+	dir, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	dir.Append("notes", store.Change{Op: ot.Insertion{Text: "hello"}})
+	dir.Snapshot("notes", "HELLO", 1)
+	dir.Append("notes", store.Change{Op: ot.Insertion{Pos: 5, Text: " world"}})
+
+	// When a client fetches it
+	s := &Server{Auth: auth.Keys{Secret: "s3cret"}, Store: dir}
+	s.Init()
+	go s.Start()
+	alice := new(MockClient)
+	a, b := comms.Pipe()
+	defer a.Close()
+	alice.Connect(a)
+	s.Accept(b)
+	alice.sIn <- comms.Authenticate{Document: "notes", Secret: "s3cret"}
+	alice.sIn <- comms.FetchDocument{}
+
+	// Then the change should be applied to the snapshot
+	want := comms.Document{Text: "HELLO world", Rev: 2}
+	if got, ok := (<-alice.sOut).(*comms.Document); !ok || *got != want {
+		t.Errorf("Alice got %v, expected %v", got, want)
+	}
+}
+
+func TestServerReloadsRolesAndLimits(t *testing.T) {
+	// Given an editor has joined
+	s, connect := setupRoles(t, nil)
+	bob := connect("bob")
+	bob.sIn <- comms.JoinSession{}
+	roster := (<-bob.sOut).(*comms.Roster)
+
+	// When the server is reloaded with an ACL making them a viewer
+	s.Reload(Config{
+		Auth:   s.Auth,
+		ACL:    map[string]map[string]comms.Role{"notes": {"bob": comms.VIEWER}},
+		Limits: Limits{SessionMessages: Rate{PerSecond: 0.01, Burst: 1}},
+	})
+
+	// Then they should be told about the change without being disconnected
+	want := comms.RoleChanged{Id: roster.Self, Role: comms.VIEWER}
+	if got, ok := (<-bob.sOut).(*comms.RoleChanged); !ok || *got != want {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+
+	// Then the new limits should apply to their session
+	bob.sIn <- comms.FetchDocument{}
+	<-bob.sOut
+	bob.sIn <- comms.FetchDocument{}
+	expectError(t, bob, comms.RATE_LIMITED)
+}
+
+// logBuffer collects log output from several goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the lines logged so far that contain every one of substrs.
+func (b *logBuffer) lines(substrs ...string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	for _, l := range strings.Split(b.buf.String(), "\n") {
+		if !slices.ContainsFunc(substrs, func(s string) bool { return !strings.Contains(l, s) }) {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func TestServerLogsMessagesOfDebuggedSessions(t *testing.T) {
+	// Given a server logging at info level, debugging Bob's sessions
+	s, connect := setupRoles(t, nil)
+	var logs logBuffer
+	s.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	s.Debug = []string{"bob"}
+	alice, bob := connect("alice"), connect("bob")
+
+	// When both join
+	alice.sIn <- comms.JoinSession{}
+	<-alice.sOut
+	<-bob.sOut
+	bob.sIn <- comms.JoinSession{}
+	<-alice.sOut
+	<-bob.sOut
+
+	// Then only Bob's messages should be logged
+	if got := logs.lines("received message", "kind=join_session", "user=bob"); len(got) != 1 {
+		t.Errorf("logged %q for Bob", got)
+	}
+	if got := logs.lines("received message", "user=alice"); len(got) != 0 {
+		t.Errorf("logged %q for Alice", got)
+	}
+	if got := logs.lines("session opened", "document=notes", "user=alice"); len(got) != 1 {
+		t.Errorf("expected Alice's session to be logged, got %q", got)
+	}
+}
+
+func TestServerReportsMetrics(t *testing.T) {
+	// Given two clients are connected
+	alice, bob, s, teardown := setupTwoClients()
+	defer teardown()
+
+	// When one sends a change and the other receives it
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-alice.sOut
+	<-bob.sOut
+
+	// Then the metrics should count them
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		"shed_sessions 2\n",
+		"shed_documents_open 1\n",
+		"shed_ops_received_total 1\n",
+		"shed_ops_broadcast_total 1\n",
+		`shed_messages_received_total{kind="buffer_op"} 1` + "\n",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics don't include %q:\n%s", want, rec.Body)
+		}
+	}
+}
+
+func TestServerSendsHistory(t *testing.T) {
+	// Given two users have changed a document
+	_, connect := setupRoles(t, nil)
+	alice, bob := connect("alice"), connect("bob")
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-alice.sOut
+	<-bob.sOut
+	bob.sIn <- comms.OpMessage{Op: ot.Insertion{Pos: 5, Text: " world"}}
+	<-bob.sOut
+	<-alice.sOut
+
+	// When the history after the first revision is fetched
+	alice.sIn <- comms.FetchHistory{From: 1}
+
+	// Then it should list the second change with its author
+	h, ok := (<-alice.sOut).(*comms.History)
+	if !ok || h.Rev != 2 || len(h.Changes) != 1 {
+		t.Fatalf("got %+v", h)
+	}
+	c := h.Changes[0]
+	if c.Rev != 2 || c.Author != "bob" || c.Op != (ot.Insertion{Pos: 5, Text: " world"}) || c.Time.IsZero() {
+		t.Errorf("got %+v", c)
+	}
+
+	// When the history is fetched with a limit too large to add to
+	alice.sIn <- comms.FetchHistory{From: 1, Limit: math.MaxInt}
+
+	// Then it should still list the second change
+	if h, ok := (<-alice.sOut).(*comms.History); !ok || len(h.Changes) != 1 {
+		t.Errorf("got %+v", h)
+	}
+}
+
+func TestServerSendsRevisions(t *testing.T) {
+	// Given a document has been changed twice
+	_, connect := setupRoles(t, nil)
+	alice := connect("alice")
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-alice.sOut
+	between := time.Now()
+	time.Sleep(time.Millisecond)
+	alice.sIn <- comms.OpMessage{Op: ot.Deletion{Pos: 1, Len: 4}}
+	<-alice.sOut
+
+	// When it is fetched as it was at the first revision, and before the
+	// second change
+	// Then both should be the text after the first change
+	for _, req := range []comms.FetchRevision{{Rev: 1}, {Time: between}} {
+		alice.sIn <- req
+		if got, ok := (<-alice.sOut).(*comms.Revision); !ok || got.Text != "hello" || got.Rev != 1 {
+			t.Errorf("%+v got %+v", req, got)
+		}
+	}
+
+	// When a revision that doesn't exist is fetched
+	alice.sIn <- comms.FetchRevision{Rev: 3}
+
+	// Then the server should say so
+	expectError(t, alice, comms.NO_SUCH_REV)
+}
+
+func TestServerSendsDiffs(t *testing.T) {
+	// Given a document has been changed three times
+	_, connect := setupRoles(t, nil)
+	alice := connect("alice")
+	for _, op := range []ot.Operation{
+		ot.Insertion{Text: "hello"},
+		ot.Insertion{Pos: 5, Text: " world"},
+		ot.Deletion{Pos: 0, Len: 6},
+	} {
+		alice.sIn <- comms.OpMessage{Op: op}
+		<-alice.sOut
+	}
+
+	// When the diff between the first and last revisions is fetched
+	alice.sIn <- comms.FetchDiff{From: 1, To: 3}
+
+	// Then it should start from the first revision's text, with the changes
+	// since
+	d, ok := (<-alice.sOut).(*comms.Diff)
+	if !ok || d.Text != "hello" || len(d.Changes) != 2 || d.Changes[0].Rev != 2 || d.Changes[1].Rev != 3 {
+		t.Errorf("got %+v", d)
+	}
+}
+
+func TestServerKeepsCheckpoints(t *testing.T) {
+	// Given a document with a checkpoint, saved by an earlier server
+	dir, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	dir.Append("notes", store.Change{Op: ot.Insertion{Text: "hello"}})
+	dir.SaveCheckpoints("notes", []store.Checkpoint{{Name: "draft", Rev: 1}})
+	s := &Server{Auth: auth.Keys{Secret: "s3cret"}, Store: dir}
+	s.Init()
+	go s.Start()
+	alice := new(MockClient)
+	a, b := comms.Pipe()
+	alice.Connect(a)
+	s.Accept(b)
+	alice.sIn <- comms.Authenticate{Document: "notes", Secret: "s3cret"}
+
+	// When another revision is checkpointed
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Pos: 5, Text: "!"}}
+	<-alice.sOut
+	alice.sIn <- comms.CreateCheckpoint{Name: "final"}
+
+	// Then both checkpoints should be listed and saved
+	got, ok := (<-alice.sOut).(*comms.Checkpoints)
+	if !ok || len(got.Checkpoints) != 2 || got.Checkpoints[0].Rev != 1 ||
+		got.Checkpoints[1].Name != "final" || got.Checkpoints[1].Rev != 2 {
+		t.Errorf("Alice got %+v, expected draft at 1 and final at 2", got)
+	}
+	if saved, err := dir.LoadCheckpoints("notes"); err != nil || len(saved) != 2 {
+		t.Errorf("store holds %v, %v, expected two checkpoints", saved, err)
+	}
+
+	// When a checkpoint is made without a name
+	alice.sIn <- comms.CreateCheckpoint{}
+
+	// Then it should be refused
+	expectError(t, alice, comms.INVALID)
+}
+
+func TestServerRevertsDocuments(t *testing.T) {
+	// Given two editors and a viewer, and a document changed after a
+	// checkpoint
+	_, connect := setupRoles(t, map[string]comms.Role{"carol": comms.VIEWER})
+	alice, bob, carol := connect("alice"), connect("bob"), connect("carol")
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "the cat sat"}}
+	<-alice.sOut
+	<-bob.sOut
+	<-carol.sOut
+	alice.sIn <- comms.CreateCheckpoint{Name: "draft"}
+	<-alice.sOut
+	alice.sIn <- comms.OpMessage{Op: ot.Deletion{Pos: 4, Len: 3}}
+	<-alice.sOut
+	<-bob.sOut
+	<-carol.sOut
+
+	// When one editor reverts to the checkpoint
+	bob.sIn <- comms.Revert{Checkpoint: "draft"}
+
+	// Then every session should receive changes restoring the text
+	for _, c := range []*MockClient{alice, bob, carol} {
+		text := "the  sat"
+		for {
+			m := <-c.sOut
+			op, ok := comms.AsOp(m)
+			if !ok {
+				if r, ok := m.(*comms.Reverted); c != bob || !ok || r.To != 1 || r.Rev != 3 {
+					t.Errorf("got %+v, expected the revert to be acknowledged", m)
+				}
+				break
+			}
+			text = op.Apply(text)
+			if c != bob && text == "the cat sat" {
+				break
+			}
+		}
+		if text != "the cat sat" {
+			t.Errorf("got %q after reverting", text)
+		}
+	}
+
+	// When the viewer tries to revert
+	carol.sIn <- comms.Revert{Rev: 0}
+
+	// Then it should be refused
+	expectError(t, carol, comms.FORBIDDEN)
+
+	// When a checkpoint that doesn't exist is reverted to
+	bob.sIn <- comms.Revert{Checkpoint: "final"}
+
+	// Then the server should say so
+	expectError(t, bob, comms.NO_SUCH_REV)
+}
+
+func TestServerRevertsAllOrNothing(t *testing.T) {
+	// Given a document that has lost two letters since it was first written
+	s, connect := setupRoles(t, nil)
+	alice := connect("alice")
+	for _, op := range []ot.Operation{
+		ot.Insertion{Text: "abcdefgh"},
+		ot.Deletion{Pos: 6, Len: 1},
+		ot.Deletion{Pos: 1, Len: 1},
+	} {
+		alice.sIn <- comms.OpMessage{Op: op}
+		<-alice.sOut
+	}
+
+	// When the size limit leaves room for only one of them back, and the
+	// document is reverted
+	s.Reload(Config{Auth: s.Auth, Limits: Limits{MaxDocumentSize: 7}})
+	alice.sIn <- comms.Revert{Rev: 1}
+
+	// Then the revert should be refused, without changing the document
+	expectError(t, alice, comms.TOO_LARGE)
+	alice.sIn <- comms.FetchDocument{}
+	want := comms.Document{Text: "acdefh", Rev: 3}
+	if got, ok := (<-alice.sOut).(*comms.Document); !ok || *got != want {
+		t.Errorf("Alice got %v, expected %v", got, want)
+	}
+
+	// When the document is locked and reverted
+	if err := s.Lock("notes", true); err != nil {
+		t.Fatal(err)
+	}
+	alice.sIn <- comms.Revert{Rev: 0}
+
+	// Then the revert should be refused as changes are
+	expectError(t, alice, comms.READ_ONLY)
+}
+
+func TestAttributionFollowsChanges(t *testing.T) {
+	cases := []struct {
+		changes []store.Change
+		want    []comms.Span
+	}{
+		{
+			changes: []store.Change{
+				{Op: ot.Insertion{Text: "hello world"}, Author: "alice"},
+				{Op: ot.Insertion{Pos: 5, Text: " there"}, Author: "bob"},
+			},
+			want: []comms.Span{
+				{Pos: 0, Len: 5, Author: "alice", Rev: 1},
+				{Pos: 5, Len: 6, Author: "bob", Rev: 2},
+				{Pos: 11, Len: 6, Author: "alice", Rev: 1},
+			},
+		},
+		{
+			changes: []store.Change{
+				{Op: ot.Insertion{Text: "hello"}, Author: "alice"},
+				{Op: ot.Insertion{Pos: 5, Text: " world"}, Author: "bob"},
+				{Op: ot.Deletion{Pos: 3, Len: 5}, Author: "carol"},
+			},
+			want: []comms.Span{
+				{Pos: 0, Len: 3, Author: "alice", Rev: 1},
+				{Pos: 3, Len: 3, Author: "bob", Rev: 2},
+			},
+		},
+		{
+			changes: []store.Change{
+				{Op: ot.Insertion{Text: "abc"}, Author: "alice"},
+				{Op: ot.Insertion{Pos: 1, Text: "x"}, Author: "bob"},
+				{Op: ot.Deletion{Pos: 1, Len: 1}, Author: "bob"},
+				{Op: ot.Deletion{Pos: 10, Len: 4}, Author: "bob"},
+			},
+			want: []comms.Span{
+				{Pos: 0, Len: 3, Author: "alice", Rev: 1},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		if got := attribute(c.changes).spans(); !slices.Equal(got, c.want) {
+			t.Errorf("%v attributed to %v, expected %v", c.changes, got, c.want)
+		}
+	}
+}
+
+func TestServerBlamesAuthors(t *testing.T) {
+	// Given two editors have changed a document
+	_, connect := setupRoles(t, nil)
+	alice, bob := connect("alice"), connect("bob")
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello world"}}
+	<-alice.sOut
+	<-bob.sOut
+	bob.sIn <- comms.OpMessage{Op: ot.Deletion{Pos: 5, Len: 6}}
+	<-bob.sOut
+	<-alice.sOut
+	bob.sIn <- comms.OpMessage{Op: ot.Insertion{Pos: 5, Text: "!"}}
+	<-bob.sOut
+	<-alice.sOut
+
+	// When one asks who wrote it
+	alice.sIn <- comms.FetchBlame{}
+
+	// Then each part should be attributed to its author
+	want := []comms.Span{
+		{Pos: 0, Len: 5, Author: "alice", Rev: 1},
+		{Pos: 5, Len: 1, Author: "bob", Rev: 3},
+	}
+	if got, ok := (<-alice.sOut).(*comms.Blame); !ok || got.Text != "hello!" || got.Rev != 3 || !slices.Equal(got.Spans, want) {
+		t.Errorf("Alice got %+v, expected %v", got, want)
+	}
+}
+
+func TestServerManagesDocuments(t *testing.T) {
+	// Given an editor on one document of a project, and two others listing
+	// the project's documents, one of whom owns them and one of whom can
+	// only view them
+	key := []byte("key")
+	s := &Server{
+		Auth: auth.Keys{TokenKey: key},
+		ACL: map[string]map[string]comms.Role{
+			"proj/a": {"bob": comms.OWNER, "carol": comms.VIEWER},
+			"proj/b": {"bob": comms.OWNER},
+		},
+	}
+	s.Init()
+	go s.Start()
+	connect := func(name, doc string) *MockClient {
+		a, b := comms.Pipe()
+		t.Cleanup(func() { a.Close() })
+		s.Accept(b)
+		token := auth.IssueToken(key, auth.Identity{Name: name}, time.Time{})
+		a.Send(comms.Authenticate{Document: doc, Token: token})
+		c := new(MockClient)
+		c.Connect(a)
+		return c
+	}
+	expectDocuments := func(c *MockClient, want ...string) {
+		t.Helper()
+		if got, ok := (<-c.sOut).(*comms.Documents); !ok || !slices.Equal(got.Names, want) {
+			t.Errorf("got %+v, expected %q", got, want)
+		}
+	}
+	expectEvent := func(c *MockClient, want comms.DocumentEvent) {
+		t.Helper()
+		if got, ok := (<-c.sOut).(*comms.DocumentEvent); !ok || *got != want {
+			t.Errorf("got %+v, expected %+v", got, want)
+		}
+	}
+	alice := connect("alice", "proj/a")
+	alice.sIn <- comms.OpMessage{Op: ot.Insertion{Text: "hello"}}
+	<-alice.sOut
+	bob, carol := connect("bob", "proj"), connect("carol", "proj")
+	bob.sIn <- comms.ListDocuments{Prefix: "proj/"}
+	expectDocuments(bob, "proj/a")
+	carol.sIn <- comms.ListDocuments{Prefix: "proj/"}
+	expectDocuments(carol, "proj/a")
+
+	// When a document is created
+	bob.sIn <- comms.CreateDocument{Name: "proj/b"}
+
+	// Then it should be listed, and the others told
+	expectDocuments(bob, "proj/a", "proj/b")
+	expectEvent(carol, comms.DocumentEvent{Action: comms.CREATED, Name: "proj/b", Author: "bob"})
+
+	// When it is created again, or a viewer deletes a document
+	bob.sIn <- comms.CreateDocument{Name: "proj/b"}
+	carol.sIn <- comms.DeleteDocument{Name: "proj/a"}
+
+	// Then they should be refused
+	expectError(t, bob, comms.EXISTS)
+	expectError(t, carol, comms.FORBIDDEN)
+
+	// When an editor who doesn't own a document deletes or renames it
+	alice.sIn <- comms.DeleteDocument{Name: "proj/a"}
+	alice.sIn <- comms.RenameDocument{From: "proj/a", To: "proj/d"}
+
+	// Then they should be refused too
+	expectError(t, alice, comms.FORBIDDEN)
+	expectError(t, alice, comms.FORBIDDEN)
+
+	// When a document being edited is renamed
+	bob.sIn <- comms.RenameDocument{From: "proj/a", To: "proj/c"}
+
+	// Then its editor should be disconnected, and the others told
+	expectDocuments(bob, "proj/b", "proj/c")
+	expectError(t, alice, comms.KICKED)
+	expectEvent(carol, comms.DocumentEvent{Action: comms.RENAMED, Name: "proj/a", To: "proj/c", Author: "bob"})
+	if doc, err := s.Text("proj/c"); err != nil || doc.Text != "hello" {
+		t.Errorf("proj/c holds %+v, %v", doc, err)
+	}
+
+	// When documents are deleted
+	bob.sIn <- comms.DeleteDocument{Name: "proj/b"}
+	bob.sIn <- comms.DeleteDocument{Name: "proj/b"}
+
+	// Then the others should be told, and a missing one refused
+	expectDocuments(bob, "proj/c")
+	expectError(t, bob, comms.NO_SUCH_DOC)
+	expectEvent(carol, comms.DocumentEvent{Action: comms.DELETED, Name: "proj/b", Author: "bob"})
+}
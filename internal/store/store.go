@@ -0,0 +1,376 @@
+// Package store keeps the changes made to documents, so that they survive the
+// server restarting.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+// A Change is an operation made to a document, with who made it and when.
+type Change struct {
+	Op     ot.Operation
+	Author string
+	Time   time.Time
+}
+
+type change struct {
+	Op     json.RawMessage `json:"op"`
+	Author string          `json:"author,omitempty"`
+	Time   time.Time       `json:"time,omitzero"`
+}
+
+func (c Change) MarshalJSON() ([]byte, error) {
+	op, err := json.Marshal(c.Op)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(change{Op: op, Author: c.Author, Time: c.Time})
+}
+
+// UnmarshalJSON reads a change, or a bare operation as logged before authors
+// and times were recorded.
+func (c *Change) UnmarshalJSON(data []byte) error {
+	var w change
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	if w.Op == nil {
+		op, err := ot.Unmarshal(data)
+		*c = Change{Op: op}
+		return err
+	}
+	op, err := ot.Unmarshal(w.Op)
+	if err != nil {
+		return err
+	}
+	*c = Change{Op: op, Author: w.Author, Time: w.Time}
+	return nil
+}
+
+// A Store records every change made to each document.
+type Store interface {
+	// Load returns the changes made to a document so far, oldest first.
+	Load(doc string) ([]Change, error)
+
+	// Append records a change made to a document.
+	Append(doc string, c Change) error
+
+	// Documents lists every document with changes.
+	Documents() ([]string, error)
+
+	// Delete forgets a document and every change made to it.
+	Delete(doc string) error
+
+	// Rename moves a document's changes to a new name, failing if the name
+	// is taken.
+	Rename(from, to string) error
+}
+
+// A Snapshotter is a Store that can also save a document's text, so that
+// loading it needn't replay every change.
+type Snapshotter interface {
+	Store
+
+	// Snapshot saves the text of a document after its first rev changes.
+	Snapshot(doc string, text string, rev int) error
+
+	// LoadSnapshot returns the latest snapshot of a document, or a rev of 0
+	// if there is none.
+	LoadSnapshot(doc string) (text string, rev int, err error)
+}
+
+// A Checkpoint names a revision of a document.
+type Checkpoint struct {
+	Name   string    `json:"name"`
+	Rev    int       `json:"rev"`
+	Author string    `json:"author,omitempty"`
+	Time   time.Time `json:"time,omitzero"`
+}
+
+// A Checkpointer is a Store that can also save a document's checkpoints.
+type Checkpointer interface {
+	Store
+
+	// SaveCheckpoints replaces the checkpoints of a document.
+	SaveCheckpoints(doc string, checkpoints []Checkpoint) error
+
+	// LoadCheckpoints returns the checkpoints of a document, or none if
+	// there are none.
+	LoadCheckpoints(doc string) ([]Checkpoint, error)
+}
+
+// ExistsError is returned when renaming a document to a name that's taken.
+var ExistsError = errors.New("document already exists")
+
+// Replay returns the text of a document after changes, starting from text.
+// Changes are clamped to fit, as the server does when it receives them.
+func Replay(text string, changes []Change) string {
+	for _, c := range changes {
+		text = ot.Clamp(c.Op, uint(len(text))).Apply(text)
+	}
+	return text
+}
+
+// Dir is a Store that logs the changes to each document to its own file in a
+// directory, one JSON change per line.
+type Dir struct {
+	path string
+
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+// Open returns a Dir storing documents at path, creating it if necessary.
+func Open(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path, files: make(map[string]*os.File)}, nil
+}
+
+// name returns the file a document is logged to. Document names are escaped,
+// so they can't refer to files outside the directory.
+func (d *Dir) name(doc string) string {
+	return filepath.Join(d.path, url.PathEscape(doc)+".log")
+}
+
+// snapshotName returns the file a document's snapshot is kept in.
+func (d *Dir) snapshotName(doc string) string {
+	return filepath.Join(d.path, url.PathEscape(doc)+".snapshot")
+}
+
+// checkpointsName returns the file a document's checkpoints are kept in.
+func (d *Dir) checkpointsName(doc string) string {
+	return filepath.Join(d.path, url.PathEscape(doc)+".checkpoints")
+}
+
+// Documents lists every document with changes in the directory.
+func (d *Dir) Documents() ([]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+	var docs []string
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if doc, err := url.PathUnescape(name); err == nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (d *Dir) Load(doc string) ([]Change, error) {
+	f, err := os.Open(d.name(doc))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var changes []Change
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without a newline was cut short by a crash, and was
+			// never acknowledged.
+			return changes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c Change
+		if err := json.Unmarshal(line, &c); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+}
+
+func (d *Dir) Append(doc string, c Change) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, ok := d.files[doc]
+	if !ok {
+		f, err = os.OpenFile(d.name(doc), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		d.files[doc] = f
+	}
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// Delete removes a document's log, snapshot and checkpoints.
+func (d *Dir) Delete(doc string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.closeLog(doc); err != nil {
+		return err
+	}
+	for _, name := range []string{d.name(doc), d.snapshotName(doc), d.checkpointsName(doc)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dir) Rename(from, to string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := os.Lstat(d.name(to)); err == nil {
+		return ExistsError
+	}
+	if err := d.closeLog(from); err != nil {
+		return err
+	}
+	// Documents opened but never changed have no log to move.
+	for _, name := range []func(string) string{d.name, d.snapshotName, d.checkpointsName} {
+		if err := os.Rename(name(from), name(to)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeLog syncs and closes a document's log if it is open. The caller must
+// hold d.mu.
+func (d *Dir) closeLog(doc string) error {
+	f, ok := d.files[doc]
+	if !ok {
+		return nil
+	}
+	delete(d.files, doc)
+	return errors.Join(f.Sync(), f.Close())
+}
+
+type snapshot struct {
+	Rev  int    `json:"rev"`
+	Text string `json:"text"`
+}
+
+// Snapshot saves a document's text once the changes it includes are on disk.
+// The snapshot replaces the previous one all at once, so a crash leaves one or
+// the other.
+func (d *Dir) Snapshot(doc string, text string, rev int) error {
+	b, err := json.Marshal(snapshot{Rev: rev, Text: text})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.files[doc]; ok {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return d.replace(d.snapshotName(doc), b)
+}
+
+// replace writes b to the file name all at once, by way of a temporary file.
+func (d *Dir) replace(name string, b []byte) error {
+	tmp, err := os.CreateTemp(d.path, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := errors.Join(tmp.Sync(), tmp.Close()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (d *Dir) LoadSnapshot(doc string) (text string, rev int, err error) {
+	b, err := os.ReadFile(d.snapshotName(doc))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return "", 0, err
+	}
+	return snap.Text, snap.Rev, nil
+}
+
+// SaveCheckpoints replaces a document's checkpoints all at once, like
+// Snapshot.
+func (d *Dir) SaveCheckpoints(doc string, checkpoints []Checkpoint) error {
+	b, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.replace(d.checkpointsName(doc), b)
+}
+
+func (d *Dir) LoadCheckpoints(doc string) ([]Checkpoint, error) {
+	b, err := os.ReadFile(d.checkpointsName(doc))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []Checkpoint
+	if err := json.Unmarshal(b, &checkpoints); err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
+
+// Sync flushes every log to disk. Until then, changes may be lost if the
+// machine crashes, though not if only the server does.
+func (d *Dir) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for _, f := range d.files {
+		errs = append(errs, f.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// Close flushes every log to disk and closes it.
+func (d *Dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for doc := range d.files {
+		errs = append(errs, d.closeLog(doc))
+	}
+	return errors.Join(errs...)
+}
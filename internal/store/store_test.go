@@ -0,0 +1,188 @@
+package store
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/ot"
+)
+
+func TestDirReplaysChanges(t *testing.T) {
+	// Given changes were appended to a document
+	path := t.TempDir()
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Op: ot.Insertion{Text: "hello"}, Author: "alice", Time: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)},
+		{Op: ot.Deletion{Pos: 1, Len: 2}},
+	}
+	for _, c := range want {
+		if err := d.Append("notes/todo", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+
+	// When the document is loaded again
+	d, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Load("notes/todo")
+
+	// Then the same changes should be returned in order
+	if err != nil || !slices.EqualFunc(got, want, func(a, b Change) bool {
+		return a.Op == b.Op && a.Author == b.Author && a.Time.Equal(b.Time)
+	}) {
+		t.Errorf("Load returned %v, %v, expected %v", got, err, want)
+	}
+}
+
+func TestDirLoadsBareOperations(t *testing.T) {
+	// Given a log written before changes recorded their author
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(d.name("doc"), []byte(`{"type":"insertion","pos":0,"text":"hello"}`+"\n"), 0o600)
+
+	// When the document is loaded
+	got, err := d.Load("doc")
+
+	// Then the operation should be returned without an author
+	if want := (Change{Op: ot.Insertion{Text: "hello"}}); err != nil || len(got) != 1 || got[0] != want {
+		t.Errorf("Load returned %v, %v, expected %v", got, err, want)
+	}
+}
+
+func TestDirIgnoresTornWrites(t *testing.T) {
+	// Given a log whose last change was cut short
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Append("doc", Change{Op: ot.Insertion{Text: "hello"}})
+	d.Close()
+	f, err := os.OpenFile(d.name("doc"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"pos":0,"te`)
+	f.Close()
+
+	// When the document is loaded
+	got, err := d.Load("doc")
+
+	// Then only the complete change should be returned
+	if err != nil || len(got) != 1 {
+		t.Errorf("Load returned %v, %v, expected one change", got, err)
+	}
+}
+
+func TestDirLoadsMissingDocumentsAsEmpty(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.Load("new"); err != nil || len(got) != 0 {
+		t.Errorf("Load returned %v, %v, expected nothing", got, err)
+	}
+}
+
+func TestDirListsDocuments(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, doc := range []string{"a", "notes/todo", ""} {
+		d.Append(doc, Change{Op: ot.Insertion{Text: "x"}})
+	}
+
+	got, err := d.Documents()
+	slices.Sort(got)
+	if want := []string{"", "a", "notes/todo"}; err != nil || !slices.Equal(got, want) {
+		t.Errorf("Documents returned %q, %v, expected %q", got, err, want)
+	}
+}
+
+func TestDirRenamesAndDeletesDocuments(t *testing.T) {
+	// Given two documents, one with a snapshot and a checkpoint
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.Append("a", Change{Op: ot.Insertion{Text: "hello"}})
+	d.Snapshot("a", "hello", 1)
+	d.SaveCheckpoints("a", []Checkpoint{{Name: "first", Rev: 1}})
+	d.Append("b", Change{Op: ot.Insertion{Text: "world"}})
+
+	// When one is renamed over the other
+	// Then it should be refused
+	if err := d.Rename("a", "b"); err != ExistsError {
+		t.Errorf("Rename returned %v, expected ExistsError", err)
+	}
+
+	// When one is renamed to a new name and the other deleted
+	if err := d.Rename("a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then only the renamed document should remain, with its snapshot and
+	// checkpoint
+	if got, err := d.Documents(); err != nil || !slices.Equal(got, []string{"c"}) {
+		t.Errorf("Documents returned %q, %v, expected [c]", got, err)
+	}
+	if ops, err := d.Load("c"); err != nil || Replay("", ops) != "hello" {
+		t.Errorf("Load returned %v, %v", ops, err)
+	}
+	if text, rev, err := d.LoadSnapshot("c"); err != nil || text != "hello" || rev != 1 {
+		t.Errorf("LoadSnapshot returned %q, %d, %v", text, rev, err)
+	}
+	if cs, err := d.LoadCheckpoints("c"); err != nil || len(cs) != 1 || cs[0].Name != "first" {
+		t.Errorf("LoadCheckpoints returned %v, %v", cs, err)
+	}
+
+	// Then it should still take changes
+	if err := d.Append("c", Change{Op: ot.Insertion{Pos: 5, Text: "!"}}); err != nil {
+		t.Fatal(err)
+	}
+	if ops, _ := d.Load("c"); Replay("", ops) != "hello!" {
+		t.Errorf("got %q after renaming", Replay("", ops))
+	}
+
+	// When a document that was never changed is renamed
+	// Then there should be nothing to move
+	if err := d.Rename("empty", "d"); err != nil {
+		t.Errorf("renaming an empty document returned %v", err)
+	}
+}
+
+func TestDirLoadsMissingSnapshotsAsEmpty(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, rev, err := d.LoadSnapshot("new"); err != nil || text != "" || rev != 0 {
+		t.Errorf("LoadSnapshot returned %q, %d, %v, expected nothing", text, rev, err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	changes := []Change{
+		{Op: ot.Insertion{Pos: 5, Text: "!"}},
+		{Op: ot.Insertion{Pos: 9, Text: "?"}},
+		{Op: ot.Deletion{Pos: 0, Len: 1}},
+	}
+	if got := Replay("hello", changes); got != "ello!?" {
+		t.Errorf("Replay returned %q, expected %q", got, "ello!?")
+	}
+}
@@ -0,0 +1,175 @@
+package tlsconf_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/tlsconf"
+)
+
+type keyPair struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// generate writes a certificate for name to dir, signed by parent or
+// self-signed if parent is nil.
+func generate(t *testing.T, dir, name string, parent *keyPair) *keyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         parent == nil,
+
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	kp := &keyPair{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	os.WriteFile(kp.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(kp.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	return kp
+}
+
+// handshake connects a client and server over loopback and returns the peer
+// the server sees.
+func handshake(t *testing.T, server, client *tls.Config) (comms.Peer, error) {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var peer comms.Peer
+	var serr, cerr error
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		c, err := l.Accept()
+		if err != nil {
+			serr = err
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		serr = c.(*tls.Conn).Handshake()
+		peer = comms.NewStream(c).Remote()
+	})
+	wg.Go(func() {
+		c, err := tls.Dial("tcp", l.Addr().String(), client)
+		if err != nil {
+			cerr = err
+			return
+		}
+		defer c.Close()
+		// Servers verify client certificates after the client has finished
+		// its side of the handshake, so wait to hear the outcome.
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+			cerr = err
+		}
+	})
+	wg.Wait()
+	return peer, errors.Join(cerr, serr)
+}
+
+func TestPinnedSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	server := generate(t, dir, "localhost", nil)
+	sconf, err := tlsconf.Server(server.certFile, server.keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cconf, _ := tlsconf.Client("localhost", "", "", "", tlsconf.Pin(server.cert))
+	if _, err := handshake(t, sconf, cconf); err != nil {
+		t.Errorf("handshake with pinned certificate failed: %s", err)
+	}
+
+	other := generate(t, dir, "other", nil)
+	cconf, _ = tlsconf.Client("localhost", "", "", "", tlsconf.Pin(other.cert))
+	if _, err := handshake(t, sconf, cconf); !errors.Is(err, tlsconf.PinMismatchError) {
+		t.Errorf("expected PinMismatchError, got %v", err)
+	}
+}
+
+func TestUnpinnedSelfSignedCertificateIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	server := generate(t, dir, "localhost", nil)
+	sconf, _ := tlsconf.Server(server.certFile, server.keyFile, "")
+
+	cconf, _ := tlsconf.Client("localhost", "", "", "", "")
+	if _, err := handshake(t, sconf, cconf); err == nil {
+		t.Errorf("client accepted an untrusted certificate")
+	}
+
+	cconf, _ = tlsconf.Client("localhost", server.certFile, "", "", "")
+	if _, err := handshake(t, sconf, cconf); err != nil {
+		t.Errorf("client rejected a certificate from its CA file: %s", err)
+	}
+}
+
+func TestClientCertificateIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := generate(t, dir, "ca", nil)
+	server := generate(t, dir, "localhost", ca)
+	alice := generate(t, dir, "alice", ca)
+
+	sconf, err := tlsconf.Server(server.certFile, server.keyFile, ca.certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cconf, _ := tlsconf.Client("localhost", ca.certFile, alice.certFile, alice.keyFile, "")
+	peer, err := handshake(t, sconf, cconf)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if peer.Name != "alice" {
+		t.Errorf("got peer %+v, expected alice", peer)
+	}
+
+	cconf, _ = tlsconf.Client("localhost", ca.certFile, "", "", "")
+	if _, err := handshake(t, sconf, cconf); err == nil {
+		t.Errorf("server accepted a client without a certificate")
+	}
+}
@@ -0,0 +1,23 @@
+// Package web serves a minimal browser editor that joins sessions over a
+// WebSocket.
+package web
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+	"strconv"
+)
+
+//go:embed editor.html
+var editor []byte
+
+// Handler serves the editor page. The page connects to the WebSocket endpoint
+// at socketPath on the same host.
+func Handler(socketPath string) http.Handler {
+	page := bytes.ReplaceAll(editor, []byte("{{SOCKET_PATH}}"), []byte(strconv.Quote(socketPath)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	})
+}
@@ -0,0 +1,30 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+// Handler upgrades every request to a WebSocket and passes it to accept as a
+// transport carrying one message per frame. Pages served by other hosts may
+// only connect if their origin is listed in allowed, as for Upgrade.
+func Handler(accept func(comms.Transport), allowed []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, allowed)
+		if err != nil {
+			return
+		}
+		accept(comms.NewMessageStream(c))
+	})
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
@@ -0,0 +1,313 @@
+// Package websocket implements enough of RFC 6455 to carry shed messages
+// between browsers and servers, one message per frame.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// acceptGUID is mixed into the client's key to prove the server understood the
+// handshake.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	BadHandshakeError   = errors.New("bad websocket handshake")
+	ProtocolError       = errors.New("websocket protocol error")
+	MessageTooLongError = errors.New("websocket message too long")
+	OriginError         = errors.New("websocket origin not allowed")
+)
+
+// A Conn is a WebSocket connection.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	client bool
+
+	wmu sync.Mutex
+}
+
+// Upgrade completes the server side of a WebSocket handshake. Handshakes from
+// web pages served by another host are refused, unless their origin is listed
+// in allowed, so that other sites can't reach the server through the browsers
+// of people visiting them.
+func Upgrade(w http.ResponseWriter, r *http.Request, allowed []string) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "expected a websocket handshake", http.StatusBadRequest)
+		return nil, BadHandshakeError
+	}
+	if !originAllowed(r, allowed) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, OriginError
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets are not supported", http.StatusInternalServerError)
+		return nil, BadHandshakeError
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", acceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, r: rw.Reader}, nil
+}
+
+// originAllowed reports whether a handshake comes from a page served by the
+// same host, from an origin listed in allowed, given as a URL such as
+// https://example.com or as a host, or from a client other than a browser,
+// which sends no Origin. "*" allows any origin.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) || strings.EqualFold(a, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial opens a WebSocket connection to a ws:// or wss:// URL.
+func Dial(rawURL string, conf *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), map[string]string{"ws": "80", "wss": "443"}[u.Scheme])
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = net.Dial("tcp", host)
+	case "wss":
+		if conf == nil {
+			conf = &tls.Config{ServerName: u.Hostname()}
+		}
+		conn, err = tls.Dial("tcp", host, conf)
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n", u.RequestURI(), u.Host, key)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, BadHandshakeError
+	}
+	return &Conn{conn: conn, r: r, client: true}, nil
+}
+
+// ReadMessage returns the payload of the next text or binary message,
+// answering any pings that arrive first.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, io.EOF
+		case opText, opBinary:
+			if started {
+				return nil, ProtocolError
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, ProtocolError
+			}
+		default:
+			return nil, ProtocolError
+		}
+		if len(msg)+len(payload) > comms.MaxPayloadSize {
+			return nil, MessageTooLongError
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// WriteMessage sends p as a single text frame.
+func (c *Conn) WriteMessage(p []byte) error {
+	return c.writeFrame(opText, p)
+}
+
+// Close tells the peer the connection is closing and closes it.
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, nil)
+	return c.conn.Close()
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		// Clients must mask every frame they send and servers must not.
+		return false, 0, nil, ProtocolError
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(comms.MaxPayloadSize) {
+		return false, 0, nil, MessageTooLongError
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	frame := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xFFFF:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		frame[1] |= 0x80
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
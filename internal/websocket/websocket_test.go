@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shed-protocol/shed/internal/comms"
+	"github.com/shed-protocol/shed/internal/server"
+)
+
+func wsURL(ts *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(msg)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := Dial(wsURL(ts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, want := range []string{"", "hello", strings.Repeat("a", 200), strings.Repeat("b", 70000)} {
+		if err := c.WriteMessage([]byte(want)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("echoed %d bytes, sent %d", len(got), len(want))
+		}
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	ts := httptest.NewServer(Handler(func(comms.Transport) {
+		t.Errorf("accepted a plain HTTP request")
+	}, nil))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, expected %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestUpgradeChecksOrigin(t *testing.T) {
+	// Given a server that lets pages from one other site connect
+	ts := httptest.NewServer(Handler(func(t comms.Transport) { t.Close() }, []string{"https://editor.example.com"}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	for origin, want := range map[string]int{
+		"":                           http.StatusSwitchingProtocols,
+		"http://" + host:             http.StatusSwitchingProtocols,
+		"https://editor.example.com": http.StatusSwitchingProtocols,
+		"https://evil.example.com":   http.StatusForbidden,
+		"null":                       http.StatusForbidden,
+	} {
+		// When a handshake comes from a page with that origin
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		// Then it should only be accepted from the server's own pages, the
+		// allowed site or clients other than browsers
+		if resp.StatusCode != want {
+			t.Errorf("origin %q: got status %d, expected %d", origin, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestReadMessageJoinsFragmentsAndAnswersPings(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// Frames from a browser: "he" (not final), a ping, then "llo" (final).
+	// Each is masked with a zero key to keep the payloads readable.
+	go func() {
+		client.Write([]byte{0x01, 0x82, 0, 0, 0, 0, 'h', 'e'})
+		client.Write([]byte{0x89, 0x81, 0, 0, 0, 0, '!'})
+		pong := make([]byte, 3)
+		bufio.NewReader(client).Read(pong)
+		if pong[0] != 0x8A || pong[2] != '!' {
+			t.Errorf("expected pong, got %x", pong)
+		}
+		client.Write([]byte{0x80, 0x83, 0, 0, 0, 0, 'l', 'l', 'o'})
+	}()
+
+	c := &Conn{conn: server, r: bufio.NewReader(server)}
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello" {
+		t.Errorf("got %q, expected %q", msg, "hello")
+	}
+}
+
+func TestHandlerCarriesServerMessages(t *testing.T) {
+	var s server.Server
+	s.Init()
+	go s.Start()
+	ts := httptest.NewServer(Handler(s.Accept, nil))
+	defer ts.Close()
+
+	dial := func() *Conn {
+		c, err := Dial(wsURL(ts)+"/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	alice, bob := dial(), dial()
+
+	// Browsers send bare JSON messages, one per frame.
+	alice.WriteMessage([]byte(`{"kind":1,"body":{"op":{"type":"insertion","pos":0,"text":"hi"}}}`))
+
+	if got, _ := alice.ReadMessage(); string(got) != `{"kind":2,"body":{}}` {
+		t.Errorf("Alice got %s, expected an acknowledgement", got)
+	}
+	want := `{"kind":1,"body":{"op":{"pos":0,"text":"hi","type":"insertion"}}}`
+	if got, _ := bob.ReadMessage(); string(got) != want {
+		t.Errorf("Bob got %s, expected %s", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package shed
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shed-protocol/shed/internal/admin"
+	"github.com/shed-protocol/shed/internal/auth"
+	"github.com/shed-protocol/shed/internal/server"
+	"github.com/shed-protocol/shed/internal/websocket"
+)
+
+// A Server hosts editing sessions. Set its fields before first use; the zero
+// value accepts anyone as an editor.
+type Server struct {
+	// Secret, if set, lets clients that present it join.
+	Secret string
+
+	// TokenKey, if set, lets clients join with tokens from IssueToken signed
+	// with the same key.
+	TokenKey []byte
+
+	// ACL assigns roles to authenticated users, keyed by document and then by
+	// user name.
+	ACL map[string]map[string]Role
+
+	// DefaultRole is given to anyone else. If empty, they are editors.
+	DefaultRole Role
+
+	// HeartbeatInterval, if set, is how often clients are pinged. Clients
+	// that stay silent for three intervals are disconnected.
+	HeartbeatInterval time.Duration
+
+	// Limits protect the server from clients that connect or send too much.
+	// DefaultLimits suit most servers open to the public.
+	Limits Limits
+
+	// Logger, if set, records what the server does. Every message clients
+	// send and receive is logged at debug level.
+	Logger *slog.Logger
+
+	// Debug lists documents and user names whose clients have every message
+	// logged at info level instead.
+	Debug []string
+
+	// AllowedOrigins lists the web pages served by other hosts that may
+	// connect through WebSocketHandler, by origin, such as
+	// https://example.com, or by host. "*" allows any page.
+	AllowedOrigins []string
+
+	once  sync.Once
+	inner server.Server
+}
+
+type (
+	// Limits bound what clients may ask of a server. Zero fields are unlimited.
+	Limits = server.Limits
+
+	// A Rate allows PerSecond messages or bytes on average, in bursts of up
+	// to Burst.
+	Rate = server.Rate
+)
+
+// DefaultLimits stop a single client from flooding a server without getting
+// in the way of people typing.
+var DefaultLimits = server.DefaultLimits
+
+func (s *Server) init() {
+	s.once.Do(func() {
+		c := s.config()
+		s.inner.Auth, s.inner.ACL, s.inner.DefaultRole = c.Auth, c.ACL, c.DefaultRole
+		s.inner.HeartbeatInterval, s.inner.Limits = c.HeartbeatInterval, c.Limits
+		s.inner.Debug, s.inner.Logger = c.Debug, s.Logger
+		s.inner.Init()
+		go s.inner.Start()
+	})
+}
+
+func (s *Server) config() server.Config {
+	c := server.Config{
+		ACL:               s.ACL,
+		DefaultRole:       s.DefaultRole,
+		HeartbeatInterval: s.HeartbeatInterval,
+		Limits:            s.Limits,
+		Debug:             s.Debug,
+	}
+	if s.Secret != "" || len(s.TokenKey) > 0 {
+		c.Auth = auth.Keys{Secret: s.Secret, TokenKey: s.TokenKey}
+	}
+	return c
+}
+
+// Reload applies changes made to the server's fields since it started,
+// without dropping anyone. Rate limits, debugging and roles granted by the
+// ACL change straight away; the rest apply to clients that join afterwards.
+// The Logger can't be changed.
+func (s *Server) Reload() {
+	s.init()
+	s.inner.Reload(s.config())
+}
+
+// Accept starts a session on t.
+func (s *Server) Accept(t Transport) {
+	s.init()
+	s.inner.Accept(t)
+}
+
+// Serve accepts connections from l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.init()
+	return s.inner.Serve(l)
+}
+
+// Shutdown stops the server, telling connected clients that it is going away
+// and waiting until ctx is done for their connections to close. Serve returns
+// ServerClosedError once Shutdown has been called.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+	return s.inner.Shutdown(ctx)
+}
+
+// ServerClosedError is returned by Serve after Shutdown.
+var ServerClosedError = server.ServerClosedError
+
+// WebSocketHandler returns a handler that accepts WebSocket connections, for
+// mounting in another program's HTTP server. Browsers may only connect from
+// pages served by the same host or listed in AllowedOrigins.
+func (s *Server) WebSocketHandler() http.Handler {
+	s.init()
+	return websocket.Handler(s.inner.Accept, s.AllowedOrigins)
+}
+
+// MetricsHandler returns a handler that serves the server's metrics in the
+// Prometheus text format: sessions and documents open, messages and bytes
+// exchanged, errors sent to clients by code and how long saving takes.
+func (s *Server) MetricsHandler() http.Handler {
+	s.init()
+	return s.inner.MetricsHandler()
+}
+
+// AdminHandler returns a handler serving an HTTP API that lists documents and
+// sessions, fetches, locks, snapshots, renames and deletes documents, and
+// disconnects sessions. Requests must present token as a bearer token. The
+// API is meant for operators, so it should only be reachable by them.
+func (s *Server) AdminHandler(token string) http.Handler {
+	s.init()
+	return admin.Handler(&s.inner, token)
+}
+
+// IssueToken returns a token naming a user, signed with key. The token allows
+// the listed documents, or every document if there are none, and grants role
+// unless it is empty. It expires at the given time, or never if that is zero.
+func IssueToken(key []byte, name string, documents []string, role Role, expires time.Time) string {
+	if len(documents) == 0 {
+		documents = nil
+	}
+	return auth.IssueToken(key, auth.Identity{Name: name, Documents: documents, Role: role}, expires)
+}
@@ -0,0 +1,350 @@
+package shed_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shed-protocol/shed"
+)
+
+func TestDocument(t *testing.T) {
+	d := shed.NewDocument("hello")
+	if err := d.Apply(shed.Insertion{Pos: 5, Text: " world"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Apply(shed.Deletion{Pos: 20, Len: 1}); !errors.Is(err, shed.OutOfRangeError) {
+		t.Errorf("expected OutOfRangeError, got %v", err)
+	}
+	if got := d.Text(); got != "hello world" {
+		t.Errorf("got %q, expected %q", got, "hello world")
+	}
+	if got := d.Revision(); got != 1 {
+		t.Errorf("got revision %d, expected 1", got)
+	}
+}
+
+func connect(t *testing.T, s *shed.Server, c *shed.Client) {
+	t.Helper()
+	a, b := shed.Pipe()
+	s.Accept(a)
+	if err := c.Connect(b); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+}
+
+func TestClientsShareEdits(t *testing.T) {
+	// Given two clients have joined an embedded server
+	var s shed.Server
+	remote := make(chan shed.Operation, 1)
+	acked := make(chan struct{}, 1)
+	joined := make(chan []shed.Participant, 4)
+	alice := &shed.Client{Name: "alice", OnAck: func() { acked <- struct{}{} }}
+	bob := &shed.Client{
+		Name:           "bob",
+		OnRemote:       func(op shed.Operation) { remote <- op },
+		OnParticipants: func(ps []shed.Participant) { joined <- ps },
+	}
+	connect(t, &s, bob)
+	<-joined
+	connect(t, &s, alice)
+	if ps := <-joined; len(ps) != 2 {
+		t.Fatalf("Bob sees participants %v, expected two", ps)
+	}
+
+	// When one submits a change
+	op := shed.Insertion{Pos: 0, Text: "hello"}
+	if err := alice.Submit(op); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then it should be acknowledged and reach the other
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Fatal("Alice's change was not acknowledged")
+	}
+	select {
+	case got := <-remote:
+		if got != op {
+			t.Errorf("Bob got %#v, expected %#v", got, op)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bob did not receive Alice's change")
+	}
+	if alice.Text() != "hello" || bob.Text() != "hello" {
+		t.Errorf("documents differ: %q and %q", alice.Text(), bob.Text())
+	}
+}
+
+func TestClientsSubmitWhileReceiving(t *testing.T) {
+	// Given two clients have joined an embedded server
+	var s shed.Server
+	alice, bob := &shed.Client{Name: "alice"}, &shed.Client{Name: "bob"}
+	connect(t, &s, alice)
+	connect(t, &s, bob)
+
+	// When both submit changes as fast as they can, one typing at the start
+	// and the other at the end
+	const n = 200
+	done := make(chan error, 2)
+	for _, c := range []*shed.Client{alice, bob} {
+		go func() {
+			for range n {
+				err := c.Edit(func(text string) []shed.Operation {
+					if c == alice {
+						return []shed.Operation{shed.Insertion{Pos: 0, Text: "a"}}
+					}
+					return []shed.Operation{shed.Insertion{Pos: uint(len(text)), Text: "b"}}
+				})
+				if err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+
+	// Then neither should get stuck waiting for the other's changes
+	for range 2 {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("gave up waiting for the changes to be submitted")
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(alice.Text()) != 2*n || len(bob.Text()) != 2*n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("documents hold %d and %d bytes, expected %d", len(alice.Text()), len(bob.Text()), 2*n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Then both should end up with the same text
+	if alice.Text() != bob.Text() {
+		t.Errorf("documents differ: %q and %q", alice.Text(), bob.Text())
+	}
+}
+
+func TestClientRejectsInvalidChanges(t *testing.T) {
+	var s shed.Server
+	c := new(shed.Client)
+	connect(t, &s, c)
+
+	if err := c.Submit(shed.Deletion{Pos: 0, Len: 1}); !errors.Is(err, shed.OutOfRangeError) {
+		t.Errorf("expected OutOfRangeError, got %v", err)
+	}
+}
+
+func TestClientUndoesRejectedChanges(t *testing.T) {
+	// Given two clients share a document that can't grow any longer
+	s := shed.Server{Limits: shed.Limits{MaxDocumentSize: 5}}
+	acked := make(chan struct{}, 2)
+	rejected := make(chan error, 1)
+	undone := make(chan shed.Operation, 1)
+	alice := &shed.Client{
+		Name:     "alice",
+		OnAck:    func() { acked <- struct{}{} },
+		OnError:  func(err error) { rejected <- err },
+		OnRemote: func(op shed.Operation) { undone <- op },
+	}
+	bob := &shed.Client{Name: "bob"}
+	connect(t, &s, alice)
+	connect(t, &s, bob)
+	if err := alice.Submit(shed.Insertion{Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+
+	// When one makes it too long, then changes it again
+	if err := alice.Submit(shed.Insertion{Pos: 5, Text: " world"}); err != nil {
+		t.Fatal(err)
+	}
+	var e shed.Error
+	if err := <-rejected; !errors.As(err, &e) || e.Code != "document_too_large" {
+		t.Fatalf("OnError got %v, expected the document to be too large", err)
+	}
+	if err := alice.Submit(shed.Deletion{Pos: 0, Len: 1}); err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+
+	// Then the rejected change should be undone before the next is made
+	select {
+	case op := <-undone:
+		if op != (shed.Deletion{Pos: 5, Len: 6}) {
+			t.Errorf("OnRemote got %#v, expected the rejected change undone", op)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the rejected change was not undone")
+	}
+	for deadline := time.Now().Add(5 * time.Second); bob.Text() != "ello"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Bob has %q, expected %q", bob.Text(), "ello")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if alice.Text() != bob.Text() {
+		t.Errorf("documents differ: %q and %q", alice.Text(), bob.Text())
+	}
+}
+
+func TestServerRequiresToken(t *testing.T) {
+	key := []byte("key")
+	s := shed.Server{TokenKey: key}
+	errs := make(chan error, 1)
+
+	c := &shed.Client{Document: "notes", Token: "forged", OnError: func(err error) { errs <- err }}
+	a, b := shed.Pipe()
+	s.Accept(a)
+	var e shed.Error
+	if err := c.Connect(b); !errors.As(err, &e) || e.Code != "unauthorized" {
+		t.Errorf("Connect returned %v, expected an unauthorized error", err)
+	}
+	if err := <-errs; !errors.As(err, &e) || e.Code != "unauthorized" {
+		t.Errorf("got %v, expected an unauthorized error", err)
+	}
+
+	roster := make(chan []shed.Participant, 1)
+	token := shed.IssueToken(key, "alice", []string{"notes"}, shed.Viewer, time.Time{})
+	c = &shed.Client{Document: "notes", Token: token, OnParticipants: func(ps []shed.Participant) { roster <- ps }}
+	connect(t, &s, c)
+	if ps := <-roster; len(ps) != 1 || ps[0].Name != "alice" || ps[0].Role != shed.Viewer {
+		t.Errorf("got participants %+v, expected alice as a viewer", ps)
+	}
+}
+
+func TestClientLoadsDocument(t *testing.T) {
+	// Given one client has edited the document
+	var s shed.Server
+	acked := make(chan struct{}, 1)
+	alice := &shed.Client{Name: "alice", OnAck: func() { acked <- struct{}{} }}
+	connect(t, &s, alice)
+	if err := alice.Submit(shed.Insertion{Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+
+	// When another client connects
+	bob := &shed.Client{Name: "bob"}
+	connect(t, &s, bob)
+
+	// Then it should start with the edited document
+	if got := bob.Text(); got != "hello" {
+		t.Errorf("Bob's document is %q, expected %q", got, "hello")
+	}
+}
+
+func TestClientFetchesPastRevisions(t *testing.T) {
+	// Given a client has made two changes
+	var s shed.Server
+	acked := make(chan struct{}, 1)
+	alice := &shed.Client{Name: "alice", OnAck: func() { acked <- struct{}{} }}
+	connect(t, &s, alice)
+	for _, op := range []shed.Operation{shed.Insertion{Text: "hello"}, shed.Insertion{Pos: 5, Text: "!"}} {
+		if err := alice.Submit(op); err != nil {
+			t.Fatal(err)
+		}
+		<-acked
+	}
+
+	// When it fetches the history and the first revision
+	changes, rev, err := alice.History(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := alice.Revision(1)
+
+	// Then both changes should be listed and the revision should hold the first
+	if len(changes) != 2 || rev != 2 || changes[0].Author != "alice" {
+		t.Errorf("History returned %v at revision %d", changes, rev)
+	}
+	if err != nil || r.Text != "hello" {
+		t.Errorf("Revision returned %+v, %v, expected %q", r, err, "hello")
+	}
+
+	// When it fetches a revision that does not exist
+	// Then it should be refused
+	if _, err := alice.Revision(3); err == nil {
+		t.Error("Revision(3) succeeded, expected an error")
+	}
+}
+
+func TestClientRevertsToCheckpoints(t *testing.T) {
+	// Given a client checkpointed the document and then changed it
+	var s shed.Server
+	acked := make(chan struct{}, 1)
+	alice := &shed.Client{Name: "alice", OnAck: func() { acked <- struct{}{} }}
+	connect(t, &s, alice)
+	if err := alice.Submit(shed.Insertion{Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+	if _, err := alice.Checkpoint("greeting"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Submit(shed.Deletion{Pos: 0, Len: 5}); err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+
+	// When it reverts to the checkpoint
+	rev, err := alice.RevertTo("greeting")
+
+	// Then its copy of the document should be restored, as a new revision
+	if err != nil || rev != 3 || alice.Text() != "hello" {
+		t.Errorf("RevertTo returned %d, %v with %q, expected 3 with %q", rev, err, alice.Text(), "hello")
+	}
+
+	// When the document is emptied again and can no longer grow that long
+	if err := alice.Submit(shed.Deletion{Pos: 0, Len: 5}); err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+	s.Limits.MaxDocumentSize = 3
+	s.Reload()
+
+	// Then reverting should fail with the server's reason
+	var e shed.Error
+	if _, err := alice.Revert(3); !errors.As(err, &e) || e.Code != "document_too_large" {
+		t.Errorf("Revert returned %v, expected the document to be too large", err)
+	}
+}
+
+func TestClientEditsWholeText(t *testing.T) {
+	// Given two clients share a document
+	var s shed.Server
+	acked := make(chan struct{}, 2)
+	remote := make(chan struct{}, 2)
+	alice := &shed.Client{Name: "alice", OnAck: func() { acked <- struct{}{} }}
+	bob := &shed.Client{Name: "bob", OnRemote: func(shed.Operation) { remote <- struct{}{} }}
+	connect(t, &s, alice)
+	connect(t, &s, bob)
+	if err := alice.Submit(shed.Insertion{Text: "the cat sat"}); err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+	<-remote
+
+	// When one replaces the text with a new version
+	err := alice.Edit(func(text string) []shed.Operation {
+		return shed.Diff(text, "the dog sat")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-acked
+	<-acked
+	<-remote
+	<-remote
+
+	// Then both should have the new version
+	if alice.Text() != "the dog sat" || bob.Text() != "the dog sat" {
+		t.Errorf("Alice has %q and Bob %q, expected %q", alice.Text(), bob.Text(), "the dog sat")
+	}
+}
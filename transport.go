@@ -0,0 +1,38 @@
+package shed
+
+import (
+	"io"
+
+	"github.com/shed-protocol/shed/internal/comms"
+)
+
+// A Transport carries protocol messages between a client and a server.
+type Transport = comms.Transport
+
+// A Peer identifies the remote end of a Transport.
+type Peer = comms.Peer
+
+// A Message is a single protocol message.
+type Message = comms.Message
+
+// Dial connects to a server at a TCP address, or at a Unix domain socket
+// given as unix:/path/to/socket.
+func Dial(addr string) (Transport, error) {
+	conn, err := comms.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return comms.NewStream(conn), nil
+}
+
+// NewStream returns a Transport that frames messages on a byte stream, such as
+// a network connection.
+func NewStream(rw io.ReadWriter) Transport {
+	return comms.NewStream(rw)
+}
+
+// Pipe returns two connected in-memory transports, for running a client and
+// server in the same program.
+func Pipe() (Transport, Transport) {
+	return comms.Pipe()
+}